@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/config"
+)
+
+// checkReport is the outcome of checking one server, as printed by the
+// check command
+type checkReport struct {
+	EntityID        string       `json:"entity_id"`
+	Organization    string       `json:"organization,omitempty"`
+	OrganizationID  string       `json:"organization_id,omitempty"`
+	BaseURI         string       `json:"base_uri"`
+	Healthy         bool         `json:"healthy"`
+	Error           string       `json:"error,omitempty"`
+	CertCN          string       `json:"cert_cn,omitempty"`
+	CertExpires     *time.Time   `json:"cert_expires,omitempty"`
+	CertFingerprint string       `json:"cert_fingerprint,omitempty"`
+	HandshakeMs     int64        `json:"handshake_ms,omitempty"`
+	Pins            []fedtls.Pin `json:"pins"`
+	CheckedAt       time.Time    `json:"checked_at"`
+}
+
+// runCheck checks the selected servers once and prints a report. Returns
+// the process exit code: 0 if all servers are healthy, 1 if any is
+// unhealthy and 2 on usage or configuration errors.
+func runCheck(args []string) int {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file")
+	entityID := flags.String("entity", "", "Entity ID of the servers to check")
+	baseURI := flags.String("base-uri", "", "Base URI of the server to check")
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	fetch := flags.Bool("fetch", false, "Download metadata instead of using the cache file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: matfmonitor check [flags]\n\nChecks servers in metadata once. At least one of -entity and -base-uri is required.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *entityID == "" && *baseURI == "" {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 2
+	}
+
+	metadata, err := loadMetadata(cfg, *fetch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load metadata: %v\n", err)
+		return 2
+	}
+
+	healthChecker := checker.NewRealChecker(cfg.TLSTimeout)
+	var reports []checkReport
+	for _, entity := range metadata.Entities {
+		if *entityID != "" && entity.EntityID != *entityID {
+			continue
+		}
+		for _, server := range entity.Servers {
+			if *baseURI != "" && server.BaseURI != *baseURI {
+				continue
+			}
+			reports = append(reports, checkOnce(healthChecker, entity, server))
+		}
+	}
+
+	if len(reports) == 0 {
+		fmt.Fprintf(os.Stderr, "No matching server found in metadata\n")
+		return 2
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(reports)
+	} else {
+		for i := range reports {
+			if i > 0 {
+				fmt.Println()
+			}
+			printCheckReport(os.Stdout, &reports[i])
+		}
+	}
+
+	for _, r := range reports {
+		if !r.Healthy {
+			return 1
+		}
+	}
+	return 0
+}
+
+func checkOnce(healthChecker checker.Checker, entity fedtls.Entity, server fedtls.Server) checkReport {
+	result := healthChecker.Check(entity.EntityID, server)
+	r := checkReport{
+		EntityID:        entity.EntityID,
+		BaseURI:         server.BaseURI,
+		Healthy:         result.IsHealthy,
+		Error:           result.ErrorMessage,
+		CertCN:          result.CertCN,
+		CertExpires:     result.CertExpires,
+		CertFingerprint: result.CertFingerprint,
+		HandshakeMs:     result.HandshakeDuration.Milliseconds(),
+		Pins:            server.Pins,
+		CheckedAt:       result.CheckedAt,
+	}
+	if entity.Organization != nil {
+		r.Organization = *entity.Organization
+	}
+	if entity.OrganizationID != nil {
+		r.OrganizationID = *entity.OrganizationID
+	}
+	return r
+}
+
+func printCheckReport(w io.Writer, r *checkReport) {
+	status := "HEALTHY"
+	if !r.Healthy {
+		status = "UNHEALTHY"
+	}
+	fmt.Fprintf(w, "Server:       %s\n", r.BaseURI)
+	fmt.Fprintf(w, "Entity:       %s\n", r.EntityID)
+	if r.Organization != "" || r.OrganizationID != "" {
+		fmt.Fprintf(w, "Organization: %s %s\n", r.Organization, r.OrganizationID)
+	}
+	fmt.Fprintf(w, "Status:       %s\n", status)
+	if r.Error != "" {
+		fmt.Fprintf(w, "Error:        %s\n", r.Error)
+	}
+	if r.CertCN != "" {
+		fmt.Fprintf(w, "Cert CN:      %s\n", r.CertCN)
+	}
+	if r.CertExpires != nil {
+		fmt.Fprintf(w, "Cert expires: %s (%d days)\n", r.CertExpires.Format("2006-01-02 15:04:05 MST"), int(time.Until(*r.CertExpires).Hours()/24))
+	}
+	if r.CertFingerprint != "" {
+		fmt.Fprintf(w, "Cert pin:     sha256 %s\n", r.CertFingerprint)
+	}
+	if r.HandshakeMs > 0 {
+		fmt.Fprintf(w, "Handshake:    %d ms\n", r.HandshakeMs)
+	}
+	if len(r.Pins) == 0 {
+		fmt.Fprintf(w, "Metadata pins: none\n")
+	}
+	for i, pin := range r.Pins {
+		label := ""
+		if i == 0 {
+			label = "Metadata pins:"
+		}
+		match := ""
+		if pin.Alg == checker.PinAlg && pin.Digest == r.CertFingerprint {
+			match = " (matches)"
+		}
+		fmt.Fprintf(w, "%-14s %s %s%s\n", label, pin.Alg, pin.Digest, match)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/joesiltberg/matfmonitor/internal/config"
+	"github.com/joesiltberg/matfmonitor/internal/lint"
+)
+
+// runLint checks the metadata for problems and prints them. Returns 0 if no
+// errors were found (warnings are allowed), 1 if there were errors and 2 on
+// usage or configuration errors.
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file")
+	jsonOutput := flags.Bool("json", false, "Print the issues as JSON")
+	fetch := flags.Bool("fetch", false, "Download metadata instead of using the cache file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: matfmonitor lint [flags]\n\nChecks the federation metadata for problems such as invalid base URIs,\nmissing pins and unsupported pin algorithms.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 2
+	}
+
+	metadata, err := loadMetadata(cfg, *fetch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load metadata: %v\n", err)
+		return 2
+	}
+
+	issues := lint.Lint(metadata)
+	if *jsonOutput {
+		if issues == nil {
+			issues = []lint.Issue{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(issues)
+	} else {
+		printLintIssues(os.Stdout, issues)
+	}
+
+	if lint.CountErrors(issues) > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printLintIssues(w io.Writer, issues []lint.Issue) {
+	for _, issue := range issues {
+		location := issue.EntityID
+		if issue.BaseURI != "" {
+			location += " " + issue.BaseURI
+		}
+		fmt.Fprintf(w, "%-7s %s: %s [%s]\n", issue.Severity, location, issue.Message, issue.Code)
+	}
+	errors := lint.CountErrors(issues)
+	fmt.Fprintf(w, "%d errors, %d warnings\n", errors, len(issues)-errors)
+}
@@ -1,117 +1,54 @@
 package main
 
 import (
-	"context"
-	"flag"
 	"fmt"
-	"log"
-	"net/http"
 	"os"
-	"os/signal"
-	"syscall"
-	"time"
-
-	"github.com/joesiltberg/bowness/fedtls"
-	"github.com/joesiltberg/matfmonitor/internal/checker"
-	"github.com/joesiltberg/matfmonitor/internal/config"
-	"github.com/joesiltberg/matfmonitor/internal/store"
-	"github.com/joesiltberg/matfmonitor/internal/web"
+	"strings"
 )
 
-func main() {
-	configPath := flag.String("config", "config.yaml", "Path to configuration file")
-	flag.Parse()
-
-	// Load configuration
-	cfg, err := config.Load(*configPath)
-	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
-	}
-
-	log.Printf("Starting matfmonitor...")
-	log.Printf("Metadata URL: %s", cfg.MetadataURL)
-	log.Printf("Listen address: %s", cfg.ListenAddress)
+func usage() {
+	fmt.Fprintf(os.Stderr, `Usage: matfmonitor [command] [flags]
 
-	// Initialize store
-	dataStore, err := store.New(cfg.DatabasePath)
-	if err != nil {
-		log.Fatalf("Failed to initialize database: %v", err)
-	}
-	defer dataStore.Close()
+Commands:
+  serve     Run the monitoring service (default)
+  check     Check one or more servers once and print a report
+  nagios    Nagios/Icinga compatible plugin for a server, entity or the federation
+  lint      Check the federation metadata for problems
+  validate  Check candidate metadata before it is published
 
-	// Initialize metadata store
-	metadataStore := fedtls.NewMetadataStore(
-		cfg.MetadataURL,
-		cfg.JWKSPath,
-		cfg.CachePath,
-	)
-
-	// Initialize health checker and scheduler
-	healthChecker := checker.NewRealChecker(cfg.TLSTimeout)
-	scheduler := checker.NewScheduler(
-		healthChecker,
-		dataStore,
-		metadataStore,
-		cfg.MaxParallelChecks,
-		cfg.ChecksPerMinute,
-		cfg.MinCheckInterval,
-		cfg.PriorityMinInterval,
-		cfg.MaxPriorityServers,
-	)
-
-	// Initialize web handler
-	// Refresh interval = time for one check cycle + 1 second buffer
-	refreshInterval := time.Duration(60/cfg.ChecksPerMinute)*time.Second + time.Second
-	webHandler, err := web.NewHandler(dataStore, metadataStore, scheduler, cfg.PriorityMinInterval, refreshInterval)
-	if err != nil {
-		log.Fatalf("Failed to initialize web handler: %v", err)
-	}
-
-	// Set up HTTP server
-	server := &http.Server{
-		Addr:         cfg.ListenAddress,
-		Handler:      webHandler,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  60 * time.Second,
-	}
-
-	// Start scheduler
-	scheduler.Start()
-	log.Printf("Health check scheduler started (max %d parallel, %d/min, interval %v)",
-		cfg.MaxParallelChecks, cfg.ChecksPerMinute, cfg.MinCheckInterval)
+Run "matfmonitor <command> -h" for the flags of a command.
+`)
+}
 
-	// Start HTTP server in goroutine
-	go func() {
-		log.Printf("Web server listening on %s", cfg.ListenAddress)
-		if err := server.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("HTTP server error: %v", err)
+func main() {
+	// Without a command (or with only flags) the service is started, as
+	// in earlier versions
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		if len(os.Args) == 2 && (os.Args[1] == "-h" || os.Args[1] == "-help" || os.Args[1] == "--help") {
+			usage()
+			os.Exit(0)
 		}
-	}()
-
-	// Wait for shutdown signal
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
-	sig := <-sigChan
-
-	log.Printf("Received signal %v, shutting down...", sig)
-
-	// Graceful shutdown
-	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer shutdownCancel()
-
-	// Stop accepting new requests
-	if err := server.Shutdown(shutdownCtx); err != nil {
-		log.Printf("HTTP server shutdown error: %v", err)
+		runServe(os.Args[1:])
+		return
 	}
 
-	// Stop scheduler (waits for in-progress checks)
-	scheduler.Stop()
-	log.Printf("Scheduler stopped")
-
-	// Stop metadata store
-	metadataStore.Quit()
-	log.Printf("Metadata store stopped")
-
-	fmt.Println("Shutdown complete")
+	command, args := os.Args[1], os.Args[2:]
+	switch command {
+	case "serve":
+		runServe(args)
+	case "check":
+		os.Exit(runCheck(args))
+	case "nagios":
+		os.Exit(runNagios(args))
+	case "lint":
+		os.Exit(runLint(args))
+	case "validate":
+		os.Exit(runValidate(args))
+	case "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", command)
+		usage()
+		os.Exit(2)
+	}
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/config"
+)
+
+// loadMetadata reads and verifies the metadata from the cache file, falling
+// back to downloading it from the federation if the cache is missing or
+// can't be verified. With fetch set the cache is not used.
+func loadMetadata(cfg *config.Config, fetch bool) (*fedtls.Metadata, error) {
+	jwks, err := os.ReadFile(cfg.JWKSPath)
+	if err != nil {
+		return nil, fmt.Errorf("reading JWKS file: %w", err)
+	}
+
+	if !fetch {
+		content, err := os.ReadFile(cfg.CachePath)
+		if err == nil {
+			metadata, err := fedtls.Verify(content, jwks)
+			if err == nil {
+				return metadata, nil
+			}
+			log.Printf("Failed to verify cached metadata, downloading: %v", err)
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("reading metadata cache: %w", err)
+		}
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(cfg.MetadataURL)
+	if err != nil {
+		return nil, fmt.Errorf("downloading metadata: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("downloading metadata: unexpected status %s", resp.Status)
+	}
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("downloading metadata: %w", err)
+	}
+
+	metadata, err := fedtls.Verify(content, jwks)
+	if err != nil {
+		return nil, fmt.Errorf("verifying metadata: %w", err)
+	}
+	return metadata, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/config"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// Monitoring plugin exit codes
+const (
+	pluginOK       = 0
+	pluginWarning  = 1
+	pluginCritical = 2
+	pluginUnknown  = 3
+)
+
+var pluginStateNames = []string{"OK", "WARNING", "CRITICAL", "UNKNOWN"}
+
+// pluginThresholds are the limits used to grade server statuses
+type pluginThresholds struct {
+	certWarning    time.Duration
+	certCritical   time.Duration
+	maxAge         time.Duration
+	warnUnhealthy  int
+	critUnhealthy  int
+	federationMode bool
+}
+
+// runNagios prints a monitoring plugin status line for a server, an entity
+// or the whole federation and returns the plugin exit code
+func runNagios(args []string) int {
+	// Exit status 2 would be read as CRITICAL, usage errors are UNKNOWN
+	flags := flag.NewFlagSet("nagios", flag.ContinueOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file")
+	entityID := flags.String("entity", "", "Entity ID, limits the check to the entity's servers")
+	baseURI := flags.String("base-uri", "", "Base URI, limits the check to one server")
+	live := flags.Bool("live", false, "Check the servers now instead of reading the database")
+	certWarningDays := flags.Int("cert-warning-days", 30, "WARNING when a certificate expires within this many days")
+	certCriticalDays := flags.Int("cert-critical-days", 7, "CRITICAL when a certificate expires within this many days")
+	maxAge := flags.Duration("max-age", 0, "WARNING when a server hasn't been checked for this long (default twice minCheckInterval)")
+	warnUnhealthy := flags.Int("warning-unhealthy", 1, "Federation mode: WARNING when at least this many servers are unhealthy")
+	critUnhealthy := flags.Int("critical-unhealthy", 10, "Federation mode: CRITICAL when at least this many servers are unhealthy")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: matfmonitor nagios [flags]\n\nPrints a Nagios/Icinga compatible status line. Without -entity and -base-uri\nthe whole federation is checked.\n\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return pluginUnknown
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Printf("MATFMONITOR UNKNOWN - failed to load configuration: %v\n", err)
+		return pluginUnknown
+	}
+
+	thresholds := pluginThresholds{
+		certWarning:    time.Duration(*certWarningDays) * 24 * time.Hour,
+		certCritical:   time.Duration(*certCriticalDays) * 24 * time.Hour,
+		maxAge:         *maxAge,
+		warnUnhealthy:  *warnUnhealthy,
+		critUnhealthy:  *critUnhealthy,
+		federationMode: *entityID == "" && *baseURI == "",
+	}
+	if thresholds.maxAge == 0 {
+		thresholds.maxAge = 2 * cfg.MinCheckInterval
+	}
+
+	var statuses []*store.ServerStatus
+	if *live {
+		statuses, err = liveStatuses(cfg, *entityID, *baseURI)
+	} else {
+		statuses, err = storedStatuses(cfg, *entityID, *baseURI)
+	}
+	if err != nil {
+		fmt.Printf("MATFMONITOR UNKNOWN - %v\n", err)
+		return pluginUnknown
+	}
+	if len(statuses) == 0 {
+		fmt.Printf("MATFMONITOR UNKNOWN - no matching server found\n")
+		return pluginUnknown
+	}
+
+	return printPluginResult(os.Stdout, statuses, thresholds, time.Now())
+}
+
+// storedStatuses reads the selected servers' statuses from the database
+func storedStatuses(cfg *config.Config, entityID, baseURI string) ([]*store.ServerStatus, error) {
+	if _, err := os.Stat(cfg.DatabasePath); err != nil {
+		return nil, fmt.Errorf("database not available: %v", err)
+	}
+	// The plugin only reads, and mustn't change the schema under the service
+	dataStore, err := store.OpenReadOnly(cfg.DatabasePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	defer dataStore.Close()
+
+	all, err := dataStore.GetAllStatuses()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read database: %v", err)
+	}
+	var statuses []*store.ServerStatus
+	for _, s := range all {
+		if (entityID == "" || s.EntityID == entityID) && (baseURI == "" || s.BaseURI == baseURI) {
+			statuses = append(statuses, s)
+		}
+	}
+	return statuses, nil
+}
+
+// liveStatuses checks the selected servers now
+func liveStatuses(cfg *config.Config, entityID, baseURI string) ([]*store.ServerStatus, error) {
+	metadata, err := loadMetadata(cfg, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %v", err)
+	}
+
+	healthChecker := checker.NewRealChecker(cfg.TLSTimeout)
+	var statuses []*store.ServerStatus
+	for _, entity := range metadata.Entities {
+		if entityID != "" && entity.EntityID != entityID {
+			continue
+		}
+		for _, server := range entity.Servers {
+			if baseURI != "" && server.BaseURI != baseURI {
+				continue
+			}
+			result := healthChecker.Check(entity.EntityID, server)
+			statuses = append(statuses, &store.ServerStatus{
+				ServerKey:       store.ServerKey{EntityID: result.EntityID, BaseURI: result.BaseURI},
+				LastChecked:     &result.CheckedAt,
+				IsHealthy:       &result.IsHealthy,
+				ErrorMessage:    result.ErrorMessage,
+				CertExpires:     result.CertExpires,
+				CertCN:          result.CertCN,
+				CertFingerprint: result.CertFingerprint,
+			})
+		}
+	}
+	return statuses, nil
+}
+
+// printPluginResult grades the statuses, prints the status line with
+// performance data followed by one line per problem, and returns the exit code
+func printPluginResult(w io.Writer, statuses []*store.ServerStatus, t pluginThresholds, now time.Time) int {
+	state := pluginOK
+	raise := func(s int) {
+		// UNKNOWN only wins over OK
+		if s == pluginUnknown && state != pluginOK {
+			return
+		}
+		if s > state || state == pluginUnknown {
+			state = s
+		}
+	}
+
+	var healthy, unhealthy, unchecked, stale int
+	var problems []string
+	var minCertDays *int
+
+	for _, s := range statuses {
+		switch {
+		case s.IsHealthy == nil || s.LastChecked == nil:
+			unchecked++
+			problems = append(problems, fmt.Sprintf("%s: not yet checked", s.BaseURI))
+			if !t.federationMode {
+				raise(pluginUnknown)
+			}
+			continue
+		case !*s.IsHealthy:
+			unhealthy++
+			problems = append(problems, fmt.Sprintf("%s: %s", s.BaseURI, s.ErrorMessage))
+			if !t.federationMode {
+				raise(pluginCritical)
+			}
+		default:
+			healthy++
+		}
+
+		if age := now.Sub(*s.LastChecked); t.maxAge > 0 && age > t.maxAge {
+			stale++
+			problems = append(problems, fmt.Sprintf("%s: last checked %s ago", s.BaseURI, age.Truncate(time.Minute)))
+			raise(pluginWarning)
+		}
+
+		if s.CertExpires != nil {
+			remaining := s.CertExpires.Sub(now)
+			days := int(remaining.Hours() / 24)
+			if minCertDays == nil || days < *minCertDays {
+				minCertDays = &days
+			}
+			if remaining <= t.certCritical {
+				problems = append(problems, fmt.Sprintf("%s: certificate expires in %d days", s.BaseURI, days))
+				raise(pluginCritical)
+			} else if remaining <= t.certWarning {
+				problems = append(problems, fmt.Sprintf("%s: certificate expires in %d days", s.BaseURI, days))
+				raise(pluginWarning)
+			}
+		}
+	}
+
+	if t.federationMode {
+		if t.critUnhealthy > 0 && unhealthy >= t.critUnhealthy {
+			raise(pluginCritical)
+		} else if t.warnUnhealthy > 0 && unhealthy >= t.warnUnhealthy {
+			raise(pluginWarning)
+		}
+	}
+
+	summary := fmt.Sprintf("%d healthy, %d unhealthy, %d not checked", healthy, unhealthy, unchecked)
+	if len(statuses) == 1 {
+		s := statuses[0]
+		switch {
+		case s.IsHealthy == nil:
+			summary = s.BaseURI + " not yet checked"
+		case *s.IsHealthy:
+			summary = s.BaseURI + " is healthy"
+		default:
+			summary = s.BaseURI + ": " + s.ErrorMessage
+		}
+	}
+
+	unhealthyPerf := fmt.Sprintf("unhealthy=%d", unhealthy)
+	if t.federationMode {
+		unhealthyPerf += fmt.Sprintf(";%d;%d", t.warnUnhealthy, t.critUnhealthy)
+	}
+	perfdata := []string{
+		fmt.Sprintf("healthy=%d", healthy),
+		unhealthyPerf,
+		fmt.Sprintf("unchecked=%d", unchecked),
+		fmt.Sprintf("stale=%d", stale),
+	}
+	if minCertDays != nil {
+		perfdata = append(perfdata, fmt.Sprintf("cert_days_min=%d;%d;%d",
+			*minCertDays, int(t.certWarning.Hours()/24), int(t.certCritical.Hours()/24)))
+	}
+
+	fmt.Fprintf(w, "MATFMONITOR %s - %s | %s\n", pluginStateNames[state], summary, strings.Join(perfdata, " "))
+	if len(statuses) > 1 {
+		sort.Strings(problems)
+		for _, p := range problems {
+			fmt.Fprintln(w, p)
+		}
+	}
+	return state
+}
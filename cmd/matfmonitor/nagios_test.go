@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func TestPrintPluginResult(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	days := func(n int) time.Duration { return time.Duration(n) * 24 * time.Hour }
+
+	// status returns a server checked checkedAgo before now, nil healthy
+	// for a server never checked and zero expiresIn for no certificate
+	status := func(baseURI string, healthy *bool, errorMessage string, checkedAgo, expiresIn time.Duration) *store.ServerStatus {
+		s := &store.ServerStatus{
+			ServerKey:    store.ServerKey{EntityID: "https://example.com", BaseURI: baseURI},
+			IsHealthy:    healthy,
+			ErrorMessage: errorMessage,
+		}
+		if healthy != nil {
+			checked := now.Add(-checkedAgo)
+			s.LastChecked = &checked
+		}
+		if expiresIn != 0 {
+			expires := now.Add(expiresIn)
+			s.CertExpires = &expires
+		}
+		return s
+	}
+	yes, no := true, false
+
+	server := pluginThresholds{certWarning: days(30), certCritical: days(7), maxAge: time.Hour}
+	federation := server
+	federation.federationMode = true
+	federation.warnUnhealthy = 1
+	federation.critUnhealthy = 2
+
+	tests := []struct {
+		name       string
+		statuses   []*store.ServerStatus
+		thresholds pluginThresholds
+		wantCode   int
+		wantOutput string
+	}{
+		{
+			name:       "healthy",
+			statuses:   []*store.ServerStatus{status("https://a/", &yes, "", time.Minute, days(92))},
+			thresholds: server,
+			wantCode:   pluginOK,
+			wantOutput: "MATFMONITOR OK - https://a/ is healthy | healthy=1 unhealthy=0 unchecked=0 stale=0 cert_days_min=92;30;7\n",
+		},
+		{
+			name:       "certificate within warning",
+			statuses:   []*store.ServerStatus{status("https://a/", &yes, "", time.Minute, days(20))},
+			thresholds: server,
+			wantCode:   pluginWarning,
+			wantOutput: "MATFMONITOR WARNING - https://a/ is healthy | healthy=1 unhealthy=0 unchecked=0 stale=0 cert_days_min=20;30;7\n",
+		},
+		{
+			name:       "certificate at warning threshold",
+			statuses:   []*store.ServerStatus{status("https://a/", &yes, "", time.Minute, days(30))},
+			thresholds: server,
+			wantCode:   pluginWarning,
+			wantOutput: "MATFMONITOR WARNING - https://a/ is healthy | healthy=1 unhealthy=0 unchecked=0 stale=0 cert_days_min=30;30;7\n",
+		},
+		{
+			name:       "certificate within critical",
+			statuses:   []*store.ServerStatus{status("https://a/", &yes, "", time.Minute, days(5))},
+			thresholds: server,
+			wantCode:   pluginCritical,
+			wantOutput: "MATFMONITOR CRITICAL - https://a/ is healthy | healthy=1 unhealthy=0 unchecked=0 stale=0 cert_days_min=5;30;7\n",
+		},
+		{
+			name:       "certificate at critical threshold",
+			statuses:   []*store.ServerStatus{status("https://a/", &yes, "", time.Minute, days(7))},
+			thresholds: server,
+			wantCode:   pluginCritical,
+			wantOutput: "MATFMONITOR CRITICAL - https://a/ is healthy | healthy=1 unhealthy=0 unchecked=0 stale=0 cert_days_min=7;30;7\n",
+		},
+		{
+			name:       "unhealthy",
+			statuses:   []*store.ServerStatus{status("https://a/", &no, "connection refused", time.Minute, 0)},
+			thresholds: server,
+			wantCode:   pluginCritical,
+			wantOutput: "MATFMONITOR CRITICAL - https://a/: connection refused | healthy=0 unhealthy=1 unchecked=0 stale=0\n",
+		},
+		{
+			name:       "not yet checked",
+			statuses:   []*store.ServerStatus{status("https://a/", nil, "", 0, 0)},
+			thresholds: server,
+			wantCode:   pluginUnknown,
+			wantOutput: "MATFMONITOR UNKNOWN - https://a/ not yet checked | healthy=0 unhealthy=0 unchecked=1 stale=0\n",
+		},
+		{
+			name:       "stale",
+			statuses:   []*store.ServerStatus{status("https://a/", &yes, "", 2*time.Hour, 0)},
+			thresholds: server,
+			wantCode:   pluginWarning,
+			wantOutput: "MATFMONITOR WARNING - https://a/ is healthy | healthy=1 unhealthy=0 unchecked=0 stale=1\n",
+		},
+		{
+			name: "entity unhealthy wins over unchecked",
+			statuses: []*store.ServerStatus{
+				status("https://a/", nil, "", 0, 0),
+				status("https://b/", &no, "timeout", time.Minute, 0),
+			},
+			thresholds: server,
+			wantCode:   pluginCritical,
+			wantOutput: "MATFMONITOR CRITICAL - 0 healthy, 1 unhealthy, 1 not checked | healthy=0 unhealthy=1 unchecked=1 stale=0\n" +
+				"https://a/: not yet checked\n" +
+				"https://b/: timeout\n",
+		},
+		{
+			name: "entity unchecked doesn't replace unhealthy",
+			statuses: []*store.ServerStatus{
+				status("https://b/", &no, "timeout", time.Minute, 0),
+				status("https://a/", nil, "", 0, 0),
+			},
+			thresholds: server,
+			wantCode:   pluginCritical,
+			wantOutput: "MATFMONITOR CRITICAL - 0 healthy, 1 unhealthy, 1 not checked | healthy=0 unhealthy=1 unchecked=1 stale=0\n" +
+				"https://a/: not yet checked\n" +
+				"https://b/: timeout\n",
+		},
+		{
+			name: "federation ignores unchecked",
+			statuses: []*store.ServerStatus{
+				status("https://a/", &yes, "", time.Minute, days(60)),
+				status("https://b/", nil, "", 0, 0),
+			},
+			thresholds: federation,
+			wantCode:   pluginOK,
+			wantOutput: "MATFMONITOR OK - 1 healthy, 0 unhealthy, 1 not checked | healthy=1 unhealthy=0;1;2 unchecked=1 stale=0 cert_days_min=60;30;7\n" +
+				"https://b/: not yet checked\n",
+		},
+		{
+			name: "federation unhealthy warning",
+			statuses: []*store.ServerStatus{
+				status("https://a/", &yes, "", time.Minute, days(60)),
+				status("https://b/", &yes, "", time.Minute, days(90)),
+				status("https://c/", &no, "timeout", time.Minute, 0),
+			},
+			thresholds: federation,
+			wantCode:   pluginWarning,
+			wantOutput: "MATFMONITOR WARNING - 2 healthy, 1 unhealthy, 0 not checked | healthy=2 unhealthy=1;1;2 unchecked=0 stale=0 cert_days_min=60;30;7\n" +
+				"https://c/: timeout\n",
+		},
+		{
+			name: "federation unhealthy critical",
+			statuses: []*store.ServerStatus{
+				status("https://a/", &yes, "", time.Minute, days(60)),
+				status("https://b/", &no, "timeout", time.Minute, 0),
+				status("https://c/", &no, "bad certificate", time.Minute, days(3)),
+			},
+			thresholds: federation,
+			wantCode:   pluginCritical,
+			wantOutput: "MATFMONITOR CRITICAL - 1 healthy, 2 unhealthy, 0 not checked | healthy=1 unhealthy=2;1;2 unchecked=0 stale=0 cert_days_min=3;30;7\n" +
+				"https://b/: timeout\n" +
+				"https://c/: bad certificate\n" +
+				"https://c/: certificate expires in 3 days\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			code := printPluginResult(&out, tt.statuses, tt.thresholds, now)
+			if code != tt.wantCode {
+				t.Errorf("exit code = %d, want %d", code, tt.wantCode)
+			}
+			if out.String() != tt.wantOutput {
+				t.Errorf("output =\n%s\nwant\n%s", out.String(), tt.wantOutput)
+			}
+		})
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/alert"
+	"github.com/joesiltberg/matfmonitor/internal/archive"
+	"github.com/joesiltberg/matfmonitor/internal/candidate"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/config"
+	"github.com/joesiltberg/matfmonitor/internal/notify"
+	"github.com/joesiltberg/matfmonitor/internal/onboard"
+	"github.com/joesiltberg/matfmonitor/internal/report"
+	"github.com/joesiltberg/matfmonitor/internal/source"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+	"github.com/joesiltberg/matfmonitor/internal/web"
+)
+
+// runServe runs the monitoring service until interrupted
+func runServe(args []string) {
+	flags := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file")
+	flags.Parse(args)
+
+	// Load configuration
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	log.Printf("Starting matfmonitor...")
+	log.Printf("Metadata URL: %s", cfg.MetadataURL)
+	log.Printf("Listen address: %s", cfg.ListenAddress)
+
+	// Initialize store
+	dataStore, err := store.New(cfg.DatabasePath)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer dataStore.Close()
+
+	// Initialize metadata store
+	metadataStore := fedtls.NewMetadataStore(
+		cfg.MetadataURL,
+		cfg.JWKSPath,
+		cfg.CachePath,
+	)
+
+	// Monitor the metadata source itself
+	sourceMonitor := source.NewMonitor(
+		cfg.MetadataURL,
+		metadataStore,
+		cfg.JWKSPath,
+		cfg.CachePath,
+		cfg.MetadataCheckInterval,
+		cfg.MetadataMaxAge,
+	)
+
+	// Archive every metadata version
+	archiver := archive.NewArchiver(dataStore, metadataStore)
+
+	// Initialize health checker and scheduler
+	healthChecker := checker.NewRealChecker(cfg.TLSTimeout)
+	scheduler := checker.NewScheduler(
+		healthChecker,
+		dataStore,
+		metadataStore,
+		cfg.MaxParallelChecks,
+		cfg.ChecksPerMinute,
+		cfg.MinCheckInterval,
+		cfg.PriorityMinInterval,
+		cfg.MaxPriorityServers,
+		cfg.HistoryRetention,
+	)
+
+	// Initialize notifications
+	var webhooks []*notify.WebhookChannel
+	var channels []notify.Channel
+	for _, wh := range cfg.Webhooks {
+		channel := notify.NewWebhookChannel(wh.Name, wh.URL, wh.Secret, wh.Events, wh.MaxAttempts, cfg.TLSTimeout, dataStore)
+		webhooks = append(webhooks, channel)
+		channels = append(channels, channel)
+	}
+	outboxWorker := notify.NewOutboxWorker(dataStore, 15*time.Second, webhooks...)
+	var emailChannel *notify.EmailChannel
+	if cfg.Email != nil {
+		var recipients []notify.Recipient
+		for _, r := range cfg.Email.Recipients {
+			recipients = append(recipients, notify.Recipient{
+				EntityID:       r.EntityID,
+				OrganizationID: r.OrganizationID,
+				Addresses:      r.Addresses,
+				Language:       r.Language,
+			})
+		}
+		emailChannel = notify.NewEmailChannel(
+			net.JoinHostPort(cfg.Email.SMTPHost, strconv.Itoa(cfg.Email.SMTPPort)),
+			cfg.Email.Username,
+			cfg.Email.Password,
+			cfg.Email.From,
+			cfg.Email.Language,
+			recipients,
+			cfg.Email.Events,
+			cfg.Email.MaxPerHour,
+			cfg.Email.DigestInterval,
+		)
+		channels = append(channels, emailChannel)
+	}
+	notifier := notify.NewNotifier(metadataStore, cfg.CertExpiryWarning, channels...)
+	if len(channels) > 0 {
+		scheduler.AddObserver(notifier)
+		log.Printf("Notifications enabled for %d channel(s)", len(channels))
+	}
+
+	// Initialize alert rules
+	var alertEngine *alert.Engine
+	if len(cfg.AlertRules) > 0 {
+		var rules []alert.Rule
+		for _, r := range cfg.AlertRules {
+			rules = append(rules, alert.Rule{
+				Name:       r.Name,
+				Type:       alert.RuleType(r.Type),
+				Count:      r.Count,
+				Within:     r.Within,
+				Percentile: r.Percentile,
+				Threshold:  r.Threshold,
+				Window:     r.Window,
+				MaxAge:     r.MaxAge,
+				Channels:   r.Channels,
+			})
+		}
+		alertEngine = alert.NewEngine(rules, dataStore, metadataStore, cfg.CachePath, notifier)
+		scheduler.AddObserver(alertEngine)
+		log.Printf("Evaluating %d alert rule(s)", len(rules))
+	}
+
+	// Initialize digest reports
+	var reportScheduler *report.Scheduler
+	if cfg.Reports != nil && (cfg.Reports.Daily || cfg.Reports.Weekly) {
+		reportScheduler = report.NewScheduler(
+			dataStore,
+			metadataStore,
+			notifier,
+			cfg.Reports.Channels,
+			cfg.Reports.Daily,
+			cfg.Reports.Weekly,
+			cfg.Reports.TimeOfDay(),
+			cfg.Reports.WeekdayValue(),
+		)
+	}
+
+	// Initialize web handler
+	// Refresh interval = time for one check cycle + 1 second buffer
+	refreshInterval := time.Duration(60/cfg.ChecksPerMinute)*time.Second + time.Second
+	webHandler, err := web.NewHandler(dataStore, metadataStore, scheduler, cfg.PriorityMinInterval, refreshInterval)
+	if err != nil {
+		log.Fatalf("Failed to initialize web handler: %v", err)
+	}
+	webHandler.SetMetadataSource(sourceMonitor)
+	webHandler.SetStalePinAge(cfg.StalePinAge)
+	webHandler.SetCertExpiryWarning(cfg.CertExpiryWarning)
+	scheduler.AddObserver(webHandler)
+	if cfg.CandidateUpload {
+		jwks, err := os.ReadFile(cfg.JWKSPath)
+		if err != nil {
+			log.Fatalf("Failed to read JWKS file: %v", err)
+		}
+		webHandler.EnableCandidateUpload(candidate.NewValidator(healthChecker, jwks, cfg.MaxParallelChecks))
+		log.Printf("Candidate metadata upload enabled at /validate")
+	}
+	if cfg.ServerTest != nil {
+		webHandler.EnableServerTest(onboard.NewTester(
+			healthChecker,
+			cfg.ServerTest.Ports,
+			cfg.ServerTest.BlockedIPNets(),
+			cfg.ServerTest.BlockedHosts,
+			cfg.ServerTest.PerClientPerHour,
+			cfg.ServerTest.TotalPerHour,
+		))
+		log.Printf("Server test enabled at /server-test")
+	}
+
+	// Set up HTTP server
+	server := &http.Server{
+		Addr:         cfg.ListenAddress,
+		Handler:      webHandler,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+	server.RegisterOnShutdown(webHandler.CloseEvents)
+
+	// Set up the optional client certificate test server
+	var clientTestServer *http.Server
+	if cfg.ClientTest != nil {
+		clientTestHandler, err := web.NewClientTestHandler(metadataStore)
+		if err != nil {
+			log.Fatalf("Failed to initialize client test handler: %v", err)
+		}
+		clientTestServer = &http.Server{
+			Addr:    cfg.ClientTest.ListenAddress,
+			Handler: clientTestHandler,
+			TLSConfig: &tls.Config{
+				// Any certificate (or none) is accepted, the handler
+				// checks it against metadata and explains what is wrong
+				ClientAuth: tls.RequestClientCert,
+			},
+			ReadTimeout:  10 * time.Second,
+			WriteTimeout: 10 * time.Second,
+			IdleTimeout:  60 * time.Second,
+		}
+	}
+
+	// Start metadata source monitoring, notification delivery and scheduler
+	sourceMonitor.Start()
+	archiver.Start()
+	outboxWorker.Start()
+	if emailChannel != nil {
+		emailChannel.Start()
+	}
+	if alertEngine != nil {
+		alertEngine.Start()
+	}
+	if reportScheduler != nil {
+		reportScheduler.Start()
+	}
+	scheduler.Start()
+	log.Printf("Health check scheduler started (max %d parallel, %d/min, interval %v)",
+		cfg.MaxParallelChecks, cfg.ChecksPerMinute, cfg.MinCheckInterval)
+
+	// Start HTTP server in goroutine
+	go func() {
+		log.Printf("Web server listening on %s", cfg.ListenAddress)
+		if err := server.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("HTTP server error: %v", err)
+		}
+	}()
+
+	if clientTestServer != nil {
+		go func() {
+			log.Printf("Client certificate test server listening on %s", cfg.ClientTest.ListenAddress)
+			if err := clientTestServer.ListenAndServeTLS(cfg.ClientTest.CertFile, cfg.ClientTest.KeyFile); err != http.ErrServerClosed {
+				log.Fatalf("Client certificate test server error: %v", err)
+			}
+		}()
+	}
+
+	// Wait for shutdown signal
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+	sig := <-sigChan
+
+	log.Printf("Received signal %v, shutting down...", sig)
+
+	// Graceful shutdown
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer shutdownCancel()
+
+	// Stop accepting new requests
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("HTTP server shutdown error: %v", err)
+	}
+	if clientTestServer != nil {
+		if err := clientTestServer.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Client certificate test server shutdown error: %v", err)
+		}
+	}
+
+	// Stop scheduler (waits for in-progress checks)
+	scheduler.Stop()
+	log.Printf("Scheduler stopped")
+
+	// Stop reports, alert evaluation and notification delivery
+	if reportScheduler != nil {
+		reportScheduler.Stop()
+	}
+	if alertEngine != nil {
+		alertEngine.Stop()
+	}
+	outboxWorker.Stop()
+	if emailChannel != nil {
+		emailChannel.Stop()
+	}
+	log.Printf("Notification delivery stopped")
+
+	// Stop metadata source monitoring, archiving and metadata store
+	sourceMonitor.Stop()
+	archiver.Stop()
+	metadataStore.Quit()
+	log.Printf("Metadata store stopped")
+
+	fmt.Println("Shutdown complete")
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/joesiltberg/matfmonitor/internal/candidate"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/config"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// runValidate checks every server in a candidate metadata file and compares
+// with the current state. Returns 0 if no server would break, 1 if any
+// would and 2 on usage or configuration errors.
+func runValidate(args []string) int {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	configPath := flags.String("config", "config.yaml", "Path to configuration file")
+	jsonOutput := flags.Bool("json", false, "Print the report as JSON")
+	fetch := flags.Bool("fetch", false, "Download current metadata instead of using the cache file")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: matfmonitor validate [flags] <candidate metadata file>\n\nChecks every server in candidate metadata (signed or unsigned) with the\ncandidate's pins and reports which servers would break if it was published.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		return 2
+	}
+
+	content, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read candidate metadata: %v\n", err)
+		return 2
+	}
+	jwks, err := os.ReadFile(cfg.JWKSPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to read JWKS file: %v\n", err)
+		return 2
+	}
+
+	current, err := loadMetadata(cfg, *fetch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load current metadata: %v\n", err)
+		return 2
+	}
+
+	// Without a database all current servers are treated as unchecked
+	var statuses []*store.ServerStatus
+	if _, err := os.Stat(cfg.DatabasePath); err == nil {
+		statuses, err = storedStatuses(cfg, "", "")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read current statuses: %v\n", err)
+			return 2
+		}
+	}
+
+	validator := candidate.NewValidator(checker.NewRealChecker(cfg.TLSTimeout), jwks, cfg.MaxParallelChecks)
+	report, err := validator.Validate(content, current, statuses)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid candidate metadata: %v\n", err)
+		return 2
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(report)
+	} else {
+		printValidationReport(os.Stdout, report)
+	}
+
+	if report.Broken > 0 {
+		return 1
+	}
+	return 0
+}
+
+func printValidationReport(w io.Writer, report *candidate.Report) {
+	signed := "unsigned"
+	if report.Signed {
+		signed = "signed, signature verified"
+	}
+	fmt.Fprintf(w, "Candidate metadata (%s): %d servers, %d would break, %d fixed, %d added, %d removed, %d unhealthy\n",
+		signed, len(report.Items), report.Broken, report.Fixed, report.Added, len(report.Removed), report.Unhealthy)
+
+	for _, item := range report.Items {
+		if item.Change == candidate.Unchanged && item.Healthy {
+			continue
+		}
+		status := "healthy"
+		if !item.Healthy {
+			status = "unhealthy"
+		}
+		fmt.Fprintf(w, "\n%-9s %s (%s)\n", item.Change, item.BaseURI, item.EntityID)
+		if item.Before != "" {
+			fmt.Fprintf(w, "          now %s, with candidate %s", item.Before, status)
+		} else {
+			fmt.Fprintf(w, "          with candidate %s", status)
+		}
+		if item.PinsChanged {
+			fmt.Fprintf(w, ", pins changed")
+		}
+		fmt.Fprintln(w)
+		if item.Error != "" {
+			fmt.Fprintf(w, "          %s\n", item.Error)
+		}
+	}
+
+	for _, removed := range report.Removed {
+		fmt.Fprintf(w, "\nremoved   %s (%s)\n", removed.BaseURI, removed.EntityID)
+	}
+}
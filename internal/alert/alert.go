@@ -0,0 +1,387 @@
+// Package alert evaluates declarative alert rules against check results and metadata.
+package alert
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/issuer"
+	"github.com/joesiltberg/matfmonitor/internal/notify"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// RuleType selects the condition a rule evaluates
+type RuleType string
+
+const (
+	// Fires when the last Count checks of a server have failed
+	RuleConsecutiveFailures RuleType = "consecutiveFailures"
+	// Fires when a server's certificate expires within Within
+	RuleCertExpiresWithin RuleType = "certExpiresWithin"
+	// Fires when the Percentile of handshake times over the last Window
+	// checks of a server exceeds Threshold
+	RuleHandshakeLatency RuleType = "handshakeLatency"
+	// Fires when the metadata hasn't been successfully fetched for MaxAge
+	RuleMetadataAge RuleType = "metadataAge"
+	// Fires when an issuer certificate of an entity expires within Within
+	RuleIssuerExpiresWithin RuleType = "issuerExpiresWithin"
+)
+
+// errNoObservation is returned by a condition when the latest check didn't
+// observe what the rule looks at, such as the certificate of a server that
+// couldn't be reached. The rule keeps firing or not firing as before.
+var errNoObservation = errors.New("nothing observed")
+
+// How often entity rules are evaluated between metadata changes
+const entityRuleInterval = time.Hour
+
+// Rule is a declarative alert condition
+type Rule struct {
+	Name       string
+	Type       RuleType
+	Count      int
+	Within     time.Duration
+	Percentile int
+	Threshold  time.Duration
+	Window     int
+	MaxAge     time.Duration
+
+	// Channels to notify, all channels accepting alert events if empty
+	Channels []string
+}
+
+// IsServerRule tells whether the rule is evaluated per server
+func (r *Rule) IsServerRule() bool {
+	return r.Type != RuleMetadataAge && !r.IsEntityRule()
+}
+
+// IsEntityRule tells whether the rule is evaluated per entity. Entity rules
+// are evaluated when metadata changes and at least every hour.
+func (r *Rule) IsEntityRule() bool {
+	return r.Type == RuleIssuerExpiresWithin
+}
+
+// Engine evaluates alert rules after each check and metadata change,
+// persists which rules are firing and notifies when a rule starts or stops
+// firing
+type Engine struct {
+	rules         []Rule
+	store         *store.Store
+	metadataStore *fedtls.MetadataStore
+	cachePath     string
+	notifier      *notify.Notifier
+
+	// Checks complete concurrently, evaluations are serialized
+	lock sync.Mutex
+
+	lastEntityEvaluation time.Time
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewEngine creates a new Engine. The modification time of the metadata
+// cache file at cachePath is used as the time of the last successful
+// metadata fetch.
+func NewEngine(rules []Rule, dataStore *store.Store, metadataStore *fedtls.MetadataStore, cachePath string, notifier *notify.Notifier) *Engine {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Engine{
+		rules:         rules,
+		store:         dataStore,
+		metadataStore: metadataStore,
+		cachePath:     cachePath,
+		notifier:      notifier,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Start begins listening for metadata changes
+func (e *Engine) Start() {
+	metadataChanged := make(chan int, 1)
+	e.metadataStore.AddChangeListener(metadataChanged)
+
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		for {
+			select {
+			case <-e.ctx.Done():
+				return
+			case <-metadataChanged:
+				e.MetadataChanged()
+			}
+		}
+	}()
+}
+
+// Stop stops listening for metadata changes
+func (e *Engine) Stop() {
+	e.cancel()
+	e.wg.Wait()
+}
+
+// ServerChecked evaluates the server rules for the checked server, and the
+// federation rules
+func (e *Engine) ServerChecked(previous, current *store.ServerStatus) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	now := time.Now()
+	hasEntityRules := false
+	for i := range e.rules {
+		rule := &e.rules[i]
+		switch {
+		case rule.IsServerRule():
+			e.evaluate(rule, current.ServerKey, now, func() (bool, string, error) {
+				return e.evaluateServer(rule, current)
+			})
+		case rule.IsEntityRule():
+			hasEntityRules = true
+		default:
+			e.evaluateFederationRule(rule, now)
+		}
+	}
+
+	if hasEntityRules && now.Sub(e.lastEntityEvaluation) >= entityRuleInterval {
+		e.evaluateEntityRules(e.metadataStore.GetMetadata(), now)
+	}
+}
+
+// MetadataChanged evaluates the federation rules and resolves alerts for
+// servers that are no longer in metadata
+func (e *Engine) MetadataChanged() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	now := time.Now()
+	for i := range e.rules {
+		if !e.rules[i].IsServerRule() && !e.rules[i].IsEntityRule() {
+			e.evaluateFederationRule(&e.rules[i], now)
+		}
+	}
+
+	metadata := e.metadataStore.GetMetadata()
+	if metadata == nil || len(metadata.Entities) == 0 {
+		return
+	}
+	e.evaluateEntityRules(metadata, now)
+
+	// Entity rules use keys without base URI
+	current := make(map[store.ServerKey]bool)
+	for _, entity := range metadata.Entities {
+		current[store.ServerKey{EntityID: entity.EntityID}] = true
+		for _, server := range entity.Servers {
+			current[store.ServerKey{EntityID: entity.EntityID, BaseURI: server.BaseURI}] = true
+		}
+	}
+
+	states, err := e.store.GetAlertStates()
+	if err != nil {
+		log.Printf("Error getting alert states: %v", err)
+		return
+	}
+	for _, state := range states {
+		if state.EntityID == "" || current[state.ServerKey] {
+			continue
+		}
+		if err := e.store.DeleteAlertState(state.Rule, state.ServerKey); err != nil {
+			log.Printf("Error removing alert state: %v", err)
+		}
+	}
+}
+
+// evaluateEntityRules evaluates the entity rules for every entity in metadata
+func (e *Engine) evaluateEntityRules(metadata *fedtls.Metadata, now time.Time) {
+	e.lastEntityEvaluation = now
+	if metadata == nil {
+		return
+	}
+
+	for i := range e.rules {
+		rule := &e.rules[i]
+		if !rule.IsEntityRule() {
+			continue
+		}
+		for j := range metadata.Entities {
+			entity := &metadata.Entities[j]
+			e.evaluate(rule, store.ServerKey{EntityID: entity.EntityID}, now, func() (bool, string, error) {
+				return evaluateEntity(rule, entity, now)
+			})
+		}
+	}
+}
+
+// evaluateEntity evaluates a per entity rule
+func evaluateEntity(rule *Rule, entity *fedtls.Entity, now time.Time) (bool, string, error) {
+	switch rule.Type {
+	case RuleIssuerExpiresWithin:
+		var expiring []string
+		for _, published := range entity.Issuers {
+			cert := issuer.Parse(entity, published)
+			if cert.ExpiresWithin(now, rule.Within) {
+				expiring = append(expiring, fmt.Sprintf("%s expires %s", cert.Subject, cert.NotAfter.Format("2006-01-02")))
+			}
+		}
+		if len(expiring) == 0 {
+			return false, "no issuer certificate expires within " + rule.Within.String(), nil
+		}
+		return true, "issuer certificate " + strings.Join(expiring, ", "), nil
+	}
+	return false, "", fmt.Errorf("unknown rule type %q", rule.Type)
+}
+
+func (e *Engine) evaluateFederationRule(rule *Rule, now time.Time) {
+	e.evaluate(rule, store.ServerKey{}, now, func() (bool, string, error) {
+		return e.evaluateFederation(rule, now)
+	})
+}
+
+// evaluate runs a rule's condition and notifies if the firing state changed
+func (e *Engine) evaluate(rule *Rule, server store.ServerKey, now time.Time, condition func() (bool, string, error)) {
+	firing, message, err := condition()
+	if err == errNoObservation {
+		return
+	}
+	if err != nil {
+		log.Printf("Error evaluating alert rule %s: %v", rule.Name, err)
+		return
+	}
+
+	state, err := e.store.GetAlertState(rule.Name, server)
+	if err != nil {
+		log.Printf("Error getting alert state for %s: %v", rule.Name, err)
+		return
+	}
+
+	switch {
+	case firing && state == nil:
+		err = e.store.SaveAlertState(&store.AlertState{
+			Rule:      rule.Name,
+			ServerKey: server,
+			Since:     now,
+			Message:   message,
+		})
+		e.publish(rule, notify.EventAlertFiring, server, message, now)
+	case !firing && state != nil:
+		err = e.store.DeleteAlertState(rule.Name, server)
+		e.publish(rule, notify.EventAlertResolved, server, message, now)
+	}
+	if err != nil {
+		log.Printf("Error saving alert state for %s: %v", rule.Name, err)
+	}
+}
+
+func (e *Engine) publish(rule *Rule, eventType notify.EventType, server store.ServerKey, message string, now time.Time) {
+	target := server.BaseURI
+	if target == "" {
+		target = server.EntityID
+	}
+	log.Printf("Alert %s %s for %q: %s", rule.Name, eventType, target, message)
+	if e.notifier == nil {
+		return
+	}
+	e.notifier.PublishTo(notify.Event{
+		Type:       eventType,
+		Rule:       rule.Name,
+		EntityID:   server.EntityID,
+		BaseURI:    server.BaseURI,
+		Message:    message,
+		OccurredAt: now,
+	}, rule.Channels)
+}
+
+// evaluateServer evaluates a per server rule
+func (e *Engine) evaluateServer(rule *Rule, current *store.ServerStatus) (bool, string, error) {
+	switch rule.Type {
+	case RuleConsecutiveFailures:
+		checks, err := e.store.GetRecentChecks(current.EntityID, current.BaseURI, rule.Count)
+		if err != nil {
+			return false, "", err
+		}
+		if len(checks) < rule.Count {
+			return false, "", errNoObservation
+		}
+		for _, check := range checks {
+			if check.IsHealthy {
+				return false, "server passed a recent check", nil
+			}
+		}
+		return true, fmt.Sprintf("unhealthy for %d consecutive checks: %s", rule.Count, current.ErrorMessage), nil
+
+	case RuleCertExpiresWithin:
+		if current.CertExpires == nil {
+			return false, "", errNoObservation
+		}
+		remaining := time.Until(*current.CertExpires)
+		if remaining > rule.Within {
+			return false, fmt.Sprintf("certificate expires %s", current.CertExpires.Format("2006-01-02")), nil
+		}
+		return true, fmt.Sprintf("certificate expires %s (within %s)", current.CertExpires.Format("2006-01-02"), rule.Within), nil
+
+	case RuleHandshakeLatency:
+		checks, err := e.store.GetRecentChecks(current.EntityID, current.BaseURI, rule.Window)
+		if err != nil {
+			return false, "", err
+		}
+		var durations []time.Duration
+		for _, check := range checks {
+			if check.HandshakeDuration != nil {
+				durations = append(durations, *check.HandshakeDuration)
+			}
+		}
+		if len(durations) == 0 {
+			return false, "", errNoObservation
+		}
+		p := Percentile(durations, rule.Percentile)
+		message := fmt.Sprintf("p%d handshake latency over last %d checks is %s (threshold %s)", rule.Percentile, len(durations), p, rule.Threshold)
+		return p > rule.Threshold, message, nil
+	}
+	return false, "", fmt.Errorf("unknown rule type %q", rule.Type)
+}
+
+// evaluateFederation evaluates a rule for the whole federation
+func (e *Engine) evaluateFederation(rule *Rule, now time.Time) (bool, string, error) {
+	switch rule.Type {
+	case RuleMetadataAge:
+		info, err := os.Stat(e.cachePath)
+		if os.IsNotExist(err) {
+			return true, "metadata has never been fetched", nil
+		}
+		if err != nil {
+			return false, "", err
+		}
+		age := now.Sub(info.ModTime()).Truncate(time.Minute)
+		message := fmt.Sprintf("metadata last fetched %s ago (limit %s)", age, rule.MaxAge)
+		return age > rule.MaxAge, message, nil
+	}
+	return false, "", fmt.Errorf("unknown rule type %q", rule.Type)
+}
+
+// Percentile returns the p:th percentile of durations using the nearest-rank method
+func Percentile(durations []time.Duration, p int) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,173 @@
+package alert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func newTestStore(t *testing.T) *store.Store {
+	t.Helper()
+	s, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("store.New() error = %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// check records a check result and runs the engine as the scheduler would
+func check(t *testing.T, engine *Engine, s *store.Store, healthy bool, handshake time.Duration) {
+	t.Helper()
+	now := time.Now()
+	key := store.ServerKey{EntityID: "https://entity.com", BaseURI: "https://api.entity.com"}
+	if err := s.AddCheckRecord(&store.CheckRecord{ServerKey: key, CheckedAt: now, IsHealthy: healthy, HandshakeDuration: &handshake}); err != nil {
+		t.Fatalf("AddCheckRecord() error = %v", err)
+	}
+	status := &store.ServerStatus{ServerKey: key, LastChecked: &now, IsHealthy: &healthy}
+	s.SaveStatus(status)
+	engine.ServerChecked(nil, status)
+}
+
+func isFiring(t *testing.T, s *store.Store, rule string) bool {
+	t.Helper()
+	state, err := s.GetAlertState(rule, store.ServerKey{EntityID: "https://entity.com", BaseURI: "https://api.entity.com"})
+	if err != nil {
+		t.Fatalf("GetAlertState() error = %v", err)
+	}
+	return state != nil
+}
+
+func TestConsecutiveFailures(t *testing.T) {
+	s := newTestStore(t)
+	engine := NewEngine([]Rule{{Name: "down", Type: RuleConsecutiveFailures, Count: 3}}, s, nil, "", nil)
+
+	check(t, engine, s, false, time.Millisecond)
+	check(t, engine, s, false, time.Millisecond)
+	if isFiring(t, s, "down") {
+		t.Fatal("rule fired after 2 failures, want 3")
+	}
+
+	check(t, engine, s, false, time.Millisecond)
+	if !isFiring(t, s, "down") {
+		t.Fatal("rule not firing after 3 failures")
+	}
+
+	check(t, engine, s, true, time.Millisecond)
+	if isFiring(t, s, "down") {
+		t.Fatal("rule still firing after recovery")
+	}
+}
+
+func TestHandshakeLatency(t *testing.T) {
+	s := newTestStore(t)
+	engine := NewEngine([]Rule{{Name: "slow", Type: RuleHandshakeLatency, Percentile: 95, Threshold: 2 * time.Second, Window: 5}}, s, nil, "", nil)
+
+	for i := 0; i < 4; i++ {
+		check(t, engine, s, true, 100*time.Millisecond)
+	}
+	if isFiring(t, s, "slow") {
+		t.Fatal("rule fired for fast handshakes")
+	}
+
+	check(t, engine, s, true, 3*time.Second)
+	if !isFiring(t, s, "slow") {
+		t.Fatal("rule not firing with a slow p95")
+	}
+}
+
+func TestCertExpiresWithinKeepsStateWithoutCertificate(t *testing.T) {
+	s := newTestStore(t)
+	engine := NewEngine([]Rule{{Name: "expiring", Type: RuleCertExpiresWithin, Within: 14 * 24 * time.Hour}}, s, nil, "", nil)
+	key := store.ServerKey{EntityID: "https://entity.com", BaseURI: "https://api.entity.com"}
+
+	checkCert := func(healthy bool, expires *time.Time) {
+		t.Helper()
+		now := time.Now()
+		status := &store.ServerStatus{ServerKey: key, LastChecked: &now, IsHealthy: &healthy, CertExpires: expires}
+		engine.ServerChecked(nil, status)
+	}
+	soon := time.Now().Add(5 * 24 * time.Hour)
+	later := time.Now().Add(100 * 24 * time.Hour)
+
+	checkCert(true, &soon)
+	if !isFiring(t, s, "expiring") {
+		t.Fatal("rule not firing for a certificate expiring in 5 days")
+	}
+
+	// A failed connection observes no certificate
+	checkCert(false, nil)
+	if !isFiring(t, s, "expiring") {
+		t.Fatal("rule resolved by a check without a certificate")
+	}
+
+	checkCert(true, &later)
+	if isFiring(t, s, "expiring") {
+		t.Fatal("rule still firing after the certificate was replaced")
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	var durations []time.Duration
+	for i := 1; i <= 100; i++ {
+		durations = append(durations, time.Duration(i)*time.Millisecond)
+	}
+	if got := Percentile(durations, 95); got != 95*time.Millisecond {
+		t.Errorf("Percentile(95) = %v, want 95ms", got)
+	}
+	if got := Percentile(durations, 100); got != 100*time.Millisecond {
+		t.Errorf("Percentile(100) = %v, want 100ms", got)
+	}
+	if got := Percentile([]time.Duration{time.Second}, 50); got != time.Second {
+		t.Errorf("Percentile of single value = %v, want 1s", got)
+	}
+}
+
+func TestIssuerExpiresWithin(t *testing.T) {
+	s := newTestStore(t)
+	engine := NewEngine([]Rule{{Name: "issuer", Type: RuleIssuerExpiresWithin, Within: 30 * 24 * time.Hour}}, s, nil, "", nil)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerPEM := func(notAfter time.Time) string {
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "Issuer"},
+			NotBefore:    time.Now().Add(-time.Hour),
+			NotAfter:     notAfter,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	}
+
+	entityKey := store.ServerKey{EntityID: "https://entity.com"}
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{{
+		EntityID: "https://entity.com",
+		Issuers:  []fedtls.Issuer{{X509certificate: issuerPEM(time.Now().Add(365 * 24 * time.Hour))}},
+	}}}
+	engine.evaluateEntityRules(metadata, time.Now())
+	if state, _ := s.GetAlertState("issuer", entityKey); state != nil {
+		t.Fatal("rule fired for an issuer valid for a year")
+	}
+
+	metadata.Entities[0].Issuers = append(metadata.Entities[0].Issuers, fedtls.Issuer{X509certificate: issuerPEM(time.Now().Add(5 * 24 * time.Hour))})
+	engine.evaluateEntityRules(metadata, time.Now())
+	if state, _ := s.GetAlertState("issuer", entityKey); state == nil {
+		t.Fatal("rule not firing for an issuer expiring in 5 days")
+	}
+}
@@ -0,0 +1,141 @@
+// Package archive keeps every metadata version in the store and computes
+// the differences between versions.
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// Archiver stores a new metadata version each time the metadata changes
+type Archiver struct {
+	store         *store.Store
+	metadataStore *fedtls.MetadataStore
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewArchiver creates a new Archiver
+func NewArchiver(dataStore *store.Store, metadataStore *fedtls.MetadataStore) *Archiver {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Archiver{
+		store:         dataStore,
+		metadataStore: metadataStore,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Start archives the current metadata and begins listening for changes
+func (a *Archiver) Start() {
+	metadataChanged := make(chan int, 1)
+	a.metadataStore.AddChangeListener(metadataChanged)
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+		a.archiveCurrent()
+		for {
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-metadataChanged:
+				a.archiveCurrent()
+			}
+		}
+	}()
+}
+
+// Stop stops listening for metadata changes
+func (a *Archiver) Stop() {
+	a.cancel()
+	a.wg.Wait()
+}
+
+func (a *Archiver) archiveCurrent() {
+	// The store returns empty metadata until the first load, which
+	// shouldn't be archived as a version without entities
+	metadata := a.metadataStore.GetMetadata()
+	if metadata == nil || len(metadata.Entities) == 0 {
+		return
+	}
+	added, err := Save(a.store, metadata, time.Now())
+	if err != nil {
+		log.Printf("Failed to archive metadata: %v", err)
+		return
+	}
+	if added {
+		log.Printf("Archived new metadata version")
+	}
+}
+
+// Save archives the metadata unless it is identical to the latest archived
+// version. Returns whether a new version was added.
+func Save(dataStore *store.Store, metadata *fedtls.Metadata, now time.Time) (bool, error) {
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		return false, err
+	}
+	sum := sha256.Sum256(content)
+	return dataStore.AddMetadataVersion(hex.EncodeToString(sum[:]), content, now)
+}
+
+// Parse reads the metadata of an archived version
+func Parse(version *store.MetadataVersion) (*fedtls.Metadata, error) {
+	var metadata fedtls.Metadata
+	if err := json.Unmarshal(version.Content, &metadata); err != nil {
+		return nil, fmt.Errorf("parsing metadata version %d: %w", version.ID, err)
+	}
+	return &metadata, nil
+}
+
+// VersionDiff is an archived version and its changes since the previous one
+type VersionDiff struct {
+	Version *store.MetadataVersion
+	// True for the first archived version, which has nothing to compare with
+	First   bool
+	Changes []Change
+}
+
+// Changes returns an archived version with its changes from the version
+// archived before it. The version doesn't need to have its content loaded.
+func Changes(dataStore *store.Store, version *store.MetadataVersion) (VersionDiff, error) {
+	diff := VersionDiff{Version: version}
+	full, err := dataStore.GetMetadataVersion(version.ID)
+	if err != nil {
+		return diff, err
+	}
+	if full == nil {
+		return diff, fmt.Errorf("metadata version %d not found", version.ID)
+	}
+	previous, err := dataStore.GetPreviousMetadataVersion(version.ID)
+	if err != nil {
+		return diff, err
+	}
+	if previous == nil {
+		diff.First = true
+		return diff, nil
+	}
+
+	newMetadata, err := Parse(full)
+	if err != nil {
+		return diff, err
+	}
+	oldMetadata, err := Parse(previous)
+	if err != nil {
+		return diff, err
+	}
+	diff.Changes = Diff(oldMetadata, newMetadata)
+	return diff, nil
+}
@@ -0,0 +1,126 @@
+package archive
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func strptr(s string) *string {
+	return &s
+}
+
+func TestDiff(t *testing.T) {
+	old := &fedtls.Metadata{Entities: []fedtls.Entity{
+		{
+			EntityID:     "https://a.com",
+			Organization: strptr("A"),
+			Clients:      []fedtls.Client{{Pins: []fedtls.Pin{{Alg: "sha256", Digest: "c1"}}}},
+			Servers: []fedtls.Server{
+				{BaseURI: "https://api.a.com/", Tags: []string{"x"}, Pins: []fedtls.Pin{{Alg: "sha256", Digest: "p1"}}},
+				{BaseURI: "https://old.a.com/"},
+			},
+		},
+		{EntityID: "https://gone.com"},
+	}}
+	new := &fedtls.Metadata{Entities: []fedtls.Entity{
+		{
+			EntityID:       "https://a.com",
+			Organization:   strptr("A"),
+			OrganizationID: strptr("SE123"),
+			Clients:        []fedtls.Client{{Pins: []fedtls.Pin{{Alg: "sha256", Digest: "c2"}}}},
+			Issuers:        []fedtls.Issuer{{X509certificate: "not a certificate"}},
+			Servers: []fedtls.Server{
+				{BaseURI: "https://api.a.com/", Tags: []string{"y"}, Pins: []fedtls.Pin{{Alg: "sha256", Digest: "p2"}}},
+				{BaseURI: "https://new.a.com/"},
+			},
+		},
+		{EntityID: "https://b.com"},
+	}}
+
+	want := map[string]bool{
+		"organization changed on entity https://a.com: A → A (SE123)":                true,
+		"client pin added on entity https://a.com: sha256 c2":                        true,
+		"client pin removed on entity https://a.com: sha256 c1":                      true,
+		"pin added on server https://api.a.com/ (entity https://a.com): sha256 p2":   true,
+		"pin removed on server https://api.a.com/ (entity https://a.com): sha256 p1": true,
+		"tag added on server https://api.a.com/ (entity https://a.com): y":           true,
+		"tag removed on server https://api.a.com/ (entity https://a.com): x":         true,
+		"server https://new.a.com/ added (entity https://a.com)":                     true,
+		"server https://old.a.com/ removed (entity https://a.com)":                   true,
+		"entity https://b.com added":                                                 true,
+		"entity https://gone.com removed":                                            true,
+	}
+
+	changes := Diff(old, new)
+	issuers := 0
+	for _, c := range changes {
+		if c.Object == ObjectIssuer {
+			issuers++
+			continue
+		}
+		if !want[c.String()] {
+			t.Errorf("unexpected change %q", c.String())
+		}
+		delete(want, c.String())
+	}
+	for missing := range want {
+		t.Errorf("missing change %q", missing)
+	}
+	if issuers != 1 {
+		t.Errorf("got %d issuer changes, want 1", issuers)
+	}
+
+	if changes := Diff(new, new); len(changes) != 0 {
+		t.Errorf("Diff() of identical metadata = %v", changes)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	s, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("store.New() error = %v", err)
+	}
+	defer s.Close()
+
+	v1 := &fedtls.Metadata{Entities: []fedtls.Entity{{EntityID: "https://a.com"}}}
+	v2 := &fedtls.Metadata{Entities: []fedtls.Entity{{EntityID: "https://a.com"}, {EntityID: "https://b.com"}}}
+
+	now := time.Now()
+	for i, m := range []*fedtls.Metadata{v1, v1, v2} {
+		if _, err := Save(s, m, now.Add(time.Duration(i)*time.Minute)); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+
+	versions, err := s.GetMetadataVersions(0, -1)
+	if err != nil {
+		t.Fatalf("GetMetadataVersions() error = %v", err)
+	}
+	if len(versions) != 2 {
+		t.Fatalf("GetMetadataVersions() returned %d versions, want 2", len(versions))
+	}
+
+	newest, err := Changes(s, versions[0])
+	if err != nil {
+		t.Fatalf("Changes() error = %v", err)
+	}
+	if newest.First || len(newest.Changes) != 1 || newest.Changes[0].EntityID != "https://b.com" {
+		t.Errorf("newest version = %+v", newest)
+	}
+
+	oldest, err := Changes(s, versions[1])
+	if err != nil {
+		t.Fatalf("Changes() error = %v", err)
+	}
+	if !oldest.First || len(oldest.Changes) != 0 {
+		t.Errorf("oldest version = %+v", oldest)
+	}
+
+	if _, err := Changes(s, &store.MetadataVersion{ID: versions[0].ID + 1}); err == nil {
+		t.Error("Changes() of missing version succeeded, want error")
+	}
+}
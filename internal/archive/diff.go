@@ -0,0 +1,268 @@
+package archive
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"sort"
+
+	"github.com/joesiltberg/bowness/fedtls"
+)
+
+// ChangeType tells what happened to an object between two versions
+type ChangeType string
+
+const (
+	Added   ChangeType = "added"
+	Removed ChangeType = "removed"
+	Changed ChangeType = "changed"
+)
+
+// Kinds of objects in metadata
+const (
+	ObjectEntity       = "entity"
+	ObjectOrganization = "organization"
+	ObjectServer       = "server"
+	ObjectPin          = "pin"
+	ObjectTag          = "tag"
+	ObjectDescription  = "description"
+	ObjectIssuer       = "issuer"
+	ObjectClientPin    = "client pin"
+)
+
+// Change is a difference between two metadata versions
+type Change struct {
+	Type     ChangeType `json:"type"`
+	Object   string     `json:"object"`
+	EntityID string     `json:"entity_id"`
+	BaseURI  string     `json:"base_uri,omitempty"`
+	Detail   string     `json:"detail,omitempty"`
+}
+
+// String describes the change in a human-readable way
+func (c Change) String() string {
+	var s string
+	switch c.Object {
+	case ObjectEntity:
+		s = fmt.Sprintf("entity %s %s", c.EntityID, c.Type)
+	case ObjectServer:
+		s = fmt.Sprintf("server %s %s (entity %s)", c.BaseURI, c.Type, c.EntityID)
+	default:
+		if c.BaseURI != "" {
+			s = fmt.Sprintf("%s %s on server %s (entity %s)", c.Object, c.Type, c.BaseURI, c.EntityID)
+		} else {
+			s = fmt.Sprintf("%s %s on entity %s", c.Object, c.Type, c.EntityID)
+		}
+	}
+	if c.Detail != "" {
+		s += ": " + c.Detail
+	}
+	return s
+}
+
+// Diff returns the changes from old to new metadata. A nil old metadata is
+// treated as empty.
+func Diff(old, new *fedtls.Metadata) []Change {
+	var changes []Change
+	add := func(changeType ChangeType, object, entityID, baseURI, detail string) {
+		changes = append(changes, Change{
+			Type:     changeType,
+			Object:   object,
+			EntityID: entityID,
+			BaseURI:  baseURI,
+			Detail:   detail,
+		})
+	}
+
+	oldEntities := entitiesByID(old)
+	newEntities := entitiesByID(new)
+
+	for _, id := range sortedKeys(oldEntities, newEntities) {
+		oldEntity, inOld := oldEntities[id]
+		newEntity, inNew := newEntities[id]
+		switch {
+		case !inOld:
+			add(Added, ObjectEntity, id, "", organizationLabel(newEntity))
+			continue
+		case !inNew:
+			add(Removed, ObjectEntity, id, "", organizationLabel(oldEntity))
+			continue
+		}
+
+		if organizationLabel(oldEntity) != organizationLabel(newEntity) {
+			add(Changed, ObjectOrganization, id, "", organizationLabel(oldEntity)+" → "+organizationLabel(newEntity))
+		}
+
+		addedIssuers, removedIssuers := setDiff(issuerSet(oldEntity), issuerSet(newEntity))
+		for _, issuer := range addedIssuers {
+			add(Added, ObjectIssuer, id, "", issuer)
+		}
+		for _, issuer := range removedIssuers {
+			add(Removed, ObjectIssuer, id, "", issuer)
+		}
+
+		addedPins, removedPins := setDiff(clientPinSet(oldEntity), clientPinSet(newEntity))
+		for _, pin := range addedPins {
+			add(Added, ObjectClientPin, id, "", pin)
+		}
+		for _, pin := range removedPins {
+			add(Removed, ObjectClientPin, id, "", pin)
+		}
+
+		oldServers := serversByBaseURI(oldEntity)
+		newServers := serversByBaseURI(newEntity)
+		for _, baseURI := range sortedKeys(oldServers, newServers) {
+			oldServer, inOld := oldServers[baseURI]
+			newServer, inNew := newServers[baseURI]
+			switch {
+			case !inOld:
+				add(Added, ObjectServer, id, baseURI, "")
+				continue
+			case !inNew:
+				add(Removed, ObjectServer, id, baseURI, "")
+				continue
+			}
+
+			addedPins, removedPins := setDiff(pinSet(oldServer.Pins), pinSet(newServer.Pins))
+			for _, pin := range addedPins {
+				add(Added, ObjectPin, id, baseURI, pin)
+			}
+			for _, pin := range removedPins {
+				add(Removed, ObjectPin, id, baseURI, pin)
+			}
+
+			addedTags, removedTags := setDiff(stringSet(oldServer.Tags), stringSet(newServer.Tags))
+			for _, tag := range addedTags {
+				add(Added, ObjectTag, id, baseURI, tag)
+			}
+			for _, tag := range removedTags {
+				add(Removed, ObjectTag, id, baseURI, tag)
+			}
+
+			if deref(oldServer.Description) != deref(newServer.Description) {
+				add(Changed, ObjectDescription, id, baseURI, fmt.Sprintf("%q → %q", deref(oldServer.Description), deref(newServer.Description)))
+			}
+		}
+	}
+	return changes
+}
+
+// IssuerName describes an issuer certificate by subject and fingerprint
+func IssuerName(issuer fedtls.Issuer) string {
+	block, _ := pem.Decode([]byte(issuer.X509certificate))
+	if block == nil {
+		sum := sha256.Sum256([]byte(issuer.X509certificate))
+		return "unparseable certificate " + base64.StdEncoding.EncodeToString(sum[:])[:12]
+	}
+	sum := sha256.Sum256(block.Bytes)
+	fingerprint := base64.StdEncoding.EncodeToString(sum[:])[:12]
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "unparseable certificate " + fingerprint
+	}
+	return fmt.Sprintf("%s (%s…)", cert.Subject.String(), fingerprint)
+}
+
+func entitiesByID(metadata *fedtls.Metadata) map[string]*fedtls.Entity {
+	result := make(map[string]*fedtls.Entity)
+	if metadata == nil {
+		return result
+	}
+	for i := range metadata.Entities {
+		result[metadata.Entities[i].EntityID] = &metadata.Entities[i]
+	}
+	return result
+}
+
+func serversByBaseURI(entity *fedtls.Entity) map[string]*fedtls.Server {
+	result := make(map[string]*fedtls.Server)
+	for i := range entity.Servers {
+		result[entity.Servers[i].BaseURI] = &entity.Servers[i]
+	}
+	return result
+}
+
+func issuerSet(entity *fedtls.Entity) map[string]bool {
+	result := make(map[string]bool)
+	for _, issuer := range entity.Issuers {
+		result[IssuerName(issuer)] = true
+	}
+	return result
+}
+
+func clientPinSet(entity *fedtls.Entity) map[string]bool {
+	result := make(map[string]bool)
+	for _, client := range entity.Clients {
+		for pin := range pinSet(client.Pins) {
+			result[pin] = true
+		}
+	}
+	return result
+}
+
+func pinSet(pins []fedtls.Pin) map[string]bool {
+	result := make(map[string]bool)
+	for _, pin := range pins {
+		result[pin.Alg+" "+pin.Digest] = true
+	}
+	return result
+}
+
+func stringSet(values []string) map[string]bool {
+	result := make(map[string]bool)
+	for _, v := range values {
+		result[v] = true
+	}
+	return result
+}
+
+// setDiff returns the sorted elements only in b (added) and only in a (removed)
+func setDiff(a, b map[string]bool) (added, removed []string) {
+	for v := range b {
+		if !a[v] {
+			added = append(added, v)
+		}
+	}
+	for v := range a {
+		if !b[v] {
+			removed = append(removed, v)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func sortedKeys[V any](a, b map[string]V) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, m := range []map[string]V{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// organizationLabel returns the organization name followed by the
+// organization ID in parentheses, if present
+func organizationLabel(entity *fedtls.Entity) string {
+	label := deref(entity.Organization)
+	if id := deref(entity.OrganizationID); id != "" {
+		label += " (" + id + ")"
+	}
+	return label
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
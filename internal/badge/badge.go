@@ -0,0 +1,53 @@
+// Package badge renders status badges as SVG images, in the flat style
+// commonly used on project and status pages.
+package badge
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+)
+
+// Colors of the badge value
+const (
+	ColorHealthy   = "#4c1"
+	ColorWarning   = "#dfb317"
+	ColorUnhealthy = "#e05d44"
+	ColorUnchecked = "#9f9f9f"
+)
+
+// Approximate text metrics of 11px Verdana, which the badge uses
+const (
+	charWidth = 7
+	padding   = 10
+	height    = 20
+)
+
+// textWidth estimates the width in pixels of a text
+func textWidth(s string) int {
+	return len([]rune(s))*charWidth + padding
+}
+
+// Render returns an SVG badge with label on a gray background and value on
+// a background of color
+func Render(label, value, color string) []byte {
+	lw, vw := textWidth(label), textWidth(value)
+	width := lw + vw
+	label, value = html.EscapeString(label), html.EscapeString(value)
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" role="img" aria-label="%s: %s">`, width, height, label, value)
+	fmt.Fprintf(&b, `<title>%s: %s</title>`, label, value)
+	b.WriteString(`<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`)
+	fmt.Fprintf(&b, `<clipPath id="r"><rect width="%d" height="%d" rx="3" fill="#fff"/></clipPath>`, width, height)
+	b.WriteString(`<g clip-path="url(#r)">`)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#555"/>`, lw, height)
+	fmt.Fprintf(&b, `<rect x="%d" width="%d" height="%d" fill="%s"/>`, lw, vw, height, color)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="url(#s)"/>`, width, height)
+	b.WriteString(`</g>`)
+	b.WriteString(`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">`)
+	fmt.Fprintf(&b, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`, lw/2, label, lw/2, label)
+	fmt.Fprintf(&b, `<text x="%d" y="15" fill="#010101" fill-opacity=".3">%s</text><text x="%d" y="14">%s</text>`, lw+vw/2, value, lw+vw/2, value)
+	b.WriteString(`</g></svg>`)
+	return b.Bytes()
+}
@@ -0,0 +1,31 @@
+package badge
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	svg := string(Render("MATF <ops>", "healthy · 99.9%", ColorHealthy))
+
+	if err := xml.Unmarshal([]byte(svg), new(struct{})); err != nil {
+		t.Fatalf("not well-formed XML: %v\n%s", err, svg)
+	}
+	for _, want := range []string{
+		`<title>MATF &lt;ops&gt;: healthy · 99.9%</title>`,
+		`fill="#4c1"`,
+	} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("badge is missing %q:\n%s", want, svg)
+		}
+	}
+}
+
+func TestRenderWidth(t *testing.T) {
+	// 4 and 17 characters, each padded by 10 pixels
+	svg := string(Render("MATF", "unhealthy · 12.5%", ColorUnhealthy))
+	if !strings.Contains(svg, `width="167"`) {
+		t.Errorf("unexpected badge width:\n%s", svg)
+	}
+}
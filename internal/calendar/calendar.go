@@ -0,0 +1,246 @@
+// Package calendar lists server and issuer certificates by expiry, and
+// renders them as an iCalendar feed admins can subscribe to.
+package calendar
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/issuer"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// Kinds of certificates in the calendar
+const (
+	KindServer = "server"
+	KindIssuer = "issuer"
+)
+
+// Reminders are how long before expiry calendar clients alert
+var Reminders = []time.Duration{30 * 24 * time.Hour, 7 * 24 * time.Hour}
+
+// Entry is a certificate and when it expires
+type Entry struct {
+	Kind           string    `json:"kind"`
+	EntityID       string    `json:"entity_id"`
+	Organization   string    `json:"organization,omitempty"`
+	OrganizationID string    `json:"organization_id,omitempty"`
+	BaseURI        string    `json:"base_uri,omitempty"`
+	Subject        string    `json:"subject"`
+	Fingerprint    string    `json:"fingerprint"`
+	Expires        time.Time `json:"expires"`
+}
+
+// Organization is an organization that has certificates in the calendar
+type Organization struct {
+	ID   string
+	Name string
+}
+
+// Key is what identifies the organization in the org query parameter, the
+// ID if there is one, otherwise the name
+func (o Organization) Key() string {
+	if o.ID != "" {
+		return o.ID
+	}
+	return o.Name
+}
+
+// Build lists the deployed certificates of the servers in metadata and the
+// issuer certificates published in metadata, ordered by expiry. Servers
+// that haven't been checked are left out, as are issuers that can't be
+// parsed.
+func Build(metadata *fedtls.Metadata, statuses []*store.ServerStatus) []Entry {
+	entries := []Entry{}
+	if metadata == nil {
+		return entries
+	}
+
+	byKey := make(map[store.ServerKey]*store.ServerStatus, len(statuses))
+	for _, status := range statuses {
+		byKey[status.ServerKey] = status
+	}
+
+	for _, entity := range metadata.Entities {
+		var org, orgID string
+		if entity.Organization != nil {
+			org = *entity.Organization
+		}
+		if entity.OrganizationID != nil {
+			orgID = *entity.OrganizationID
+		}
+		for _, server := range entity.Servers {
+			status := byKey[store.ServerKey{EntityID: entity.EntityID, BaseURI: server.BaseURI}]
+			if status == nil || status.CertExpires == nil {
+				continue
+			}
+			entries = append(entries, Entry{
+				Kind:           KindServer,
+				EntityID:       entity.EntityID,
+				Organization:   org,
+				OrganizationID: orgID,
+				BaseURI:        server.BaseURI,
+				Subject:        status.CertCN,
+				Fingerprint:    status.CertFingerprint,
+				Expires:        *status.CertExpires,
+			})
+		}
+	}
+
+	for _, cert := range issuer.Inventory(metadata) {
+		if cert.ParseError != "" {
+			continue
+		}
+		entries = append(entries, Entry{
+			Kind:           KindIssuer,
+			EntityID:       cert.EntityID,
+			Organization:   cert.Organization,
+			OrganizationID: cert.OrganizationID,
+			Subject:        cert.Subject,
+			Fingerprint:    cert.Fingerprint,
+			Expires:        cert.NotAfter,
+		})
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Expires.Before(entries[j].Expires)
+	})
+	return entries
+}
+
+// Filter returns the entries of the organization with the given ID or, for
+// organizations without an ID, name. An empty org returns all entries.
+func Filter(entries []Entry, org string) []Entry {
+	if org == "" {
+		return entries
+	}
+	filtered := []Entry{}
+	for _, e := range entries {
+		if e.organization().Key() == org {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// Organizations lists the organizations of the entries, sorted by name
+func Organizations(entries []Entry) []Organization {
+	seen := make(map[Organization]bool)
+	var orgs []Organization
+	for _, e := range entries {
+		o := e.organization()
+		if o.Key() != "" && !seen[o] {
+			seen[o] = true
+			orgs = append(orgs, o)
+		}
+	}
+	sort.Slice(orgs, func(i, j int) bool {
+		return orgs[i].Name < orgs[j].Name
+	})
+	return orgs
+}
+
+func (e Entry) organization() Organization {
+	return Organization{ID: e.OrganizationID, Name: e.Organization}
+}
+
+// Summary is the event title of the entry
+func (e Entry) Summary() string {
+	if e.Kind == KindServer {
+		return "Server certificate expires: " + e.BaseURI
+	}
+	return "Issuer certificate expires: " + e.Subject
+}
+
+// ICS renders the entries as an iCalendar feed with an all-day event on
+// each expiry date and alarms ahead of it
+func ICS(entries []Entry, name string, now time.Time) string {
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		b.WriteString(fold(fmt.Sprintf(format, args...)))
+		b.WriteString("\r\n")
+	}
+
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//matfmonitor//Certificate expiry//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:%s", escape(name))
+	stamp := now.UTC().Format("20060102T150405Z")
+	for _, e := range entries {
+		day := e.Expires.UTC()
+		line("BEGIN:VEVENT")
+		line("UID:%s@matfmonitor", uid(e))
+		line("DTSTAMP:%s", stamp)
+		line("DTSTART;VALUE=DATE:%s", day.Format("20060102"))
+		line("DTEND;VALUE=DATE:%s", day.AddDate(0, 0, 1).Format("20060102"))
+		line("SUMMARY:%s", escape(e.Summary()))
+		line("DESCRIPTION:%s", escape(description(e)))
+		line("TRANSP:TRANSPARENT")
+		for _, r := range Reminders {
+			line("BEGIN:VALARM")
+			line("ACTION:DISPLAY")
+			line("DESCRIPTION:%s", escape(e.Summary()))
+			line("TRIGGER:-P%dD", int(r.Hours()/24))
+			line("END:VALARM")
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return b.String()
+}
+
+// description is the event description of the entry
+func description(e Entry) string {
+	lines := []string{"Entity: " + e.EntityID}
+	if e.Organization != "" {
+		lines = append(lines, "Organization: "+e.Organization)
+	}
+	lines = append(lines,
+		"Subject: "+e.Subject,
+		"Fingerprint: "+e.Fingerprint,
+		"Expires: "+e.Expires.UTC().Format(time.RFC3339),
+	)
+	return strings.Join(lines, "\n")
+}
+
+// uid identifies the event of a certificate. It stays the same between
+// feed downloads and changes when the certificate is replaced.
+func uid(e Entry) string {
+	sum := sha256.Sum256([]byte(e.Kind + "|" + e.EntityID + "|" + e.BaseURI + "|" + e.Fingerprint))
+	return hex.EncodeToString(sum[:16])
+}
+
+// escape escapes a TEXT value (RFC 5545 section 3.3.11). Any line break,
+// including a lone carriage return, becomes an escaped newline, since a raw
+// one would end the content line.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\r", `\n`, "\n", `\n`).Replace(s)
+}
+
+// fold splits content lines longer than 75 octets (RFC 5545 section 3.1),
+// without breaking UTF-8 sequences
+func fold(s string) string {
+	const limit = 75
+	if len(s) <= limit {
+		return s
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range s {
+		size := len(string(r))
+		if n+size > limit {
+			b.WriteString("\r\n ")
+			n = 1
+		}
+		b.WriteRune(r)
+		n += size
+	}
+	return b.String()
+}
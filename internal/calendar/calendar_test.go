@@ -0,0 +1,147 @@
+package calendar
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func issuerPEM(t *testing.T, notAfter time.Time) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Example Issuer"},
+		NotBefore:             notAfter.AddDate(-1, 0, 0),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func TestBuild(t *testing.T) {
+	now := time.Now()
+	orgA, orgIDA, orgB := "Org A", "SE1", "Org B"
+	serverExpires := now.Add(10 * 24 * time.Hour)
+	issuerExpires := now.Add(5 * 24 * time.Hour)
+
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{
+		{
+			EntityID:       "https://a.com",
+			Organization:   &orgA,
+			OrganizationID: &orgIDA,
+			Issuers:        []fedtls.Issuer{{X509certificate: issuerPEM(t, issuerExpires)}, {X509certificate: "garbage"}},
+			Servers: []fedtls.Server{
+				{BaseURI: "https://api.a.com/"},
+				{BaseURI: "https://unchecked.a.com/"},
+			},
+		},
+		{
+			EntityID:     "https://b.com",
+			Organization: &orgB,
+			Servers:      []fedtls.Server{{BaseURI: "https://api.b.com/"}},
+		},
+	}}
+	later := now.Add(100 * 24 * time.Hour)
+	statuses := []*store.ServerStatus{
+		{ServerKey: store.ServerKey{EntityID: "https://a.com", BaseURI: "https://api.a.com/"}, CertExpires: &serverExpires, CertCN: "api.a.com"},
+		{ServerKey: store.ServerKey{EntityID: "https://b.com", BaseURI: "https://api.b.com/"}, CertExpires: &later},
+		{ServerKey: store.ServerKey{EntityID: "https://gone.com", BaseURI: "https://gone.com/"}, CertExpires: &later},
+	}
+
+	entries := Build(metadata, statuses)
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want 3: %+v", len(entries), entries)
+	}
+	if entries[0].Kind != KindIssuer || entries[1].BaseURI != "https://api.a.com/" || entries[2].BaseURI != "https://api.b.com/" {
+		t.Errorf("entries not ordered by expiry: %+v", entries)
+	}
+
+	if got := Filter(entries, "SE1"); len(got) != 2 {
+		t.Errorf("Filter(SE1) = %+v", got)
+	}
+	if got := Filter(entries, "Org B"); len(got) != 1 {
+		t.Errorf("Filter(Org B) = %+v", got)
+	}
+	if got := Filter(entries, ""); len(got) != 3 {
+		t.Errorf("Filter(\"\") = %+v", got)
+	}
+
+	orgs := Organizations(entries)
+	if len(orgs) != 2 || orgs[0].Key() != "SE1" || orgs[1].Key() != "Org B" {
+		t.Errorf("Organizations() = %+v", orgs)
+	}
+}
+
+func TestICS(t *testing.T) {
+	expires := time.Date(2030, 3, 14, 12, 0, 0, 0, time.UTC)
+	entries := []Entry{{
+		Kind:         KindServer,
+		EntityID:     "https://a.com",
+		Organization: "Org A, Inc; Sweden",
+		BaseURI:      "https://api.a.com/" + strings.Repeat("long/", 20),
+		Subject:      "api.a.com",
+		Fingerprint:  "abc",
+		Expires:      expires,
+	}, {
+		Kind:         KindIssuer,
+		EntityID:     "https://b.com",
+		Organization: "Org B\r\nDepartment\rUnit",
+		Subject:      "CN=Issuer\r\nB",
+		Fingerprint:  "ghi",
+		Expires:      expires,
+	}}
+
+	ics := ICS(entries, "Certificates", time.Now())
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"DTSTART;VALUE=DATE:20300314\r\n",
+		"DTEND;VALUE=DATE:20300315\r\n",
+		"TRIGGER:-P30D\r\n",
+		"TRIGGER:-P7D\r\n",
+		`Org A\, Inc\; Sweden`,
+		`Org B\nDepartment\nUnit`,
+		`CN=Issuer\nB`,
+		"END:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("feed is missing %q:\n%s", want, ics)
+		}
+	}
+	// Line breaks from metadata must not end content lines
+	if bare := strings.Count(ics, "\r") - strings.Count(ics, "\r\n"); bare != 0 {
+		t.Errorf("feed has %d bare carriage returns:\n%q", bare, ics)
+	}
+	if strings.Contains(strings.ReplaceAll(ics, "\r\n", ""), "\n") {
+		t.Errorf("feed has bare newlines:\n%q", ics)
+	}
+	for _, l := range strings.Split(ics, "\r\n") {
+		if len(l) > 75 {
+			t.Errorf("line longer than 75 octets: %q", l)
+		}
+	}
+
+	// A new certificate is a new event
+	replaced := entries[0]
+	replaced.Fingerprint = "def"
+	if uid(replaced) == uid(entries[0]) {
+		t.Error("UID didn't change with the certificate")
+	}
+}
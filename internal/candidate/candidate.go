@@ -0,0 +1,264 @@
+// Package candidate checks a metadata version before it is published, to
+// find the servers that would break.
+package candidate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// Change describes how a server's health differs between current and
+// candidate metadata
+type Change string
+
+const (
+	// Healthy now, unhealthy with the candidate
+	Broken Change = "broken"
+	// Unhealthy now, healthy with the candidate
+	Fixed Change = "fixed"
+	// Not in the current metadata
+	Added Change = "added"
+	// Same health as now
+	Unchanged Change = "unchanged"
+)
+
+// Item is the outcome for one server in the candidate metadata
+type Item struct {
+	EntityID     string `json:"entity_id"`
+	Organization string `json:"organization,omitempty"`
+	BaseURI      string `json:"base_uri"`
+	Change       Change `json:"change"`
+	// "healthy", "unhealthy" or "unchecked" from the latest stored check,
+	// empty for added servers
+	Before      string `json:"before,omitempty"`
+	BeforeError string `json:"before_error,omitempty"`
+	Healthy     bool   `json:"healthy"`
+	Error       string `json:"error,omitempty"`
+	PinsChanged bool   `json:"pins_changed"`
+	CertCN      string `json:"cert_cn,omitempty"`
+}
+
+// RemovedServer is a server in the current metadata that is missing from
+// the candidate
+type RemovedServer struct {
+	EntityID string `json:"entity_id"`
+	BaseURI  string `json:"base_uri"`
+}
+
+// Report is the result of validating candidate metadata
+type Report struct {
+	GeneratedAt time.Time       `json:"generated_at"`
+	Signed      bool            `json:"signed"`
+	Items       []Item          `json:"servers"`
+	Removed     []RemovedServer `json:"removed"`
+	Broken      int             `json:"broken"`
+	Fixed       int             `json:"fixed"`
+	Added       int             `json:"added"`
+	Unhealthy   int             `json:"unhealthy"`
+}
+
+// Validator checks candidate metadata
+type Validator struct {
+	checker  checker.Checker
+	jwks     []byte
+	parallel int
+}
+
+// NewValidator creates a Validator. Signed candidates are verified with the
+// given JWKS, parallel limits the number of simultaneous checks.
+func NewValidator(healthChecker checker.Checker, jwks []byte, parallel int) *Validator {
+	if parallel < 1 {
+		parallel = 1
+	}
+	return &Validator{
+		checker:  healthChecker,
+		jwks:     jwks,
+		parallel: parallel,
+	}
+}
+
+// Parse reads candidate metadata, either unsigned JSON or a JWS signed with
+// a key in the federation's JWKS. Returns whether the candidate was signed.
+func (v *Validator) Parse(content []byte) (*fedtls.Metadata, bool, error) {
+	trimmed := bytes.TrimSpace(content)
+	if len(trimmed) == 0 {
+		return nil, false, fmt.Errorf("empty metadata")
+	}
+
+	if trimmed[0] == '{' {
+		var metadata fedtls.Metadata
+		if err := json.Unmarshal(trimmed, &metadata); err != nil {
+			return nil, false, fmt.Errorf("parsing unsigned metadata: %w", err)
+		}
+		return &metadata, false, nil
+	}
+
+	metadata, err := fedtls.Verify(trimmed, v.jwks)
+	if err != nil {
+		return nil, true, err
+	}
+	return metadata, true, nil
+}
+
+// Validate parses the candidate and checks every server in it with the
+// candidate's pins, comparing with the current metadata and stored statuses
+func (v *Validator) Validate(content []byte, current *fedtls.Metadata, statuses []*store.ServerStatus) (*Report, error) {
+	candidate, signed, err := v.Parse(content)
+	if err != nil {
+		return nil, err
+	}
+
+	type job struct {
+		entity fedtls.Entity
+		server fedtls.Server
+	}
+	var jobs []job
+	for _, entity := range candidate.Entities {
+		for _, server := range entity.Servers {
+			jobs = append(jobs, job{entity, server})
+		}
+	}
+
+	results := make([]*checker.Result, len(jobs))
+	sem := make(chan struct{}, v.parallel)
+	var wg sync.WaitGroup
+	for i, j := range jobs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, j job) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			results[i] = v.checker.Check(j.entity.EntityID, j.server)
+		}(i, j)
+	}
+	wg.Wait()
+
+	report := &Report{
+		GeneratedAt: time.Now(),
+		Signed:      signed,
+	}
+
+	currentPins := make(map[store.ServerKey][]fedtls.Pin)
+	if current != nil {
+		for _, entity := range current.Entities {
+			for _, server := range entity.Servers {
+				currentPins[store.ServerKey{EntityID: entity.EntityID, BaseURI: server.BaseURI}] = server.Pins
+			}
+		}
+	}
+	statusMap := make(map[store.ServerKey]*store.ServerStatus)
+	for _, s := range statuses {
+		statusMap[s.ServerKey] = s
+	}
+
+	inCandidate := make(map[store.ServerKey]bool)
+	for i, j := range jobs {
+		key := store.ServerKey{EntityID: j.entity.EntityID, BaseURI: j.server.BaseURI}
+		inCandidate[key] = true
+		result := results[i]
+
+		item := Item{
+			EntityID: key.EntityID,
+			BaseURI:  key.BaseURI,
+			Healthy:  result.IsHealthy,
+			Error:    result.ErrorMessage,
+			CertCN:   result.CertCN,
+		}
+		if j.entity.Organization != nil {
+			item.Organization = *j.entity.Organization
+		}
+
+		pins, existed := currentPins[key]
+		if !existed {
+			item.Change = Added
+			report.Added++
+		} else {
+			item.PinsChanged = !samePins(pins, j.server.Pins)
+			item.Before = "unchecked"
+			if s, ok := statusMap[key]; ok && s.IsHealthy != nil {
+				if *s.IsHealthy {
+					item.Before = "healthy"
+				} else {
+					item.Before = "unhealthy"
+					item.BeforeError = s.ErrorMessage
+				}
+			}
+			switch {
+			case item.Before == "healthy" && !item.Healthy:
+				item.Change = Broken
+				report.Broken++
+			case item.Before == "unhealthy" && item.Healthy:
+				item.Change = Fixed
+				report.Fixed++
+			default:
+				item.Change = Unchanged
+			}
+		}
+		if !item.Healthy {
+			report.Unhealthy++
+		}
+		report.Items = append(report.Items, item)
+	}
+
+	for key := range currentPins {
+		if !inCandidate[key] {
+			report.Removed = append(report.Removed, RemovedServer{EntityID: key.EntityID, BaseURI: key.BaseURI})
+		}
+	}
+
+	sort.SliceStable(report.Items, func(i, j int) bool {
+		a, b := report.Items[i], report.Items[j]
+		if changeOrder[a.Change] != changeOrder[b.Change] {
+			return changeOrder[a.Change] < changeOrder[b.Change]
+		}
+		if a.Healthy != b.Healthy {
+			return !a.Healthy
+		}
+		if a.EntityID != b.EntityID {
+			return a.EntityID < b.EntityID
+		}
+		return a.BaseURI < b.BaseURI
+	})
+	sort.Slice(report.Removed, func(i, j int) bool {
+		if report.Removed[i].EntityID != report.Removed[j].EntityID {
+			return report.Removed[i].EntityID < report.Removed[j].EntityID
+		}
+		return report.Removed[i].BaseURI < report.Removed[j].BaseURI
+	})
+
+	return report, nil
+}
+
+// Order of changes in the report, most important first
+var changeOrder = map[Change]int{
+	Broken:    0,
+	Added:     1,
+	Fixed:     2,
+	Unchanged: 3,
+}
+
+// samePins tells if two pin lists contain the same pins, in any order
+func samePins(a, b []fedtls.Pin) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	count := make(map[fedtls.Pin]int)
+	for _, pin := range a {
+		count[pin]++
+	}
+	for _, pin := range b {
+		count[pin]--
+		if count[pin] < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,130 @@
+package candidate
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// fakeChecker reports a server as healthy if one of its pins matches the
+// fingerprint deployed at its base URI
+type fakeChecker struct {
+	deployed map[string]string
+}
+
+func (c *fakeChecker) Check(entityID string, server fedtls.Server) *checker.Result {
+	result := &checker.Result{EntityID: entityID, BaseURI: server.BaseURI, CheckedAt: time.Now()}
+	for _, pin := range server.Pins {
+		if pin.Digest == c.deployed[server.BaseURI] {
+			result.IsHealthy = true
+			return result
+		}
+	}
+	result.ErrorMessage = "certificate fingerprint does not match any pin in metadata"
+	return result
+}
+
+func server(baseURI string, digests ...string) fedtls.Server {
+	s := fedtls.Server{BaseURI: baseURI}
+	for _, d := range digests {
+		s.Pins = append(s.Pins, fedtls.Pin{Alg: "sha256", Digest: d})
+	}
+	return s
+}
+
+func status(entityID, baseURI string, healthy bool) *store.ServerStatus {
+	return &store.ServerStatus{
+		ServerKey: store.ServerKey{EntityID: entityID, BaseURI: baseURI},
+		IsHealthy: &healthy,
+	}
+}
+
+func TestValidate(t *testing.T) {
+	current := &fedtls.Metadata{Entities: []fedtls.Entity{{
+		EntityID: "https://a.com",
+		Servers: []fedtls.Server{
+			server("https://keep.a.com/", "k"),
+			server("https://break.a.com/", "b"),
+			server("https://fix.a.com/", "old"),
+			server("https://gone.a.com/", "g"),
+		},
+	}}}
+	statuses := []*store.ServerStatus{
+		status("https://a.com", "https://keep.a.com/", true),
+		status("https://a.com", "https://break.a.com/", true),
+		status("https://a.com", "https://fix.a.com/", false),
+	}
+	candidate := &fedtls.Metadata{Entities: []fedtls.Entity{{
+		EntityID: "https://a.com",
+		Servers: []fedtls.Server{
+			server("https://keep.a.com/", "k"),
+			server("https://break.a.com/", "wrong"),
+			server("https://fix.a.com/", "f"),
+			server("https://new.a.com/", "n"),
+		},
+	}}}
+	content, err := json.Marshal(candidate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := NewValidator(&fakeChecker{deployed: map[string]string{
+		"https://keep.a.com/":  "k",
+		"https://break.a.com/": "b",
+		"https://fix.a.com/":   "f",
+		"https://new.a.com/":   "n",
+	}}, nil, 2)
+
+	report, err := v.Validate(content, current, statuses)
+	if err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+
+	if report.Signed {
+		t.Error("unsigned candidate reported as signed")
+	}
+	if report.Broken != 1 || report.Fixed != 1 || report.Added != 1 || report.Unhealthy != 1 {
+		t.Errorf("counts = %d broken, %d fixed, %d added, %d unhealthy", report.Broken, report.Fixed, report.Added, report.Unhealthy)
+	}
+
+	want := []struct {
+		baseURI     string
+		change      Change
+		pinsChanged bool
+	}{
+		{"https://break.a.com/", Broken, true},
+		{"https://new.a.com/", Added, false},
+		{"https://fix.a.com/", Fixed, true},
+		{"https://keep.a.com/", Unchanged, false},
+	}
+	if len(report.Items) != len(want) {
+		t.Fatalf("got %d items, want %d", len(report.Items), len(want))
+	}
+	for i, w := range want {
+		item := report.Items[i]
+		if item.BaseURI != w.baseURI || item.Change != w.change || item.PinsChanged != w.pinsChanged {
+			t.Errorf("item %d = %s %s pinsChanged=%v, want %s %s pinsChanged=%v",
+				i, item.BaseURI, item.Change, item.PinsChanged, w.baseURI, w.change, w.pinsChanged)
+		}
+	}
+	if report.Items[0].Error == "" {
+		t.Error("broken server has no error message")
+	}
+
+	if len(report.Removed) != 1 || report.Removed[0].BaseURI != "https://gone.a.com/" {
+		t.Errorf("Removed = %v", report.Removed)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	v := NewValidator(&fakeChecker{}, []byte(`{"keys":[]}`), 1)
+	for _, content := range []string{"", "  ", "{not json", "not.a.jws"} {
+		if _, _, err := v.Parse([]byte(content)); err == nil {
+			t.Errorf("Parse(%q) succeeded", content)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/joesiltberg/bowness/fedtls"
 	"github.com/joesiltberg/bowness/util"
+	"github.com/joesiltberg/matfmonitor/internal/issuer"
 )
 
 // Result represents the outcome of a health check
@@ -24,8 +25,32 @@ type Result struct {
 	CertCN          string
 	CertFingerprint string
 	CheckedAt       time.Time
+
+	// Time taken by the TLS handshake, zero if no handshake was attempted
+	HandshakeDuration time.Duration
+
+	// Negotiated TLS parameters and certificate details, empty if not known
+	TLSVersion    string
+	CipherSuite   string
+	KeyType       string
+	KeyBits       int
+	CertIssuer    string
+	CertNotBefore *time.Time
+
+	// Category of the failure, one of the Failure constants, empty if healthy
+	FailureReason string
 }
 
+// Categories of check failures
+const (
+	FailureInvalidURI    = "invalid-uri"
+	FailureConnection    = "connection"
+	FailureNoCertificate = "no-certificate"
+	FailureExpired       = "expired"
+	FailureHostname      = "hostname"
+	FailurePin           = "pin"
+)
+
 // Checker performs TLS health checks against servers
 type Checker interface {
 	Check(entityID string, server fedtls.Server) *Result
@@ -50,44 +75,69 @@ func (c *RealChecker) Check(entityID string, server fedtls.Server) *Result {
 	}
 
 	// Parse the base URI to get host and port
-	host, port, err := parseBaseURI(server.BaseURI)
+	host, port, err := ParseBaseURI(server.BaseURI)
 	if err != nil {
 		result.IsHealthy = false
 		result.ErrorMessage = fmt.Sprintf("invalid base_uri: %v", err)
+		result.FailureReason = FailureInvalidURI
 		return result
 	}
 
 	// Perform TLS handshake and get certificate
-	cert, err := c.getTLSCertificate(host, port)
+	handshake, err := c.handshake(host, port, nil)
 	if err != nil {
 		result.IsHealthy = false
 		result.ErrorMessage = fmt.Sprintf("TLS connection failed: %v", err)
+		result.FailureReason = FailureConnection
 		return result
 	}
+	result.HandshakeDuration = handshake.Duration
+	if handshake.Version != 0 {
+		result.TLSVersion = tls.VersionName(handshake.Version)
+	}
+	if handshake.CipherSuite != 0 {
+		result.CipherSuite = tls.CipherSuiteName(handshake.CipherSuite)
+	}
+	if len(handshake.Chain) == 0 {
+		result.IsHealthy = false
+		result.ErrorMessage = "TLS connection failed: no certificate received from server"
+		result.FailureReason = FailureNoCertificate
+		return result
+	}
+	cert := handshake.Chain[0]
 
 	// We got a certificate, verify it
 	result.CertCN = cert.Subject.CommonName
 	result.CertExpires = &cert.NotAfter
+	result.CertNotBefore = &cert.NotBefore
 	result.CertFingerprint = util.Fingerprint(cert)
+	result.CertIssuer = cert.Issuer.CommonName
+	if result.CertIssuer == "" {
+		result.CertIssuer = cert.Issuer.String()
+	}
+	result.KeyType, result.KeyBits = issuer.KeyInfo(cert)
 
 	// Check certificate expiry
 	if time.Now().After(cert.NotAfter) {
 		result.IsHealthy = false
 		result.ErrorMessage = fmt.Sprintf("certificate expired on %s", cert.NotAfter.Format(time.RFC3339))
+		result.FailureReason = FailureExpired
 		return result
 	}
 
 	// Check if CN or SAN matches hostname
-	if !matchesHostname(cert, host) {
+	if !MatchesHostname(cert, host) {
 		result.IsHealthy = false
 		result.ErrorMessage = fmt.Sprintf("certificate CN (%s) and SANs do not match hostname (%s)", cert.Subject.CommonName, host)
+		result.FailureReason = FailureHostname
 		return result
 	}
 
 	// Verify fingerprint against metadata pins
-	if !matchesPin(result.CertFingerprint, server.Pins) {
+	if !MatchesPin(result.CertFingerprint, server.Pins) {
 		result.IsHealthy = false
 		result.ErrorMessage = fmt.Sprintf("certificate fingerprint (%s) does not match any pin in metadata", result.CertFingerprint)
+		result.FailureReason = FailurePin
 		return result
 	}
 
@@ -95,8 +145,9 @@ func (c *RealChecker) Check(entityID string, server fedtls.Server) *Result {
 	return result
 }
 
-// parseBaseURI extracts host and port from a base URI
-func parseBaseURI(baseURI string) (string, string, error) {
+// ParseBaseURI extracts host and port from a base URI. The port defaults
+// to 443.
+func ParseBaseURI(baseURI string) (string, string, error) {
 	u, err := url.Parse(baseURI)
 	if err != nil {
 		return "", "", err
@@ -116,50 +167,8 @@ func parseBaseURI(baseURI string) (string, string, error) {
 	return host, port, nil
 }
 
-// getTLSCertificate connects to the server and retrieves its certificate.
-// Uses VerifyPeerCertificate callback to capture the certificate regardless
-// of whether the handshake succeeds (e.g., even if server requires client cert).
-func (c *RealChecker) getTLSCertificate(host, port string) (*x509.Certificate, error) {
-	addr := net.JoinHostPort(host, port)
-
-	dialer := &net.Dialer{Timeout: c.timeout}
-	rawConn, err := dialer.Dial("tcp", addr)
-	if err != nil {
-		return nil, fmt.Errorf("connection failed: %w", err)
-	}
-	defer rawConn.Close()
-
-	rawConn.SetDeadline(time.Now().Add(c.timeout))
-
-	var capturedCert *x509.Certificate
-
-	tlsConn := tls.Client(rawConn, &tls.Config{
-		InsecureSkipVerify: true, // We verify the cert ourselves against metadata
-		ServerName:         host,
-		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
-			if len(rawCerts) > 0 {
-				cert, err := x509.ParseCertificate(rawCerts[0])
-				if err == nil {
-					capturedCert = cert
-				}
-			}
-			return nil
-		},
-	})
-
-	// Attempt the handshake - we don't care if it fails, we just want the cert
-	tlsConn.Handshake()
-	tlsConn.Close()
-
-	if capturedCert == nil {
-		return nil, fmt.Errorf("no certificate received from server")
-	}
-
-	return capturedCert, nil
-}
-
-// matchesHostname checks if the certificate's CN or any SAN matches the hostname
-func matchesHostname(cert *x509.Certificate, hostname string) bool {
+// MatchesHostname checks if the certificate's CN or any SAN matches the hostname
+func MatchesHostname(cert *x509.Certificate, hostname string) bool {
 	// Check CN
 	if matchHostname(cert.Subject.CommonName, hostname) {
 		return true
@@ -210,10 +219,13 @@ func matchHostname(pattern, hostname string) bool {
 	return false
 }
 
-// matchesPin checks if the fingerprint matches any of the pins
-func matchesPin(fingerprint string, pins []fedtls.Pin) bool {
+// PinAlg is the only pin algorithm supported, pins with other algorithms are ignored
+const PinAlg = "sha256"
+
+// MatchesPin checks if the fingerprint matches any of the pins
+func MatchesPin(fingerprint string, pins []fedtls.Pin) bool {
 	for _, pin := range pins {
-		if pin.Alg == "sha256" && pin.Digest == fingerprint {
+		if pin.Alg == PinAlg && pin.Digest == fingerprint {
 			return true
 		}
 	}
@@ -0,0 +1,100 @@
+package checker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+)
+
+// Handshake is what a TLS handshake with a server revealed
+type Handshake struct {
+	Host       string
+	Port       string
+	RemoteAddr string
+
+	// Certificates sent by the server, leaf first. Set even if the
+	// handshake failed after the server's certificate was received.
+	Chain []*x509.Certificate
+
+	// Negotiated TLS version and cipher suite, zero if not known
+	Version     uint16
+	CipherSuite uint16
+
+	// Whether the server asked for a client certificate
+	ClientCertRequested bool
+
+	// Handshake error, expected when the server requires a client certificate
+	Error error
+
+	Duration time.Duration
+}
+
+// DialControl is called with the resolved address before a connection is
+// made, returning an error prevents the connection
+type DialControl func(network, address string, c syscall.RawConn) error
+
+// Probe performs the same TLS handshake as Check against a base URI, but
+// without verifying the result against metadata. If control is set it is
+// used to restrict which addresses may be connected to.
+func (c *RealChecker) Probe(baseURI string, control DialControl) (*Handshake, error) {
+	host, port, err := ParseBaseURI(baseURI)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base_uri: %w", err)
+	}
+	return c.handshake(host, port, control)
+}
+
+// handshake connects to the server and performs a TLS handshake without a
+// client certificate. An error is only returned if no connection could be
+// made, a failed handshake is reported in the result.
+func (c *RealChecker) handshake(host, port string, control DialControl) (*Handshake, error) {
+	addr := net.JoinHostPort(host, port)
+
+	dialer := &net.Dialer{Timeout: c.timeout, Control: control}
+	rawConn, err := dialer.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("connection failed: %w", err)
+	}
+	defer rawConn.Close()
+
+	rawConn.SetDeadline(time.Now().Add(c.timeout))
+
+	result := &Handshake{
+		Host:       host,
+		Port:       port,
+		RemoteAddr: rawConn.RemoteAddr().String(),
+	}
+
+	tlsConn := tls.Client(rawConn, &tls.Config{
+		InsecureSkipVerify: true, // We verify the cert ourselves against metadata
+		ServerName:         host,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, raw := range rawCerts {
+				cert, err := x509.ParseCertificate(raw)
+				if err != nil {
+					break
+				}
+				result.Chain = append(result.Chain, cert)
+			}
+			return nil
+		},
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			result.ClientCertRequested = true
+			return &tls.Certificate{}, nil
+		},
+	})
+
+	// Attempt the handshake - we don't care if it fails, we just want the cert
+	start := time.Now()
+	result.Error = tlsConn.Handshake()
+	result.Duration = time.Since(start)
+	state := tlsConn.ConnectionState()
+	result.Version = state.Version
+	result.CipherSuite = state.CipherSuite
+	tlsConn.Close()
+
+	return result, nil
+}
@@ -10,6 +10,13 @@ import (
 	"github.com/joesiltberg/matfmonitor/internal/store"
 )
 
+// Observer is notified after each completed and saved health check.
+// previous is the server's status before the check, or nil if it wasn't
+// known.
+type Observer interface {
+	ServerChecked(previous, current *store.ServerStatus)
+}
+
 // Scheduler manages rate-limited health checks for all servers
 type Scheduler struct {
 	checker          Checker
@@ -18,6 +25,7 @@ type Scheduler struct {
 	maxParallel      int
 	checksPerMinute  int
 	minCheckInterval time.Duration
+	historyRetention time.Duration
 
 	// Priority server configuration
 	priorityMinInterval time.Duration
@@ -30,6 +38,9 @@ type Scheduler struct {
 	inFlight     map[string]bool
 	inFlightLock sync.Mutex
 
+	// Notified after each check, must be registered before Start
+	observers []Observer
+
 	// For graceful shutdown
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -46,6 +57,7 @@ func NewScheduler(
 	minCheckInterval time.Duration,
 	priorityMinInterval time.Duration,
 	maxPriorityServers int,
+	historyRetention time.Duration,
 ) *Scheduler {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &Scheduler{
@@ -55,6 +67,7 @@ func NewScheduler(
 		maxParallel:         maxParallel,
 		checksPerMinute:     checksPerMinute,
 		minCheckInterval:    minCheckInterval,
+		historyRetention:    historyRetention,
 		priorityMinInterval: priorityMinInterval,
 		maxPriorityServers:  maxPriorityServers,
 		priorityChan:        make(chan store.ServerKey, maxPriorityServers),
@@ -75,6 +88,12 @@ func (s *Scheduler) RequestPriorityCheck(server store.ServerKey) bool {
 	}
 }
 
+// AddObserver registers an observer to be notified of check results.
+// Must be called before Start.
+func (s *Scheduler) AddObserver(observer Observer) {
+	s.observers = append(s.observers, observer)
+}
+
 // Start begins the scheduling loop
 func (s *Scheduler) Start() {
 	s.wg.Add(1)
@@ -259,6 +278,16 @@ func (s *Scheduler) syncServersFromMetadata() {
 		return
 	}
 
+	// Servers already known, to record which servers were added and removed
+	known := make(map[store.ServerKey]bool)
+	statuses, err := s.store.GetAllStatuses()
+	if err != nil {
+		log.Printf("Error getting known servers: %v", err)
+	}
+	for _, status := range statuses {
+		known[status.ServerKey] = true
+	}
+
 	var currentServers []store.ServerKey
 
 	for _, entity := range parsed.Entities {
@@ -275,6 +304,11 @@ func (s *Scheduler) syncServersFromMetadata() {
 		}
 	}
 
+	// Record changes, unless this is the first sync of an empty database
+	if len(known) > 0 && len(currentServers) > 0 {
+		s.recordServerChanges(known, currentServers)
+	}
+
 	// Remove servers no longer in metadata
 	if len(currentServers) > 0 {
 		if err := s.store.RemoveServersNotIn(currentServers); err != nil {
@@ -282,9 +316,61 @@ func (s *Scheduler) syncServersFromMetadata() {
 		}
 	}
 
+	// Drop check history older than the retention period
+	if err := s.store.PruneCheckHistory(time.Now().Add(-s.historyRetention)); err != nil {
+		log.Printf("Error pruning check history: %v", err)
+	}
+
 	log.Printf("Synced %d servers from metadata", len(currentServers))
 }
 
+// recordServerChanges stores which servers have been added to and removed
+// from metadata since the last sync
+func (s *Scheduler) recordServerChanges(known map[store.ServerKey]bool, currentServers []store.ServerKey) {
+	now := time.Now()
+	current := make(map[store.ServerKey]bool, len(currentServers))
+	for _, key := range currentServers {
+		current[key] = true
+		if !known[key] {
+			if err := s.store.AddServerChange(&store.ServerChange{ServerKey: key, Change: store.ServerAdded, ChangedAt: now}); err != nil {
+				log.Printf("Error recording added server: %v", err)
+			}
+		}
+	}
+	for key := range known {
+		if !current[key] {
+			if err := s.store.AddServerChange(&store.ServerChange{ServerKey: key, Change: store.ServerRemoved, ChangedAt: now}); err != nil {
+				log.Printf("Error recording removed server: %v", err)
+			}
+		}
+	}
+}
+
+// recordCertChange stores that a server presents a different certificate
+// than at the previous check
+func (s *Scheduler) recordCertChange(previous, current *store.ServerStatus, server fedtls.Server) {
+	change := &store.CertChange{
+		ServerKey:      current.ServerKey,
+		ChangedAt:      *current.LastChecked,
+		OldFingerprint: previous.CertFingerprint,
+		NewFingerprint: current.CertFingerprint,
+		OldCN:          previous.CertCN,
+		NewCN:          current.CertCN,
+		OldExpires:     previous.CertExpires,
+		NewExpires:     current.CertExpires,
+		Pinned:         MatchesPin(current.CertFingerprint, server.Pins),
+	}
+	if err := s.store.AddCertChange(change); err != nil {
+		log.Printf("Error recording certificate change for %s: %v", server.BaseURI, err)
+		return
+	}
+	if change.Pinned {
+		log.Printf("Certificate of %s changed", server.BaseURI)
+	} else {
+		log.Printf("Certificate of %s changed to a certificate that isn't pinned", server.BaseURI)
+	}
+}
+
 func (s *Scheduler) getServerFromMetadata(entityID, baseURI string) *fedtls.Server {
 	parsed := s.metadataStore.GetMetadata()
 	if parsed == nil {
@@ -304,6 +390,11 @@ func (s *Scheduler) getServerFromMetadata(entityID, baseURI string) *fedtls.Serv
 }
 
 func (s *Scheduler) checkServer(entityID string, server fedtls.Server) {
+	previous, err := s.store.GetStatus(entityID, server.BaseURI)
+	if err != nil {
+		log.Printf("Error getting previous status for %s: %v", server.BaseURI, err)
+	}
+
 	result := s.checker.Check(entityID, server)
 
 	status := &store.ServerStatus{
@@ -317,10 +408,44 @@ func (s *Scheduler) checkServer(entityID string, server fedtls.Server) {
 		CertExpires:     result.CertExpires,
 		CertCN:          result.CertCN,
 		CertFingerprint: result.CertFingerprint,
+		TLSVersion:      result.TLSVersion,
+		CipherSuite:     result.CipherSuite,
+		KeyType:         result.KeyType,
+		KeyBits:         result.KeyBits,
+		CertIssuer:      result.CertIssuer,
+		CertNotBefore:   result.CertNotBefore,
+		FailureReason:   result.FailureReason,
+	}
+
+	record := &store.CheckRecord{
+		ServerKey: status.ServerKey,
+		CheckedAt: result.CheckedAt,
+		IsHealthy: result.IsHealthy,
+	}
+	if result.HandshakeDuration > 0 {
+		record.HandshakeDuration = &result.HandshakeDuration
+	}
+	if err := s.store.AddCheckRecord(record); err != nil {
+		log.Printf("Error saving check history for %s: %v", server.BaseURI, err)
+	}
+
+	if status.CertFingerprint != "" {
+		if err := s.store.RecordFingerprint(status.ServerKey, status.CertFingerprint, result.CheckedAt); err != nil {
+			log.Printf("Error recording fingerprint for %s: %v", server.BaseURI, err)
+		}
+	}
+
+	if previous != nil && previous.CertFingerprint != "" && status.CertFingerprint != "" &&
+		previous.CertFingerprint != status.CertFingerprint {
+		s.recordCertChange(previous, status, server)
 	}
 
 	if err := s.store.SaveStatus(status); err != nil {
 		log.Printf("Error saving status for %s: %v", server.BaseURI, err)
+	} else {
+		for _, observer := range s.observers {
+			observer.ServerChecked(previous, status)
+		}
 	}
 
 	statusStr := "healthy"
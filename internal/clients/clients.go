@@ -0,0 +1,168 @@
+// Package clients builds an inventory of the client pins in metadata and
+// finds common client misconfigurations.
+package clients
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+)
+
+// Pin is a client pin published by an entity
+type Pin struct {
+	Client      int      `json:"client"`
+	Description string   `json:"description,omitempty"`
+	Alg         string   `json:"alg"`
+	Digest      string   `json:"digest"`
+	Problems    []string `json:"problems,omitempty"`
+}
+
+// Entity is the clients of an entity
+type Entity struct {
+	EntityID       string   `json:"entity_id"`
+	Organization   string   `json:"organization,omitempty"`
+	OrganizationID string   `json:"organization_id,omitempty"`
+	Clients        int      `json:"clients"`
+	Issuers        int      `json:"issuers"`
+	Pins           []Pin    `json:"pins"`
+	Problems       []string `json:"problems,omitempty"`
+}
+
+// HasProblems tells if the entity or any of its pins has a problem
+func (e *Entity) HasProblems() bool {
+	if len(e.Problems) > 0 {
+		return true
+	}
+	for _, pin := range e.Pins {
+		if len(pin.Problems) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Inventory is all client pins in metadata
+type Inventory struct {
+	Entities             []Entity `json:"entities"`
+	PinCount             int      `json:"pin_count"`
+	PinsWithProblems     int      `json:"pins_with_problems"`
+	EntitiesWithProblems int      `json:"entities_with_problems"`
+}
+
+// Build creates the client inventory for metadata. Only entities with
+// clients are included.
+func Build(metadata *fedtls.Metadata) *Inventory {
+	inventory := &Inventory{Entities: []Entity{}}
+	if metadata == nil {
+		return inventory
+	}
+
+	// Which entities use each client pin, and which servers each server pin
+	clientPinUsers := make(map[string][]string)
+	serverPinUsers := make(map[string][]string)
+	for _, entity := range metadata.Entities {
+		seen := make(map[string]bool)
+		for _, client := range entity.Clients {
+			for _, pin := range client.Pins {
+				if !seen[pin.Digest] {
+					seen[pin.Digest] = true
+					clientPinUsers[pin.Digest] = append(clientPinUsers[pin.Digest], entity.EntityID)
+				}
+			}
+		}
+		for _, server := range entity.Servers {
+			for _, pin := range server.Pins {
+				serverPinUsers[pin.Digest] = append(serverPinUsers[pin.Digest], server.BaseURI)
+			}
+		}
+	}
+
+	for _, entity := range metadata.Entities {
+		if len(entity.Clients) == 0 {
+			continue
+		}
+		e := Entity{
+			EntityID: entity.EntityID,
+			Clients:  len(entity.Clients),
+			Issuers:  len(entity.Issuers),
+			Pins:     []Pin{},
+		}
+		if entity.Organization != nil {
+			e.Organization = *entity.Organization
+		}
+		if entity.OrganizationID != nil {
+			e.OrganizationID = *entity.OrganizationID
+		}
+		if len(entity.Issuers) == 0 {
+			e.Problems = append(e.Problems, "entity has clients but no issuers, servers can't verify its client certificates")
+		}
+
+		inEntity := make(map[string]int)
+		for i, client := range entity.Clients {
+			if len(client.Pins) == 0 {
+				e.Problems = append(e.Problems, fmt.Sprintf("client %d has no pins", i+1))
+			}
+			for _, pin := range client.Pins {
+				inEntity[pin.Digest]++
+			}
+		}
+
+		for i, client := range entity.Clients {
+			for _, pin := range client.Pins {
+				p := Pin{
+					Client: i + 1,
+					Alg:    pin.Alg,
+					Digest: pin.Digest,
+				}
+				if client.Description != nil {
+					p.Description = *client.Description
+				}
+				if pin.Alg != checker.PinAlg {
+					p.Problems = append(p.Problems, fmt.Sprintf("unsupported algorithm %q, only %s is supported", pin.Alg, checker.PinAlg))
+				}
+				if inEntity[pin.Digest] > 1 {
+					p.Problems = append(p.Problems, "pin is listed more than once in the entity")
+				}
+				if others := without(clientPinUsers[pin.Digest], entity.EntityID); len(others) > 0 {
+					p.Problems = append(p.Problems, "pin is also a client pin of "+strings.Join(others, ", "))
+				}
+				if servers := serverPinUsers[pin.Digest]; len(servers) > 0 {
+					p.Problems = append(p.Problems, "pin is also a server pin of "+strings.Join(servers, ", "))
+				}
+				if len(p.Problems) > 0 {
+					inventory.PinsWithProblems++
+				}
+				inventory.PinCount++
+				e.Pins = append(e.Pins, p)
+			}
+		}
+
+		if e.HasProblems() {
+			inventory.EntitiesWithProblems++
+		}
+		inventory.Entities = append(inventory.Entities, e)
+	}
+
+	sort.SliceStable(inventory.Entities, func(i, j int) bool {
+		a, b := inventory.Entities[i], inventory.Entities[j]
+		if a.Organization != b.Organization {
+			return a.Organization < b.Organization
+		}
+		return a.EntityID < b.EntityID
+	})
+	return inventory
+}
+
+// without returns the values except v
+func without(values []string, v string) []string {
+	var result []string
+	for _, value := range values {
+		if value != v {
+			result = append(result, value)
+		}
+	}
+	return result
+}
@@ -0,0 +1,87 @@
+package clients
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/joesiltberg/bowness/fedtls"
+)
+
+func hasProblem(problems []string, substring string) bool {
+	for _, p := range problems {
+		if strings.Contains(p, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBuild(t *testing.T) {
+	sha256 := func(digest string) fedtls.Pin { return fedtls.Pin{Alg: "sha256", Digest: digest} }
+	issuers := []fedtls.Issuer{{X509certificate: "cert"}}
+
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{
+		{
+			EntityID: "https://good.com",
+			Issuers:  issuers,
+			Clients:  []fedtls.Client{{Pins: []fedtls.Pin{sha256("good")}}},
+		},
+		{
+			EntityID: "https://a.com",
+			Issuers:  issuers,
+			Clients: []fedtls.Client{
+				{Pins: []fedtls.Pin{sha256("shared"), sha256("server"), {Alg: "sha1", Digest: "old"}}},
+				{Pins: []fedtls.Pin{sha256("twice"), sha256("twice")}},
+			},
+		},
+		{
+			EntityID: "https://b.com",
+			Clients:  []fedtls.Client{{Pins: []fedtls.Pin{sha256("shared")}}, {}},
+			Servers:  []fedtls.Server{{BaseURI: "https://api.b.com/", Pins: []fedtls.Pin{sha256("server")}}},
+		},
+		{EntityID: "https://noclients.com"},
+	}}
+
+	inventory := Build(metadata)
+	if len(inventory.Entities) != 3 {
+		t.Fatalf("got %d entities, want 3 (only entities with clients)", len(inventory.Entities))
+	}
+	if inventory.PinCount != 7 {
+		t.Errorf("PinCount = %d, want 7", inventory.PinCount)
+	}
+
+	entities := make(map[string]Entity)
+	for _, e := range inventory.Entities {
+		entities[e.EntityID] = e
+	}
+
+	if e := entities["https://good.com"]; e.HasProblems() {
+		t.Errorf("good entity has problems: %+v", e)
+	}
+
+	pins := make(map[string][]string)
+	for _, pin := range entities["https://a.com"].Pins {
+		pins[pin.Digest] = pin.Problems
+	}
+	if !hasProblem(pins["shared"], "client pin of https://b.com") {
+		t.Errorf("shared pin problems = %v", pins["shared"])
+	}
+	if !hasProblem(pins["server"], "server pin of https://api.b.com/") {
+		t.Errorf("server pin problems = %v", pins["server"])
+	}
+	if !hasProblem(pins["old"], "unsupported algorithm") {
+		t.Errorf("sha1 pin problems = %v", pins["old"])
+	}
+	if !hasProblem(pins["twice"], "more than once") {
+		t.Errorf("duplicate pin problems = %v", pins["twice"])
+	}
+
+	b := entities["https://b.com"]
+	if !hasProblem(b.Problems, "no issuers") || !hasProblem(b.Problems, "client 2 has no pins") {
+		t.Errorf("b.com problems = %v", b.Problems)
+	}
+
+	if inventory.EntitiesWithProblems != 2 {
+		t.Errorf("EntitiesWithProblems = %d, want 2", inventory.EntitiesWithProblems)
+	}
+}
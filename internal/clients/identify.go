@@ -0,0 +1,156 @@
+package clients
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/bowness/util"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+)
+
+// Match is a client in metadata whose pins include a certificate
+type Match struct {
+	EntityID       string   `json:"entity_id"`
+	Organization   string   `json:"organization,omitempty"`
+	OrganizationID string   `json:"organization_id,omitempty"`
+	Client         int      `json:"client"`
+	Description    string   `json:"description,omitempty"`
+	Problems       []string `json:"problems,omitempty"`
+}
+
+// Identification tells which clients in metadata a client certificate
+// belongs to, and what would stop servers from accepting it
+type Identification struct {
+	Presented   bool      `json:"presented"`
+	Subject     string    `json:"subject,omitempty"`
+	Issuer      string    `json:"issuer,omitempty"`
+	NotBefore   time.Time `json:"not_before,omitempty"`
+	NotAfter    time.Time `json:"not_after,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Matches     []Match   `json:"matches"`
+	Problems    []string  `json:"problems,omitempty"`
+}
+
+// OK tells if the certificate matches exactly one client and no problems
+// were found
+func (id *Identification) OK() bool {
+	return len(id.Problems) == 0 && len(id.Matches) == 1 && len(id.Matches[0].Problems) == 0
+}
+
+// Identify looks up a presented client certificate chain (leaf first)
+// against all client pins in metadata
+func Identify(metadata *fedtls.Metadata, chain []*x509.Certificate, now time.Time) *Identification {
+	id := &Identification{Matches: []Match{}}
+	if len(chain) == 0 {
+		id.Problems = append(id.Problems, "no client certificate was presented")
+		return id
+	}
+
+	cert := chain[0]
+	id.Presented = true
+	id.Subject = cert.Subject.String()
+	id.Issuer = cert.Issuer.String()
+	id.NotBefore = cert.NotBefore
+	id.NotAfter = cert.NotAfter
+	id.Fingerprint = util.Fingerprint(cert)
+
+	if now.After(cert.NotAfter) {
+		id.Problems = append(id.Problems, fmt.Sprintf("certificate expired %s", cert.NotAfter.Format(time.RFC3339)))
+	} else if now.Before(cert.NotBefore) {
+		id.Problems = append(id.Problems, fmt.Sprintf("certificate is not valid until %s", cert.NotBefore.Format(time.RFC3339)))
+	}
+
+	// The metadata store serves empty metadata until the first load, which
+	// would make every certificate look unpinned
+	if metadata == nil || len(metadata.Entities) == 0 {
+		id.Problems = append(id.Problems, "metadata is not loaded yet, try again shortly")
+		return id
+	}
+
+	var serverPins []string
+	for _, entity := range metadata.Entities {
+		for i, client := range entity.Clients {
+			for _, pin := range client.Pins {
+				if pin.Digest != id.Fingerprint {
+					continue
+				}
+				m := Match{EntityID: entity.EntityID, Client: i + 1}
+				if entity.Organization != nil {
+					m.Organization = *entity.Organization
+				}
+				if entity.OrganizationID != nil {
+					m.OrganizationID = *entity.OrganizationID
+				}
+				if client.Description != nil {
+					m.Description = *client.Description
+				}
+				if pin.Alg != checker.PinAlg {
+					m.Problems = append(m.Problems, fmt.Sprintf("pin uses unsupported algorithm %q, it must be %s", pin.Alg, checker.PinAlg))
+				}
+				if problem := verifyIssuers(&entity, chain, now); problem != "" {
+					m.Problems = append(m.Problems, problem)
+				}
+				id.Matches = append(id.Matches, m)
+				break
+			}
+		}
+		for _, server := range entity.Servers {
+			for _, pin := range server.Pins {
+				if pin.Digest == id.Fingerprint {
+					serverPins = append(serverPins, server.BaseURI)
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(id.Matches) == 0 && len(serverPins) > 0:
+		id.Problems = append(id.Problems, fmt.Sprintf("certificate is not pinned by any client, but is pinned as a server certificate for %v", serverPins))
+	case len(id.Matches) == 0:
+		id.Problems = append(id.Problems, fmt.Sprintf("certificate is not pinned by any client in metadata, publish the pin sha256 %s", id.Fingerprint))
+	case len(id.Matches) > 1:
+		id.Problems = append(id.Problems, "certificate is pinned by more than one client, servers can't tell which one is connecting")
+	}
+	return id
+}
+
+// verifyIssuers checks that the chain is issued by one of the entity's
+// issuers, as servers in the federation will require. Returns a problem
+// description, or the empty string if the chain verifies.
+func verifyIssuers(entity *fedtls.Entity, chain []*x509.Certificate, now time.Time) string {
+	roots := x509.NewCertPool()
+	count := 0
+	for _, issuer := range entity.Issuers {
+		block, _ := pem.Decode([]byte(issuer.X509certificate))
+		if block == nil {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		roots.AddCert(cert)
+		count++
+	}
+	if count == 0 {
+		return "entity has no usable issuers, servers can't verify the certificate"
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := chain[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err != nil {
+		return fmt.Sprintf("certificate is not issued by any of the entity's issuers: %v", err)
+	}
+	return ""
+}
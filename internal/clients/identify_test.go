@@ -0,0 +1,107 @@
+package clients
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/bowness/util"
+)
+
+// newCertificate creates a certificate signed by parent, or a self-signed
+// CA if parent is nil
+func newCertificate(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestIdentify(t *testing.T) {
+	now := time.Now()
+	ca, caKey := newCertificate(t, "CA", nil, nil)
+	otherCA, otherKey := newCertificate(t, "Other CA", nil, nil)
+	client, _ := newCertificate(t, "client", ca, caKey)
+	stranger, _ := newCertificate(t, "stranger", otherCA, otherKey)
+	server, _ := newCertificate(t, "server", ca, caKey)
+
+	issuers := []fedtls.Issuer{{X509certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))}}
+	pin := func(cert *x509.Certificate) fedtls.Pin {
+		return fedtls.Pin{Alg: "sha256", Digest: util.Fingerprint(cert)}
+	}
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{
+		{
+			EntityID: "https://a.com",
+			Issuers:  issuers,
+			Clients:  []fedtls.Client{{Pins: []fedtls.Pin{pin(client)}}},
+			Servers:  []fedtls.Server{{BaseURI: "https://api.a.com/", Pins: []fedtls.Pin{pin(server)}}},
+		},
+		{
+			EntityID: "https://b.com",
+			Issuers:  issuers,
+			Clients:  []fedtls.Client{{Pins: []fedtls.Pin{{Alg: "sha256", Digest: "other"}}}, {Pins: []fedtls.Pin{pin(stranger)}}},
+		},
+	}}
+
+	if id := Identify(metadata, nil, now); id.Presented || id.OK() || len(id.Problems) != 1 {
+		t.Errorf("no certificate: %+v", id)
+	}
+
+	id := Identify(metadata, []*x509.Certificate{client}, now)
+	if !id.OK() || id.Matches[0].EntityID != "https://a.com" || id.Fingerprint != util.Fingerprint(client) {
+		t.Errorf("pinned client: %+v", id)
+	}
+
+	id = Identify(metadata, []*x509.Certificate{stranger}, now)
+	if id.OK() || len(id.Matches) != 1 || id.Matches[0].Client != 2 || !hasProblem(id.Matches[0].Problems, "not issued by") {
+		t.Errorf("wrong issuer: %+v", id)
+	}
+
+	id = Identify(metadata, []*x509.Certificate{server}, now)
+	if id.OK() || len(id.Matches) != 0 || !hasProblem(id.Problems, "https://api.a.com/") {
+		t.Errorf("server certificate: %+v", id)
+	}
+
+	unknown, _ := newCertificate(t, "unknown", ca, caKey)
+	id = Identify(metadata, []*x509.Certificate{unknown}, now)
+	if id.OK() || !hasProblem(id.Problems, util.Fingerprint(unknown)) {
+		t.Errorf("unknown certificate: %+v", id)
+	}
+
+	// Before the first metadata load nothing can be looked up
+	id = Identify(&fedtls.Metadata{}, []*x509.Certificate{client}, now)
+	if id.OK() || len(id.Problems) != 1 || !hasProblem(id.Problems, "not loaded") {
+		t.Errorf("metadata not loaded: %+v", id)
+	}
+}
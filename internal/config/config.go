@@ -3,6 +3,7 @@ package config
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"reflect"
 	"strings"
@@ -18,12 +19,29 @@ type Config struct {
 	JWKSPath    string `yaml:"jwksPath"`
 	CachePath   string `yaml:"cachePath"`
 
+	// Metadata source monitoring
+	MetadataCheckInterval time.Duration `yaml:"metadataCheckInterval"`
+	MetadataMaxAge        time.Duration `yaml:"metadataMaxAge"`
+
 	// Database settings
-	DatabasePath string `yaml:"databasePath"`
+	DatabasePath     string        `yaml:"databasePath"`
+	HistoryRetention time.Duration `yaml:"historyRetention"`
 
 	// Web server settings
 	ListenAddress string `yaml:"listenAddress"`
 
+	// Allow candidate metadata to be uploaded and checked from the web UI.
+	// Every upload makes the monitor connect to all servers in the file.
+	CandidateUpload bool `yaml:"candidateUpload"`
+
+	// Optional TLS listener where organizations can test their client
+	// certificate against the client pins in metadata
+	ClientTest *ClientTestConfig `yaml:"clientTest"`
+
+	// Optional form and API where servers can be tested before they are
+	// published in metadata
+	ServerTest *ServerTestConfig `yaml:"serverTest"`
+
 	// Health check limits
 	MaxParallelChecks   int           `yaml:"maxParallelChecks"`
 	ChecksPerMinute     int           `yaml:"checksPerMinute"`
@@ -33,19 +51,151 @@ type Config struct {
 
 	// TLS settings
 	TLSTimeout time.Duration `yaml:"tlsTimeout"`
+
+	// How long a previously deployed pin may go without matching the
+	// server's certificate before it is shown as stale
+	StalePinAge time.Duration `yaml:"stalePinAge"`
+
+	// Notification settings
+	CertExpiryWarning time.Duration   `yaml:"certExpiryWarning"`
+	Webhooks          []WebhookConfig `yaml:"webhooks"`
+	Email             *EmailConfig    `yaml:"email"`
+
+	// Alert rules, evaluated after each check and metadata change
+	AlertRules []AlertRuleConfig `yaml:"alertRules"`
+
+	// Digest reports
+	Reports *ReportsConfig `yaml:"reports"`
+}
+
+// WebhookConfig configures a webhook receiving notifications
+type WebhookConfig struct {
+	Name        string   `yaml:"name"`
+	URL         string   `yaml:"url"`
+	Secret      string   `yaml:"secret"`
+	Events      []string `yaml:"events"`
+	MaxAttempts int      `yaml:"maxAttempts"`
+}
+
+// ClientTestConfig configures the client certificate test listener
+type ClientTestConfig struct {
+	ListenAddress string `yaml:"listenAddress"`
+	CertFile      string `yaml:"certFile"`
+	KeyFile       string `yaml:"keyFile"`
+}
+
+// ServerTestConfig configures the pre-onboarding server test. Private
+// addresses are always blocked.
+type ServerTestConfig struct {
+	Ports            []int    `yaml:"ports"`
+	PerClientPerHour int      `yaml:"perClientPerHour"`
+	TotalPerHour     int      `yaml:"totalPerHour"`
+	BlockedNetworks  []string `yaml:"blockedNetworks"`
+	BlockedHosts     []string `yaml:"blockedHosts"`
+}
+
+// BlockedIPNets returns the parsed blocked networks
+func (s *ServerTestConfig) BlockedIPNets() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range s.BlockedNetworks {
+		if _, network, err := net.ParseCIDR(cidr); err == nil {
+			networks = append(networks, network)
+		}
+	}
+	return networks
+}
+
+// EmailConfig configures email notifications
+type EmailConfig struct {
+	SMTPHost       string                 `yaml:"smtpHost"`
+	SMTPPort       int                    `yaml:"smtpPort"`
+	Username       string                 `yaml:"username"`
+	Password       string                 `yaml:"password"`
+	From           string                 `yaml:"from"`
+	Language       string                 `yaml:"language"`
+	Events         []string               `yaml:"events"`
+	MaxPerHour     int                    `yaml:"maxPerHour"`
+	DigestInterval time.Duration          `yaml:"digestInterval"`
+	Recipients     []EmailRecipientConfig `yaml:"recipients"`
+}
+
+// EmailRecipientConfig maps an entity, an organization or (with neither set)
+// all servers to a list of email addresses
+type EmailRecipientConfig struct {
+	EntityID       string   `yaml:"entityID"`
+	OrganizationID string   `yaml:"organizationID"`
+	Addresses      []string `yaml:"addresses"`
+	Language       string   `yaml:"language"`
+}
+
+// AlertRuleConfig declares an alert rule. Which parameters are used depends
+// on the type:
+//
+//   - consecutiveFailures: count
+//   - certExpiresWithin: within
+//   - issuerExpiresWithin: within
+//   - handshakeLatency: percentile, threshold, window
+//   - metadataAge: maxAge
+type AlertRuleConfig struct {
+	Name       string        `yaml:"name"`
+	Type       string        `yaml:"type"`
+	Count      int           `yaml:"count"`
+	Within     time.Duration `yaml:"within"`
+	Percentile int           `yaml:"percentile"`
+	Threshold  time.Duration `yaml:"threshold"`
+	Window     int           `yaml:"window"`
+	MaxAge     time.Duration `yaml:"maxAge"`
+	Channels   []string      `yaml:"channels"`
+}
+
+// ReportsConfig configures scheduled digest reports
+type ReportsConfig struct {
+	Daily    bool     `yaml:"daily"`
+	Weekly   bool     `yaml:"weekly"`
+	Time     string   `yaml:"time"`
+	Weekday  string   `yaml:"weekday"`
+	Channels []string `yaml:"channels"`
+}
+
+// TimeOfDay returns the configured time as a duration since midnight
+func (r *ReportsConfig) TimeOfDay() time.Duration {
+	t, err := time.Parse("15:04", r.Time)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
 }
 
+// WeekdayValue returns the day weekly reports are generated
+func (r *ReportsConfig) WeekdayValue() time.Weekday {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(d.String(), r.Weekday) {
+			return d
+		}
+	}
+	return time.Monday
+}
+
+// UptimeWindow is the period server uptime is computed over. The check
+// history must be kept at least this long.
+const UptimeWindow = 7 * 24 * time.Hour
+
 // DefaultConfig returns a Config with default values
 func DefaultConfig() *Config {
 	return &Config{
-		DatabasePath:        "./matfmonitor.db",
-		ListenAddress:       ":8080",
-		MaxParallelChecks:   5,
-		ChecksPerMinute:     20,
-		MinCheckInterval:    5 * time.Hour,
-		PriorityMinInterval: 1 * time.Minute,
-		MaxPriorityServers:  5,
-		TLSTimeout:          10 * time.Second,
+		MetadataCheckInterval: 15 * time.Minute,
+		MetadataMaxAge:        24 * time.Hour,
+		DatabasePath:          "./matfmonitor.db",
+		HistoryRetention:      30 * 24 * time.Hour,
+		ListenAddress:         ":8080",
+		MaxParallelChecks:     5,
+		ChecksPerMinute:       20,
+		MinCheckInterval:      5 * time.Hour,
+		PriorityMinInterval:   1 * time.Minute,
+		MaxPriorityServers:    5,
+		TLSTimeout:            10 * time.Second,
+		StalePinAge:           30 * 24 * time.Hour,
+		CertExpiryWarning:     14 * 24 * time.Hour,
 	}
 }
 
@@ -63,6 +213,7 @@ func Load(path string) (*Config, error) {
 	}
 
 	applyEnvOverrides(cfg)
+	cfg.applyDefaults()
 
 	if err := cfg.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -71,6 +222,27 @@ func Load(path string) (*Config, error) {
 	return cfg, nil
 }
 
+// applyDefaults fills in defaults for settings in lists and optional
+// sections, which DefaultConfig can't hold
+func (c *Config) applyDefaults() {
+	for i := range c.Webhooks {
+		if c.Webhooks[i].MaxAttempts == 0 {
+			c.Webhooks[i].MaxAttempts = 10
+		}
+	}
+	for i := range c.AlertRules {
+		rule := &c.AlertRules[i]
+		if rule.Type == "handshakeLatency" {
+			if rule.Percentile == 0 {
+				rule.Percentile = 95
+			}
+			if rule.Window == 0 {
+				rule.Window = 20
+			}
+		}
+	}
+}
+
 // Validate checks that all required configuration values are set
 func (c *Config) Validate() error {
 	if c.MetadataURL == "" {
@@ -82,6 +254,12 @@ func (c *Config) Validate() error {
 	if c.CachePath == "" {
 		return fmt.Errorf("cachePath is required")
 	}
+	if c.MetadataCheckInterval < time.Minute {
+		return fmt.Errorf("metadataCheckInterval must be at least 1 minute")
+	}
+	if c.MetadataMaxAge < c.MetadataCheckInterval {
+		return fmt.Errorf("metadataMaxAge must be at least metadataCheckInterval")
+	}
 	if c.MaxParallelChecks < 1 {
 		return fmt.Errorf("maxParallelChecks must be at least 1")
 	}
@@ -94,6 +272,211 @@ func (c *Config) Validate() error {
 	if c.TLSTimeout < time.Second {
 		return fmt.Errorf("tlsTimeout must be at least 1 second")
 	}
+	if c.StalePinAge < time.Hour {
+		return fmt.Errorf("stalePinAge must be at least 1 hour")
+	}
+	// Uptime is computed from the history, a shorter retention would prune
+	// it away on every metadata sync
+	if c.HistoryRetention < UptimeWindow {
+		return fmt.Errorf("historyRetention must be at least %d days", int(UptimeWindow.Hours()/24))
+	}
+	names := make(map[string]bool)
+	for i, wh := range c.Webhooks {
+		if wh.Name == "" {
+			return fmt.Errorf("webhooks[%d]: name is required", i)
+		}
+		if names[wh.Name] {
+			return fmt.Errorf("webhooks[%d]: duplicate name %q", i, wh.Name)
+		}
+		names[wh.Name] = true
+		if wh.URL == "" {
+			return fmt.Errorf("webhook %s: url is required", wh.Name)
+		}
+		if wh.MaxAttempts < 1 {
+			return fmt.Errorf("webhook %s: maxAttempts must be at least 1", wh.Name)
+		}
+	}
+	if c.Email != nil {
+		if err := c.Email.validate(); err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+	}
+	if c.ClientTest != nil {
+		if err := c.ClientTest.validate(); err != nil {
+			return fmt.Errorf("clientTest: %w", err)
+		}
+	}
+	if c.ServerTest != nil {
+		if err := c.ServerTest.validate(); err != nil {
+			return fmt.Errorf("serverTest: %w", err)
+		}
+	}
+	if err := c.validateAlertRules(); err != nil {
+		return err
+	}
+	if c.Reports != nil {
+		if err := c.validateReports(); err != nil {
+			return fmt.Errorf("reports: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *Config) validateReports() error {
+	r := c.Reports
+	if r.Time == "" {
+		r.Time = "07:00"
+	}
+	if _, err := time.Parse("15:04", r.Time); err != nil {
+		return fmt.Errorf("time must be HH:MM")
+	}
+	if r.Weekday == "" {
+		r.Weekday = "monday"
+	}
+	valid := false
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		valid = valid || strings.EqualFold(d.String(), r.Weekday)
+	}
+	if !valid {
+		return fmt.Errorf("unknown weekday %q", r.Weekday)
+	}
+	channels := make(map[string]bool)
+	for _, name := range c.ChannelNames() {
+		channels[name] = true
+	}
+	for _, channel := range r.Channels {
+		if !channels[channel] {
+			return fmt.Errorf("unknown channel %q", channel)
+		}
+	}
+	return nil
+}
+
+// ChannelNames returns the names of all configured notification channels
+func (c *Config) ChannelNames() []string {
+	var names []string
+	for _, wh := range c.Webhooks {
+		names = append(names, wh.Name)
+	}
+	if c.Email != nil {
+		names = append(names, "email")
+	}
+	return names
+}
+
+func (c *Config) validateAlertRules() error {
+	channels := make(map[string]bool)
+	for _, name := range c.ChannelNames() {
+		channels[name] = true
+	}
+
+	names := make(map[string]bool)
+	for i := range c.AlertRules {
+		rule := c.AlertRules[i]
+		if rule.Name == "" {
+			return fmt.Errorf("alertRules[%d]: name is required", i)
+		}
+		if names[rule.Name] {
+			return fmt.Errorf("alertRules[%d]: duplicate name %q", i, rule.Name)
+		}
+		names[rule.Name] = true
+
+		switch rule.Type {
+		case "consecutiveFailures":
+			if rule.Count < 1 {
+				return fmt.Errorf("alert rule %s: count must be at least 1", rule.Name)
+			}
+		case "certExpiresWithin", "issuerExpiresWithin":
+			if rule.Within <= 0 {
+				return fmt.Errorf("alert rule %s: within is required", rule.Name)
+			}
+		case "handshakeLatency":
+			if rule.Threshold <= 0 {
+				return fmt.Errorf("alert rule %s: threshold is required", rule.Name)
+			}
+			if rule.Percentile < 1 || rule.Percentile > 100 {
+				return fmt.Errorf("alert rule %s: percentile must be between 1 and 100", rule.Name)
+			}
+			if rule.Window < 1 {
+				return fmt.Errorf("alert rule %s: window must be at least 1", rule.Name)
+			}
+		case "metadataAge":
+			if rule.MaxAge <= 0 {
+				return fmt.Errorf("alert rule %s: maxAge is required", rule.Name)
+			}
+		default:
+			return fmt.Errorf("alert rule %s: unknown type %q", rule.Name, rule.Type)
+		}
+
+		for _, channel := range rule.Channels {
+			if !channels[channel] {
+				return fmt.Errorf("alert rule %s: unknown channel %q", rule.Name, channel)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *ClientTestConfig) validate() error {
+	if t.ListenAddress == "" {
+		return fmt.Errorf("listenAddress is required")
+	}
+	if t.CertFile == "" || t.KeyFile == "" {
+		return fmt.Errorf("certFile and keyFile are required")
+	}
+	return nil
+}
+
+func (s *ServerTestConfig) validate() error {
+	if len(s.Ports) == 0 {
+		s.Ports = []int{443}
+	}
+	for _, port := range s.Ports {
+		if port < 1 || port > 65535 {
+			return fmt.Errorf("invalid port %d", port)
+		}
+	}
+	if s.PerClientPerHour == 0 {
+		s.PerClientPerHour = 10
+	}
+	if s.TotalPerHour == 0 {
+		s.TotalPerHour = 100
+	}
+	if s.PerClientPerHour < 1 || s.TotalPerHour < 1 {
+		return fmt.Errorf("rate limits must be at least 1")
+	}
+	for _, cidr := range s.BlockedNetworks {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("blockedNetworks: %w", err)
+		}
+	}
+	return nil
+}
+
+func (e *EmailConfig) validate() error {
+	if e.SMTPHost == "" {
+		return fmt.Errorf("smtpHost is required")
+	}
+	if e.From == "" {
+		return fmt.Errorf("from is required")
+	}
+	if e.SMTPPort == 0 {
+		e.SMTPPort = 25
+	}
+	if e.Language == "" {
+		e.Language = "en"
+	}
+	if e.Language != "en" && e.Language != "sv" {
+		return fmt.Errorf("unsupported language %q (must be en or sv)", e.Language)
+	}
+	for i, r := range e.Recipients {
+		if len(r.Addresses) == 0 {
+			return fmt.Errorf("recipients[%d]: addresses is required", i)
+		}
+		if r.Language != "" && r.Language != "en" && r.Language != "sv" {
+			return fmt.Errorf("recipients[%d]: unsupported language %q (must be en or sv)", i, r.Language)
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,149 @@
+// Package issuer parses and assesses the issuer certificates entities
+// publish in metadata.
+package issuer
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/bowness/util"
+)
+
+// DefaultExpiryWarning is how long before expiry an issuer is shown as
+// expiring
+const DefaultExpiryWarning = 30 * 24 * time.Hour
+
+// Certificate is an issuer certificate published by an entity
+type Certificate struct {
+	EntityID       string
+	Organization   string
+	OrganizationID string
+
+	// Set if the certificate couldn't be parsed, the other fields are
+	// then empty
+	ParseError string
+
+	Subject     string
+	Issuer      string
+	SelfSigned  bool
+	NotBefore   time.Time
+	NotAfter    time.Time
+	KeyType     string
+	KeyBits     int
+	SigAlg      string
+	Fingerprint string
+
+	// Reasons the key or signature is considered weak, if any
+	Weaknesses []string
+}
+
+// Expired tells if the certificate is expired or not yet valid at t
+func (c *Certificate) Expired(t time.Time) bool {
+	return c.ParseError == "" && (t.After(c.NotAfter) || t.Before(c.NotBefore))
+}
+
+// ExpiresWithin tells if the certificate expires within d from t
+func (c *Certificate) ExpiresWithin(t time.Time, d time.Duration) bool {
+	return c.ParseError == "" && c.NotAfter.Sub(t) <= d
+}
+
+// Parse reads an issuer certificate. The result has ParseError set if the
+// certificate can't be parsed.
+func Parse(entity *fedtls.Entity, issuer fedtls.Issuer) Certificate {
+	c := Certificate{EntityID: entity.EntityID}
+	if entity.Organization != nil {
+		c.Organization = *entity.Organization
+	}
+	if entity.OrganizationID != nil {
+		c.OrganizationID = *entity.OrganizationID
+	}
+
+	block, _ := pem.Decode([]byte(issuer.X509certificate))
+	if block == nil {
+		c.ParseError = "not a PEM encoded certificate"
+		return c
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		c.ParseError = err.Error()
+		return c
+	}
+
+	c.Subject = cert.Subject.String()
+	c.Issuer = cert.Issuer.String()
+	c.SelfSigned = bytes.Equal(cert.RawSubject, cert.RawIssuer) && cert.CheckSignatureFrom(cert) == nil
+	c.NotBefore = cert.NotBefore
+	c.NotAfter = cert.NotAfter
+	c.SigAlg = cert.SignatureAlgorithm.String()
+	c.Fingerprint = util.Fingerprint(cert)
+	c.KeyType, c.KeyBits = KeyInfo(cert)
+	c.Weaknesses = Weaknesses(cert)
+	return c
+}
+
+// Inventory returns all issuer certificates in metadata, sorted by
+// organization, entity and expiry
+func Inventory(metadata *fedtls.Metadata) []Certificate {
+	if metadata == nil {
+		return nil
+	}
+	var certs []Certificate
+	for i := range metadata.Entities {
+		entity := &metadata.Entities[i]
+		for _, issuer := range entity.Issuers {
+			certs = append(certs, Parse(entity, issuer))
+		}
+	}
+	sort.SliceStable(certs, func(i, j int) bool {
+		a, b := certs[i], certs[j]
+		if a.Organization != b.Organization {
+			return a.Organization < b.Organization
+		}
+		if a.EntityID != b.EntityID {
+			return a.EntityID < b.EntityID
+		}
+		return a.NotAfter.Before(b.NotAfter)
+	})
+	return certs
+}
+
+// KeyInfo returns the type and size in bits of a certificate's public key
+func KeyInfo(cert *x509.Certificate) (string, int) {
+	switch key := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return "RSA", key.N.BitLen()
+	case *ecdsa.PublicKey:
+		return "ECDSA", key.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return "Ed25519", 256
+	}
+	return cert.PublicKeyAlgorithm.String(), 0
+}
+
+// Weaknesses returns the reasons a certificate's key or signature is
+// considered weak, if any
+func Weaknesses(cert *x509.Certificate) []string {
+	keyType, keyBits := KeyInfo(cert)
+	var result []string
+	switch {
+	case keyType == "RSA" && keyBits < 2048:
+		result = append(result, fmt.Sprintf("RSA key of %d bits (minimum 2048)", keyBits))
+	case keyType == "ECDSA" && keyBits < 256:
+		result = append(result, fmt.Sprintf("ECDSA key of %d bits (minimum 256)", keyBits))
+	case keyType == "DSA":
+		result = append(result, "DSA key")
+	}
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1, x509.ECDSAWithSHA1:
+		result = append(result, fmt.Sprintf("signed with %s", cert.SignatureAlgorithm))
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package issuer
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+)
+
+// newCertificate creates a PEM certificate for key, signed by parent (or
+// self-signed if parent is nil)
+func newCertificate(t *testing.T, cn string, key crypto.Signer, notAfter time.Time, parent *x509.Certificate, parentKey crypto.Signer) (string, *x509.Certificate) {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-365 * 24 * time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})), cert
+}
+
+func TestInventory(t *testing.T) {
+	now := time.Now()
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootPEM, root := newCertificate(t, "Root", ecKey, now.Add(10*365*24*time.Hour), nil, nil)
+	expiringPEM, _ := newCertificate(t, "Expiring", ecKey, now.Add(5*24*time.Hour), root, ecKey)
+	expiredPEM, _ := newCertificate(t, "Expired", ecKey, now.Add(-24*time.Hour), nil, nil)
+	weakPEM, _ := newCertificate(t, "Weak", weakKey, now.Add(365*24*time.Hour), nil, nil)
+
+	org := "Org"
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{{
+		EntityID:     "https://a.com",
+		Organization: &org,
+		Issuers: []fedtls.Issuer{
+			{X509certificate: rootPEM},
+			{X509certificate: expiringPEM},
+			{X509certificate: expiredPEM},
+			{X509certificate: weakPEM},
+			{X509certificate: "garbage"},
+		},
+	}}}
+
+	certs := Inventory(metadata)
+	if len(certs) != 5 {
+		t.Fatalf("Inventory() returned %d certificates, want 5", len(certs))
+	}
+	byCN := make(map[string]Certificate)
+	for _, c := range certs {
+		if c.Organization != "Org" || c.EntityID != "https://a.com" {
+			t.Errorf("certificate %s has organization %q, entity %q", c.Subject, c.Organization, c.EntityID)
+		}
+		byCN[c.Subject] = c
+	}
+
+	if c := byCN["CN=Root"]; !c.SelfSigned || c.KeyType != "ECDSA" || c.KeyBits != 256 || len(c.Weaknesses) != 0 {
+		t.Errorf("root = %+v", c)
+	}
+	if c := byCN["CN=Expiring"]; c.SelfSigned || !c.ExpiresWithin(now, DefaultExpiryWarning) || c.Expired(now) || c.Issuer != "CN=Root" {
+		t.Errorf("expiring = %+v", c)
+	}
+	if c := byCN["CN=Expired"]; !c.Expired(now) {
+		t.Errorf("expired = %+v", c)
+	}
+	if c := byCN["CN=Weak"]; c.KeyType != "RSA" || c.KeyBits != 1024 || len(c.Weaknesses) != 1 {
+		t.Errorf("weak = %+v", c)
+	}
+	if c := byCN[""]; c.ParseError == "" || c.Expired(now) || c.ExpiresWithin(now, DefaultExpiryWarning) {
+		t.Errorf("garbage = %+v", c)
+	}
+
+	// Sorted by expiry within the entity
+	for i := 1; i < len(certs); i++ {
+		if certs[i].NotAfter.Before(certs[i-1].NotAfter) {
+			t.Errorf("certificates not sorted by expiry")
+		}
+	}
+}
@@ -0,0 +1,158 @@
+// Package lint finds problems in federation metadata that are not TLS failures.
+package lint
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/issuer"
+)
+
+// Severity tells how serious an issue is
+type Severity string
+
+const (
+	// The server or entity can't work as intended
+	SeverityError Severity = "error"
+	// Probably a mistake, but not necessarily breaking
+	SeverityWarning Severity = "warning"
+)
+
+// Issue codes
+const (
+	CodeBaseURIInvalid       = "base-uri-invalid"
+	CodeBaseURINotHTTPS      = "base-uri-not-https"
+	CodeBaseURIDuplicate     = "base-uri-duplicate"
+	CodePinUnsupportedAlg    = "pin-unsupported-alg"
+	CodeServerNoPins         = "server-no-pins"
+	CodeServerNoUsablePins   = "server-no-usable-pins"
+	CodeEntityNoOrganization = "entity-no-organization"
+	CodeIssuerInvalid        = "issuer-invalid"
+	CodeIssuerExpired        = "issuer-expired"
+	CodeIssuerWeak           = "issuer-weak"
+)
+
+// Issue is a problem found in metadata
+type Issue struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	EntityID string   `json:"entity_id"`
+	BaseURI  string   `json:"base_uri,omitempty"`
+	Message  string   `json:"message"`
+}
+
+// Lint checks metadata and returns the issues found, errors first
+func Lint(metadata *fedtls.Metadata) []Issue {
+	if metadata == nil {
+		return nil
+	}
+
+	var issues []Issue
+	add := func(severity Severity, code, entityID, baseURI, format string, args ...any) {
+		issues = append(issues, Issue{
+			Severity: severity,
+			Code:     code,
+			EntityID: entityID,
+			BaseURI:  baseURI,
+			Message:  fmt.Sprintf(format, args...),
+		})
+	}
+
+	now := time.Now()
+
+	// Entities using each base URI, to find duplicates
+	baseURIUsers := make(map[string][]string)
+
+	for _, entity := range metadata.Entities {
+		if entity.Organization == nil || strings.TrimSpace(*entity.Organization) == "" {
+			add(SeverityWarning, CodeEntityNoOrganization, entity.EntityID, "",
+				"entity has no organization name")
+		}
+
+		for _, published := range entity.Issuers {
+			cert := issuer.Parse(&entity, published)
+			switch {
+			case cert.ParseError != "":
+				add(SeverityError, CodeIssuerInvalid, entity.EntityID, "",
+					"issuer certificate can't be parsed: %s", cert.ParseError)
+			case cert.Expired(now):
+				add(SeverityError, CodeIssuerExpired, entity.EntityID, "",
+					"issuer certificate %s is not valid (valid %s to %s)", cert.Subject,
+					cert.NotBefore.Format("2006-01-02"), cert.NotAfter.Format("2006-01-02"))
+			}
+			for _, weakness := range cert.Weaknesses {
+				add(SeverityWarning, CodeIssuerWeak, entity.EntityID, "",
+					"issuer certificate %s: %s", cert.Subject, weakness)
+			}
+		}
+
+		for _, server := range entity.Servers {
+			baseURIUsers[server.BaseURI] = append(baseURIUsers[server.BaseURI], entity.EntityID)
+
+			if _, _, err := checker.ParseBaseURI(server.BaseURI); err != nil {
+				add(SeverityError, CodeBaseURIInvalid, entity.EntityID, server.BaseURI,
+					"base_uri can't be parsed: %v", err)
+			} else if u, err := url.Parse(server.BaseURI); err == nil && !strings.EqualFold(u.Scheme, "https") {
+				add(SeverityError, CodeBaseURINotHTTPS, entity.EntityID, server.BaseURI,
+					"base_uri uses scheme %q, not https", u.Scheme)
+			}
+
+			if len(server.Pins) == 0 {
+				add(SeverityError, CodeServerNoPins, entity.EntityID, server.BaseURI,
+					"server has no pins")
+				continue
+			}
+
+			usable := 0
+			for _, pin := range server.Pins {
+				if pin.Alg == checker.PinAlg {
+					usable++
+					continue
+				}
+				add(SeverityWarning, CodePinUnsupportedAlg, entity.EntityID, server.BaseURI,
+					"pin uses unsupported algorithm %q and is ignored", pin.Alg)
+			}
+			if usable == 0 {
+				add(SeverityError, CodeServerNoUsablePins, entity.EntityID, server.BaseURI,
+					"server has no %s pin", checker.PinAlg)
+			}
+		}
+	}
+
+	for baseURI, entities := range baseURIUsers {
+		if len(entities) < 2 {
+			continue
+		}
+		for _, entityID := range entities {
+			add(SeverityWarning, CodeBaseURIDuplicate, entityID, baseURI,
+				"base_uri is used by %d servers (entities: %s)", len(entities), strings.Join(entities, ", "))
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Severity != issues[j].Severity {
+			return issues[i].Severity == SeverityError
+		}
+		if issues[i].EntityID != issues[j].EntityID {
+			return issues[i].EntityID < issues[j].EntityID
+		}
+		return issues[i].BaseURI < issues[j].BaseURI
+	})
+	return issues
+}
+
+// CountErrors returns the number of issues with error severity
+func CountErrors(issues []Issue) int {
+	n := 0
+	for _, issue := range issues {
+		if issue.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,79 @@
+package lint
+
+import (
+	"testing"
+
+	"github.com/joesiltberg/bowness/fedtls"
+)
+
+func codes(issues []Issue, entityID, baseURI string) map[string]bool {
+	result := make(map[string]bool)
+	for _, issue := range issues {
+		if issue.EntityID == entityID && issue.BaseURI == baseURI {
+			result[issue.Code] = true
+		}
+	}
+	return result
+}
+
+func TestLint(t *testing.T) {
+	org := "Example"
+	sha256Pin := fedtls.Pin{Alg: "sha256", Digest: "abc"}
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{
+		{
+			EntityID:     "https://good.com",
+			Organization: &org,
+			Servers:      []fedtls.Server{{BaseURI: "https://api.good.com/", Pins: []fedtls.Pin{sha256Pin}}},
+		},
+		{
+			EntityID: "https://bad.com",
+			Issuers:  []fedtls.Issuer{{X509certificate: "garbage"}},
+			Servers: []fedtls.Server{
+				{BaseURI: "http://api.bad.com/", Pins: []fedtls.Pin{sha256Pin}},
+				{BaseURI: "https://%zz", Pins: []fedtls.Pin{sha256Pin}},
+				{BaseURI: "https://nopins.bad.com/"},
+				{BaseURI: "https://sha1.bad.com/", Pins: []fedtls.Pin{{Alg: "sha1", Digest: "abc"}}},
+				{BaseURI: "https://api.good.com/", Pins: []fedtls.Pin{sha256Pin}},
+			},
+		},
+	}}
+
+	issues := Lint(metadata)
+
+	if got := codes(issues, "https://good.com", ""); len(got) != 0 {
+		t.Errorf("good entity has issues: %v", got)
+	}
+
+	tests := []struct {
+		entityID, baseURI, code string
+	}{
+		{"https://bad.com", "", CodeEntityNoOrganization},
+		{"https://bad.com", "", CodeIssuerInvalid},
+		{"https://bad.com", "http://api.bad.com/", CodeBaseURINotHTTPS},
+		{"https://bad.com", "https://%zz", CodeBaseURIInvalid},
+		{"https://bad.com", "https://nopins.bad.com/", CodeServerNoPins},
+		{"https://bad.com", "https://sha1.bad.com/", CodePinUnsupportedAlg},
+		{"https://bad.com", "https://sha1.bad.com/", CodeServerNoUsablePins},
+		{"https://bad.com", "https://api.good.com/", CodeBaseURIDuplicate},
+		{"https://good.com", "https://api.good.com/", CodeBaseURIDuplicate},
+	}
+	for _, tt := range tests {
+		if !codes(issues, tt.entityID, tt.baseURI)[tt.code] {
+			t.Errorf("missing %s for %s %s", tt.code, tt.entityID, tt.baseURI)
+		}
+	}
+
+	// Errors are sorted first
+	seenWarning := false
+	for _, issue := range issues {
+		if issue.Severity == SeverityWarning {
+			seenWarning = true
+		} else if seenWarning {
+			t.Errorf("error %s sorted after a warning", issue.Code)
+		}
+	}
+
+	if CountErrors(issues) != 5 {
+		t.Errorf("CountErrors() = %d, want 5", CountErrors(issues))
+	}
+}
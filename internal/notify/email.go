@@ -0,0 +1,457 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Recipient maps servers to email addresses. A recipient with an EntityID
+// receives notifications about that entity's servers, one with an
+// OrganizationID about all servers of the organization's entities. A
+// recipient with neither receives all notifications.
+type Recipient struct {
+	EntityID       string
+	OrganizationID string
+	Addresses      []string
+	Language       string
+}
+
+func (r *Recipient) matches(event Event) bool {
+	if r.EntityID != "" && r.EntityID != event.EntityID {
+		return false
+	}
+	if r.OrganizationID != "" && r.OrganizationID != event.OrganizationID {
+		return false
+	}
+	return true
+}
+
+// pendingMail holds the events waiting to be sent to one address
+type pendingMail struct {
+	language string
+	events   []Event
+	since    time.Time
+}
+
+// EmailChannel sends notifications by email. Events are collected per
+// address and sent by a background goroutine, either right away or, in
+// digest mode, as one message per digest interval. Addresses that have
+// reached the hourly rate limit get the held events in a single message
+// once the limit allows.
+type EmailChannel struct {
+	smtpAddr        string
+	auth            smtp.Auth
+	from            string
+	defaultLanguage string
+	recipients      []Recipient
+	events          map[EventType]bool
+	maxPerHour      int
+	digestInterval  time.Duration
+
+	// Replaced in tests
+	sendMail func(addr string, a smtp.Auth, from string, to []string, msg []byte) error
+
+	lock    sync.Mutex
+	pending map[string]*pendingMail
+	sent    map[string][]time.Time
+	wake    chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewEmailChannel creates a new EmailChannel. A digestInterval of zero sends
+// each notification as soon as possible, maxPerHour of zero disables rate
+// limiting. If events is empty all event types are accepted.
+func NewEmailChannel(
+	smtpAddr string,
+	username string,
+	password string,
+	from string,
+	defaultLanguage string,
+	recipients []Recipient,
+	events []string,
+	maxPerHour int,
+	digestInterval time.Duration,
+) *EmailChannel {
+	var auth smtp.Auth
+	if username != "" {
+		host := smtpAddr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	accepted := make(map[EventType]bool, len(events))
+	for _, e := range events {
+		accepted[EventType(e)] = true
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	return &EmailChannel{
+		smtpAddr:        smtpAddr,
+		auth:            auth,
+		from:            from,
+		defaultLanguage: defaultLanguage,
+		recipients:      recipients,
+		events:          accepted,
+		maxPerHour:      maxPerHour,
+		digestInterval:  digestInterval,
+		sendMail:        smtp.SendMail,
+		pending:         make(map[string]*pendingMail),
+		sent:            make(map[string][]time.Time),
+		wake:            make(chan struct{}, 1),
+		ctx:             ctx,
+		cancel:          cancel,
+	}
+}
+
+// Name returns the channel name used in the configuration
+func (e *EmailChannel) Name() string {
+	return "email"
+}
+
+// Accepts tells whether the channel is subscribed to the event type
+func (e *EmailChannel) Accepts(eventType EventType) bool {
+	return len(e.events) == 0 || e.events[eventType]
+}
+
+// Send queues the event for every address mapped to the event's server,
+// once per address even if it is listed in several mappings
+func (e *EmailChannel) Send(event Event) error {
+	e.lock.Lock()
+	queued := make(map[string]bool)
+	for i := range e.recipients {
+		recipient := &e.recipients[i]
+		if !recipient.matches(event) {
+			continue
+		}
+		language := recipient.Language
+		if language == "" {
+			language = e.defaultLanguage
+		}
+		for _, address := range recipient.Addresses {
+			if queued[address] {
+				continue
+			}
+			p, ok := e.pending[address]
+			if !ok {
+				p = &pendingMail{language: language, since: time.Now()}
+				e.pending[address] = p
+			}
+			p.events = append(p.events, event)
+			queued[address] = true
+		}
+	}
+	e.lock.Unlock()
+
+	if len(queued) > 0 && e.digestInterval == 0 {
+		select {
+		case e.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// SendReport sends a report to the recipients mapped to all servers, with
+// the Markdown version as the plain text body and the HTML version as an
+// alternative. A failed address doesn't stop delivery to the others.
+func (e *EmailChannel) SendReport(subject, markdown, html string) error {
+	now := time.Now()
+	var errs []error
+	for _, recipient := range e.recipients {
+		if recipient.EntityID != "" || recipient.OrganizationID != "" {
+			continue
+		}
+		for _, address := range recipient.Addresses {
+			msg, err := e.composeReport(address, subject, markdown, html, now)
+			if err == nil {
+				err = e.sendMail(e.smtpAddr, e.auth, e.from, []string{address}, msg)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", address, err))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// composeReport renders a report message, multipart/alternative with plain
+// text and HTML, or plain text only without HTML
+func (e *EmailChannel) composeReport(to, subject, markdown, html string, now time.Time) ([]byte, error) {
+	if html == "" {
+		msg := e.header(to, subject, "text/plain; charset=utf-8", now)
+		msg.WriteString(crlf(markdown))
+		return msg.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", markdown},
+		{"text/html; charset=utf-8", html},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"8bit"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		w.Write([]byte(crlf(part.content)))
+	}
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	msg := e.header(to, subject, "multipart/alternative; boundary="+parts.Boundary(), now)
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+// crlf converts line endings for a message body
+func crlf(s string) string {
+	return strings.ReplaceAll(s, "\n", "\r\n")
+}
+
+// Start begins sending queued notifications
+func (e *EmailChannel) Start() {
+	e.wg.Add(1)
+	go e.run()
+}
+
+// Stop stops sending, waits for an ongoing send to finish and then sends
+// the notifications still queued, without waiting for digests to be due
+func (e *EmailChannel) Stop() {
+	e.cancel()
+	e.wg.Wait()
+	e.flush(time.Now(), true)
+}
+
+func (e *EmailChannel) run() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.ctx.Done():
+			return
+		case <-e.wake:
+		case <-ticker.C:
+		}
+		e.flush(time.Now(), false)
+	}
+}
+
+// flush sends the pending messages that are due and allowed by the rate
+// limit. The final flush on shutdown sends all pending messages, since
+// held notifications would otherwise be lost.
+func (e *EmailChannel) flush(now time.Time, final bool) {
+	type outgoing struct {
+		address string
+		mail    *pendingMail
+	}
+	var due []outgoing
+
+	e.lock.Lock()
+	for address, p := range e.pending {
+		if !final && e.digestInterval > 0 && now.Sub(p.since) < e.digestInterval {
+			continue
+		}
+		if !e.allowLocked(address, now) && !final {
+			continue
+		}
+		due = append(due, outgoing{address, p})
+		delete(e.pending, address)
+	}
+	e.lock.Unlock()
+
+	for _, o := range due {
+		msg, err := e.compose(o.address, o.mail.language, o.mail.events, now)
+		if err != nil {
+			log.Printf("Error composing email to %s: %v", o.address, err)
+			continue
+		}
+		if err := e.sendMail(e.smtpAddr, e.auth, e.from, []string{o.address}, msg); err != nil {
+			log.Printf("Error sending email to %s: %v", o.address, err)
+		}
+	}
+}
+
+// allowLocked records a message to address if the rate limit allows it.
+// Must be called with e.lock held.
+func (e *EmailChannel) allowLocked(address string, now time.Time) bool {
+	if e.maxPerHour <= 0 {
+		return true
+	}
+	recent := e.sent[address][:0]
+	for _, t := range e.sent[address] {
+		if now.Sub(t) < time.Hour {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= e.maxPerHour {
+		e.sent[address] = recent
+		return false
+	}
+	e.sent[address] = append(recent, now)
+	return true
+}
+
+// compose renders a complete message with headers
+func (e *EmailChannel) compose(to, language string, events []Event, now time.Time) ([]byte, error) {
+	templates, ok := emailTemplates[language]
+	if !ok {
+		templates = emailTemplates["en"]
+	}
+
+	data := emailData{Events: events, Language: language}
+	if len(events) == 1 {
+		data.Event = &events[0]
+	}
+
+	var subject, body bytes.Buffer
+	if err := templates.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, err
+	}
+	if err := templates.ExecuteTemplate(&body, "body", data); err != nil {
+		return nil, err
+	}
+
+	msg := e.header(to, strings.TrimSpace(subject.String()), "text/plain; charset=utf-8", now)
+	msg.WriteString(crlf(body.String()))
+	return msg.Bytes(), nil
+}
+
+// header returns the headers of a message, ending with the blank line
+// before the body
+func (e *EmailChannel) header(to, subject, contentType string, now time.Time) *bytes.Buffer {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", to)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: %s\r\n", contentType)
+	msg.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	msg.WriteString("\r\n")
+	return &msg
+}
+
+// emailData is the data passed to the email templates. Event is set when the
+// message contains a single event.
+type emailData struct {
+	Event    *Event
+	Events   []Event
+	Language string
+}
+
+var eventTitles = map[string]map[EventType]string{
+	"en": {
+		EventUnhealthy:     "Server unhealthy",
+		EventRecovered:     "Server recovered",
+		EventCertChanged:   "Certificate changed",
+		EventCertExpiring:  "Certificate expires soon",
+		EventAlertFiring:   "Alert",
+		EventAlertResolved: "Alert resolved",
+	},
+	"sv": {
+		EventUnhealthy:     "Servern fungerar inte",
+		EventRecovered:     "Servern fungerar igen",
+		EventCertChanged:   "Certifikatet har bytts",
+		EventCertExpiring:  "Certifikatet går snart ut",
+		EventAlertFiring:   "Larm",
+		EventAlertResolved: "Larmet har upphört",
+	},
+}
+
+func newEmailTemplate(language, text string) *template.Template {
+	funcs := template.FuncMap{
+		"title": func(event Event) string {
+			if title, ok := eventTitles[language][event.Type]; ok {
+				return title
+			}
+			return event.Message
+		},
+		"time": func(t time.Time) string {
+			return t.Format("2006-01-02 15:04:05 MST")
+		},
+		"unpinned": func(event Event) bool {
+			return event.CertPinned != nil && !*event.CertPinned
+		},
+	}
+	return template.Must(template.New(language).Funcs(funcs).Parse(text))
+}
+
+var emailTemplates = map[string]*template.Template{
+	"en": newEmailTemplate("en", `
+{{define "subject"}}[matfmonitor] {{if .Event}}{{title .Event}}: {{if .Event.Rule}}{{.Event.Rule}} {{end}}{{.Event.BaseURI}}{{else}}{{len .Events}} notifications{{end}}{{end}}
+{{define "event"}}{{title .}}
+{{- if .Rule}}
+Rule:         {{.Rule}}
+Details:      {{.Message}}{{end}}
+{{- if .EntityID}}
+Organization: {{if .Organization}}{{.Organization}}{{else}}-{{end}}{{if .OrganizationID}} ({{.OrganizationID}}){{end}}
+Entity:       {{.EntityID}}
+Server:       {{.BaseURI}}{{end}}
+Time:         {{time .OccurredAt}}
+{{- if .ErrorMessage}}
+Error:        {{.ErrorMessage}}{{end}}
+{{- if .CertExpires}}
+Certificate expires: {{time .CertExpires}}{{end}}
+{{- if .PreviousCertFingerprint}}
+Previous certificate: {{.PreviousCertCN}}, fingerprint {{.PreviousCertFingerprint}}{{if .PreviousCertExpires}}, expires {{time .PreviousCertExpires}}{{end}}
+New certificate:      {{.CertCN}}, fingerprint {{.CertFingerprint}}
+{{- if unpinned .}}
+The new certificate is NOT pinned in metadata.{{end}}{{end}}
+{{end}}
+{{define "body"}}{{if .Event}}{{template "event" .Event}}{{else}}The following has happened since the last message:
+{{range .Events}}
+{{template "event" .}}{{end}}{{end}}
+--
+This message was sent by matfmonitor.
+{{end}}`),
+	"sv": newEmailTemplate("sv", `
+{{define "subject"}}[matfmonitor] {{if .Event}}{{title .Event}}: {{if .Event.Rule}}{{.Event.Rule}} {{end}}{{.Event.BaseURI}}{{else}}{{len .Events}} notiser{{end}}{{end}}
+{{define "event"}}{{title .}}
+{{- if .Rule}}
+Regel:        {{.Rule}}
+Detaljer:     {{.Message}}{{end}}
+{{- if .EntityID}}
+Organisation: {{if .Organization}}{{.Organization}}{{else}}-{{end}}{{if .OrganizationID}} ({{.OrganizationID}}){{end}}
+Entitet:      {{.EntityID}}
+Server:       {{.BaseURI}}{{end}}
+Tid:          {{time .OccurredAt}}
+{{- if .ErrorMessage}}
+Fel:          {{.ErrorMessage}}{{end}}
+{{- if .CertExpires}}
+Certifikatet går ut: {{time .CertExpires}}{{end}}
+{{- if .PreviousCertFingerprint}}
+Tidigare certifikat: {{.PreviousCertCN}}, fingeravtryck {{.PreviousCertFingerprint}}{{if .PreviousCertExpires}}, går ut {{time .PreviousCertExpires}}{{end}}
+Nytt certifikat:     {{.CertCN}}, fingeravtryck {{.CertFingerprint}}
+{{- if unpinned .}}
+Det nya certifikatet är INTE pinnat i metadata.{{end}}{{end}}
+{{end}}
+{{define "body"}}{{if .Event}}{{template "event" .Event}}{{else}}Följande har hänt sedan förra meddelandet:
+{{range .Events}}
+{{template "event" .}}{{end}}{{end}}
+--
+Detta meddelande skickades av matfmonitor.
+{{end}}`),
+}
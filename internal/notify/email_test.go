@@ -0,0 +1,260 @@
+package notify
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// smtpStandIn is a minimal SMTP server accepting all mail
+type smtpStandIn struct {
+	listener net.Listener
+	lock     sync.Mutex
+	messages []string
+}
+
+func newSMTPStandIn(t *testing.T) *smtpStandIn {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	s := &smtpStandIn{listener: listener}
+	go s.serve()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *smtpStandIn) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *smtpStandIn) handle(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		cmd := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case cmd == "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.lock.Lock()
+			s.messages = append(s.messages, data.String())
+			s.lock.Unlock()
+			reply("250 ok")
+		case cmd == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+func (s *smtpStandIn) received() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string(nil), s.messages...)
+}
+
+func TestEmailChannelSends(t *testing.T) {
+	smtpServer := newSMTPStandIn(t)
+
+	channel := NewEmailChannel(
+		smtpServer.listener.Addr().String(), "", "", "monitor@example.com", "en",
+		[]Recipient{
+			{OrganizationID: "SE0000000001", Addresses: []string{"ops@example.se"}, Language: "sv"},
+			{EntityID: "https://other.example.com", Addresses: []string{"other@example.com"}},
+		},
+		nil, 0, 0,
+	)
+
+	channel.Send(Event{
+		Type:           EventUnhealthy,
+		EntityID:       "https://entity.example.se",
+		BaseURI:        "https://api.example.se",
+		OrganizationID: "SE0000000001",
+		ErrorMessage:   "TLS connection failed",
+		OccurredAt:     time.Now(),
+	})
+	channel.flush(time.Now(), false)
+
+	messages := smtpServer.received()
+	if len(messages) != 1 {
+		t.Fatalf("received %d messages, want 1", len(messages))
+	}
+	if !strings.Contains(messages[0], "To: ops@example.se") {
+		t.Errorf("message not addressed to ops@example.se:\n%s", messages[0])
+	}
+	if !strings.Contains(messages[0], "Servern fungerar inte") {
+		t.Errorf("message not in Swedish:\n%s", messages[0])
+	}
+	if !strings.Contains(messages[0], "TLS connection failed") {
+		t.Errorf("message does not contain error:\n%s", messages[0])
+	}
+}
+
+func TestEmailChannelRateLimitAndDigest(t *testing.T) {
+	var sent []string
+	channel := NewEmailChannel("localhost:25", "", "", "monitor@example.com", "en",
+		[]Recipient{{Addresses: []string{"ops@example.com"}}}, nil, 1, 0)
+	channel.sendMail = func(addr string, _ smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, string(msg))
+		return nil
+	}
+
+	now := time.Now()
+	channel.Send(Event{Type: EventUnhealthy, BaseURI: "https://a.example.com", OccurredAt: now})
+	channel.flush(now, false)
+
+	// Rate limit reached, both events are held
+	channel.Send(Event{Type: EventUnhealthy, BaseURI: "https://b.example.com", OccurredAt: now})
+	channel.Send(Event{Type: EventRecovered, BaseURI: "https://a.example.com", OccurredAt: now})
+	channel.flush(now.Add(time.Minute), false)
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages within rate limit, want 1", len(sent))
+	}
+
+	// After an hour the held events are sent as one message
+	channel.flush(now.Add(time.Hour+time.Minute), false)
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages after rate limit window, want 2", len(sent))
+	}
+	if !strings.Contains(sent[1], "2 notifications") {
+		t.Errorf("held events not combined:\n%s", sent[1])
+	}
+}
+
+func TestEmailChannelDeduplicatesAddresses(t *testing.T) {
+	var sent []string
+	channel := NewEmailChannel("localhost:25", "", "", "monitor@example.com", "en",
+		[]Recipient{
+			{OrganizationID: "SE0000000001", Addresses: []string{"ops@example.se"}},
+			{Addresses: []string{"ops@example.se", "all@example.com"}},
+		}, nil, 0, 0)
+	channel.sendMail = func(addr string, _ smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, string(msg))
+		return nil
+	}
+
+	channel.Send(Event{Type: EventUnhealthy, BaseURI: "https://a.example.se", OrganizationID: "SE0000000001", OccurredAt: time.Now()})
+	channel.flush(time.Now(), false)
+	if len(sent) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(sent))
+	}
+	for _, msg := range sent {
+		if strings.Contains(msg, "2 notifications") {
+			t.Errorf("event queued twice for an address:\n%s", msg)
+		}
+	}
+}
+
+func TestEmailChannelStopSendsDigest(t *testing.T) {
+	var sent []string
+	channel := NewEmailChannel("localhost:25", "", "", "monitor@example.com", "en",
+		[]Recipient{{Addresses: []string{"ops@example.com"}}}, nil, 0, time.Hour)
+	channel.sendMail = func(addr string, _ smtp.Auth, from string, to []string, msg []byte) error {
+		sent = append(sent, string(msg))
+		return nil
+	}
+
+	channel.Send(Event{Type: EventUnhealthy, BaseURI: "https://a.example.com", OccurredAt: time.Now()})
+	channel.flush(time.Now(), false)
+	if len(sent) != 0 {
+		t.Fatalf("sent %d messages before the digest was due, want 0", len(sent))
+	}
+
+	channel.Stop()
+	if len(sent) != 1 {
+		t.Fatalf("sent %d messages on stop, want 1", len(sent))
+	}
+}
+
+func TestEmailChannelSendReport(t *testing.T) {
+	sent := make(map[string]string)
+	channel := NewEmailChannel("localhost:25", "", "", "monitor@example.com", "en",
+		[]Recipient{
+			{Addresses: []string{"broken@example.com", "ops@example.com"}},
+			{EntityID: "https://entity.example.com", Addresses: []string{"entity@example.com"}},
+		}, nil, 0, 0)
+	channel.sendMail = func(addr string, _ smtp.Auth, from string, to []string, msg []byte) error {
+		if to[0] == "broken@example.com" {
+			return errors.New("mailbox unavailable")
+		}
+		sent[to[0]] = string(msg)
+		return nil
+	}
+
+	err := channel.SendReport("Daily report", "# Report\n", "<h1>Report</h1>\n")
+	if err == nil || !strings.Contains(err.Error(), "broken@example.com") {
+		t.Errorf("SendReport() error = %v, want error for broken@example.com", err)
+	}
+	if len(sent) != 1 {
+		t.Fatalf("sent %d reports, want 1", len(sent))
+	}
+	msg, ok := sent["ops@example.com"]
+	if !ok {
+		t.Fatal("report not sent to the address after the failed one")
+	}
+	for _, want := range []string{"multipart/alternative", "text/plain; charset=utf-8", "# Report\r\n", "text/html; charset=utf-8", "<h1>Report</h1>"} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("report is missing %q:\n%s", want, msg)
+		}
+	}
+}
+
+func TestComposeCertChanged(t *testing.T) {
+	channel := NewEmailChannel("localhost:25", "", "", "monitor@example.com", "en", nil, nil, 0, 0)
+	pinned := false
+	expires := time.Now()
+	message, err := channel.compose("ops@example.com", "en", []Event{{
+		Type:                    EventCertChanged,
+		EntityID:                "https://entity.example.com",
+		BaseURI:                 "https://api.example.com",
+		CertCN:                  "new.example.com",
+		CertFingerprint:         "new",
+		PreviousCertCN:          "old.example.com",
+		PreviousCertFingerprint: "old",
+		PreviousCertExpires:     &expires,
+		CertPinned:              &pinned,
+		OccurredAt:              time.Now(),
+	}}, time.Now())
+	if err != nil {
+		t.Fatalf("compose() error = %v", err)
+	}
+	for _, want := range []string{"old.example.com, fingerprint old", "new.example.com, fingerprint new", "NOT pinned"} {
+		if !strings.Contains(string(message), want) {
+			t.Errorf("message does not contain %q:\n%s", want, message)
+		}
+	}
+}
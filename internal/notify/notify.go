@@ -0,0 +1,255 @@
+// Package notify detects health state transitions and delivers notifications about them.
+package notify
+
+import (
+	"log"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// EventType identifies the kind of transition an event describes
+type EventType string
+
+const (
+	EventUnhealthy    EventType = "server.unhealthy"
+	EventRecovered    EventType = "server.recovered"
+	EventCertChanged  EventType = "cert.changed"
+	EventCertExpiring EventType = "cert.expiring"
+
+	// Produced by alert rules, see package alert
+	EventAlertFiring   EventType = "alert.firing"
+	EventAlertResolved EventType = "alert.resolved"
+)
+
+// Event describes a notable change in a server's state
+type Event struct {
+	Type                    EventType  `json:"type"`
+	Rule                    string     `json:"rule,omitempty"`
+	EntityID                string     `json:"entity_id"`
+	BaseURI                 string     `json:"base_uri"`
+	Organization            string     `json:"organization,omitempty"`
+	OrganizationID          string     `json:"organization_id,omitempty"`
+	Message                 string     `json:"message"`
+	ErrorMessage            string     `json:"error_message,omitempty"`
+	CertCN                  string     `json:"cert_cn,omitempty"`
+	CertFingerprint         string     `json:"cert_fingerprint,omitempty"`
+	PreviousCertFingerprint string     `json:"previous_cert_fingerprint,omitempty"`
+	PreviousCertCN          string     `json:"previous_cert_cn,omitempty"`
+	PreviousCertExpires     *time.Time `json:"previous_cert_expires,omitempty"`
+	CertExpires             *time.Time `json:"cert_expires,omitempty"`
+	CertPinned              *bool      `json:"cert_pinned,omitempty"`
+	OccurredAt              time.Time  `json:"occurred_at"`
+}
+
+// Channel is a destination for notifications
+type Channel interface {
+	Name() string
+	Accepts(eventType EventType) bool
+	Send(event Event) error
+}
+
+// ReportSender is implemented by channels that can deliver periodic reports
+type ReportSender interface {
+	SendReport(subject, markdown, html string) error
+}
+
+// Notifier turns completed health checks into events and passes them on to channels
+type Notifier struct {
+	metadataStore     *fedtls.MetadataStore
+	certExpiryWarning time.Duration
+	channels          []Channel
+}
+
+// NewNotifier creates a new Notifier. Certificates expiring within
+// certExpiryWarning generate a cert.expiring event.
+func NewNotifier(metadataStore *fedtls.MetadataStore, certExpiryWarning time.Duration, channels ...Channel) *Notifier {
+	return &Notifier{
+		metadataStore:     metadataStore,
+		certExpiryWarning: certExpiryWarning,
+		channels:          channels,
+	}
+}
+
+// ServerChecked is called by the scheduler after each completed health check
+func (n *Notifier) ServerChecked(previous, current *store.ServerStatus) {
+	for _, event := range DetectTransitions(previous, current, n.certExpiryWarning) {
+		if event.Type == EventCertChanged {
+			n.addPinned(&event)
+		}
+		n.Publish(event)
+	}
+}
+
+// addPinned tells in a cert.changed event whether the new certificate is
+// pinned in metadata
+func (n *Notifier) addPinned(event *Event) {
+	if n.metadataStore == nil {
+		return
+	}
+	metadata := n.metadataStore.GetMetadata()
+	if metadata == nil {
+		return
+	}
+	for _, entity := range metadata.Entities {
+		if entity.EntityID != event.EntityID {
+			continue
+		}
+		for _, server := range entity.Servers {
+			if server.BaseURI == event.BaseURI {
+				pinned := checker.MatchesPin(event.CertFingerprint, server.Pins)
+				event.CertPinned = &pinned
+				if !pinned {
+					event.Message = "server certificate changed to a certificate that isn't pinned in metadata"
+				}
+				return
+			}
+		}
+	}
+}
+
+// Publish adds organization details to an event and sends it to every channel accepting it
+func (n *Notifier) Publish(event Event) {
+	n.PublishTo(event, nil)
+}
+
+// PublishTo adds organization details to an event and sends it to the named
+// channels, regardless of which event types they are subscribed to. If no
+// channels are named, the event goes to every channel accepting it.
+func (n *Notifier) PublishTo(event Event, channelNames []string) {
+	if event.Organization == "" && event.OrganizationID == "" && event.EntityID != "" {
+		event.Organization, event.OrganizationID = n.lookupOrganization(event.EntityID)
+	}
+
+	named := make(map[string]bool, len(channelNames))
+	for _, name := range channelNames {
+		named[name] = true
+	}
+
+	for _, channel := range n.channels {
+		if len(named) > 0 && !named[channel.Name()] {
+			continue
+		}
+		if len(named) == 0 && !channel.Accepts(event.Type) {
+			continue
+		}
+		if err := channel.Send(event); err != nil {
+			log.Printf("Error sending %s notification to %s: %v", event.Type, channel.Name(), err)
+		}
+	}
+}
+
+// PublishReport sends a report to the named channels, or all channels if
+// none are named. Channels that can't deliver reports are skipped.
+func (n *Notifier) PublishReport(subject, markdown, html string, channelNames []string) {
+	named := make(map[string]bool, len(channelNames))
+	for _, name := range channelNames {
+		named[name] = true
+	}
+
+	for _, channel := range n.channels {
+		if len(named) > 0 && !named[channel.Name()] {
+			continue
+		}
+		sender, ok := channel.(ReportSender)
+		if !ok {
+			continue
+		}
+		if err := sender.SendReport(subject, markdown, html); err != nil {
+			log.Printf("Error sending report to %s: %v", channel.Name(), err)
+		}
+	}
+}
+
+func (n *Notifier) lookupOrganization(entityID string) (string, string) {
+	if n.metadataStore == nil {
+		return "", ""
+	}
+	metadata := n.metadataStore.GetMetadata()
+	if metadata == nil {
+		return "", ""
+	}
+	for _, entity := range metadata.Entities {
+		if entity.EntityID != entityID {
+			continue
+		}
+		org, orgID := "", ""
+		if entity.Organization != nil {
+			org = *entity.Organization
+		}
+		if entity.OrganizationID != nil {
+			orgID = *entity.OrganizationID
+		}
+		return org, orgID
+	}
+	return "", ""
+}
+
+// DetectTransitions compares a server's status before and after a check and
+// returns the events that should be notified. Health transitions are only
+// reported when the previous state is known, so the first check of a new
+// server never produces an unhealthy or recovered event.
+func DetectTransitions(previous, current *store.ServerStatus, certExpiryWarning time.Duration) []Event {
+	if current == nil || current.IsHealthy == nil {
+		return nil
+	}
+
+	occurredAt := time.Now()
+	if current.LastChecked != nil {
+		occurredAt = *current.LastChecked
+	}
+
+	newEvent := func(eventType EventType, message string) Event {
+		return Event{
+			Type:            eventType,
+			EntityID:        current.EntityID,
+			BaseURI:         current.BaseURI,
+			Message:         message,
+			ErrorMessage:    current.ErrorMessage,
+			CertCN:          current.CertCN,
+			CertFingerprint: current.CertFingerprint,
+			CertExpires:     current.CertExpires,
+			OccurredAt:      occurredAt,
+		}
+	}
+
+	var events []Event
+
+	if previous != nil && previous.IsHealthy != nil && *previous.IsHealthy != *current.IsHealthy {
+		if *current.IsHealthy {
+			events = append(events, newEvent(EventRecovered, "server has recovered"))
+		} else {
+			events = append(events, newEvent(EventUnhealthy, "server became unhealthy"))
+		}
+	}
+
+	if previous != nil && previous.CertFingerprint != "" && current.CertFingerprint != "" &&
+		previous.CertFingerprint != current.CertFingerprint {
+		event := newEvent(EventCertChanged, "server certificate changed")
+		event.PreviousCertFingerprint = previous.CertFingerprint
+		event.PreviousCertCN = previous.CertCN
+		event.PreviousCertExpires = previous.CertExpires
+		events = append(events, event)
+	}
+
+	if current.CertExpires != nil && certExpiryWarning > 0 &&
+		current.CertExpires.Sub(occurredAt) <= certExpiryWarning &&
+		!wasExpiring(previous, *current.CertExpires, certExpiryWarning) {
+		events = append(events, newEvent(EventCertExpiring, "server certificate expires soon"))
+	}
+
+	return events
+}
+
+// wasExpiring tells whether the previous check already saw the same
+// certificate expiry inside the warning window, in which case it has
+// already been notified
+func wasExpiring(previous *store.ServerStatus, expires time.Time, certExpiryWarning time.Duration) bool {
+	if previous == nil || previous.CertExpires == nil || previous.LastChecked == nil {
+		return false
+	}
+	return previous.CertExpires.Equal(expires) &&
+		previous.CertExpires.Sub(*previous.LastChecked) <= certExpiryWarning
+}
@@ -0,0 +1,173 @@
+package notify
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func status(healthy *bool, fingerprint string, checked time.Time, expires *time.Time) *store.ServerStatus {
+	return &store.ServerStatus{
+		ServerKey:       store.ServerKey{EntityID: "https://entity.com", BaseURI: "https://api.entity.com"},
+		LastChecked:     &checked,
+		IsHealthy:       healthy,
+		CertFingerprint: fingerprint,
+		CertExpires:     expires,
+	}
+}
+
+func eventTypes(events []Event) []EventType {
+	var types []EventType
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestDetectTransitions(t *testing.T) {
+	yes, no := true, false
+	now := time.Now()
+	earlier := now.Add(-time.Hour)
+	farExpiry := now.Add(365 * 24 * time.Hour)
+	nearExpiry := now.Add(5 * 24 * time.Hour)
+	warning := 14 * 24 * time.Hour
+
+	tests := []struct {
+		name     string
+		previous *store.ServerStatus
+		current  *store.ServerStatus
+		want     []EventType
+	}{
+		{
+			name:     "first check unhealthy",
+			previous: &store.ServerStatus{},
+			current:  status(&no, "", now, nil),
+			want:     nil,
+		},
+		{
+			name:     "healthy to unhealthy",
+			previous: status(&yes, "a", earlier, &farExpiry),
+			current:  status(&no, "", now, nil),
+			want:     []EventType{EventUnhealthy},
+		},
+		{
+			name:     "recovery",
+			previous: status(&no, "a", earlier, &farExpiry),
+			current:  status(&yes, "a", now, &farExpiry),
+			want:     []EventType{EventRecovered},
+		},
+		{
+			name:     "certificate changed",
+			previous: status(&yes, "a", earlier, &farExpiry),
+			current:  status(&yes, "b", now, &farExpiry),
+			want:     []EventType{EventCertChanged},
+		},
+		{
+			name:     "certificate enters warning window",
+			previous: status(&yes, "a", earlier.Add(-30*24*time.Hour), &nearExpiry),
+			current:  status(&yes, "a", now, &nearExpiry),
+			want:     []EventType{EventCertExpiring},
+		},
+		{
+			name:     "already warned about expiry",
+			previous: status(&yes, "a", earlier, &nearExpiry),
+			current:  status(&yes, "a", now, &nearExpiry),
+			want:     nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := eventTypes(DetectTransitions(tt.previous, tt.current, warning))
+			if len(got) != len(tt.want) {
+				t.Fatalf("DetectTransitions() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("DetectTransitions() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookDelivery(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = io.ReadAll(r.Body)
+		received <- r
+	}))
+	defer server.Close()
+
+	dataStore, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("store.New() error = %v", err)
+	}
+	defer dataStore.Close()
+
+	webhook := NewWebhookChannel("test", server.URL, "s3cret", nil, 3, 5*time.Second, dataStore)
+	if err := webhook.Send(Event{Type: EventUnhealthy, EntityID: "https://entity.com"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	worker := NewOutboxWorker(dataStore, time.Hour, webhook)
+	worker.deliverDue()
+
+	var req *http.Request
+	select {
+	case req = <-received:
+	default:
+		t.Fatal("webhook was not called")
+	}
+
+	if got, want := req.Header.Get(SignatureHeader), Sign("s3cret", body); got != want {
+		t.Errorf("signature = %v, want %v", got, want)
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		t.Fatalf("invalid payload: %v", err)
+	}
+	if event.Type != EventUnhealthy {
+		t.Errorf("Type = %v, want %v", event.Type, EventUnhealthy)
+	}
+
+	entries, _ := dataStore.GetDueOutbox(time.Now().Add(time.Hour), 10)
+	if len(entries) != 0 {
+		t.Errorf("outbox has %d entries after delivery, want 0", len(entries))
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dataStore, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("store.New() error = %v", err)
+	}
+	defer dataStore.Close()
+
+	webhook := NewWebhookChannel("test", server.URL, "", nil, 3, 5*time.Second, dataStore)
+	webhook.Send(Event{Type: EventRecovered})
+
+	worker := NewOutboxWorker(dataStore, time.Hour, webhook)
+	worker.deliverDue()
+
+	entries, _ := dataStore.GetDueOutbox(time.Now().Add(time.Hour), 10)
+	if len(entries) != 1 {
+		t.Fatalf("outbox has %d entries after failed delivery, want 1", len(entries))
+	}
+	if entries[0].Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", entries[0].Attempts)
+	}
+}
@@ -0,0 +1,242 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of the request body,
+// keyed with the webhook's secret
+const SignatureHeader = "X-Matfmonitor-Signature"
+
+// WebhookChannel delivers events as signed JSON POST requests.
+// Send only writes the event to the store's outbox, delivery is done by an
+// OutboxWorker so that pending notifications survive a restart.
+type WebhookChannel struct {
+	name        string
+	url         string
+	secret      string
+	events      map[EventType]bool
+	maxAttempts int
+	store       *store.Store
+	client      *http.Client
+}
+
+// NewWebhookChannel creates a new WebhookChannel. If events is empty all
+// event types are accepted.
+func NewWebhookChannel(name, url, secret string, events []string, maxAttempts int, timeout time.Duration, dataStore *store.Store) *WebhookChannel {
+	accepted := make(map[EventType]bool, len(events))
+	for _, e := range events {
+		accepted[EventType(e)] = true
+	}
+	return &WebhookChannel{
+		name:        name,
+		url:         url,
+		secret:      secret,
+		events:      accepted,
+		maxAttempts: maxAttempts,
+		store:       dataStore,
+		client:      &http.Client{Timeout: timeout},
+	}
+}
+
+// Name returns the configured name of the webhook
+func (w *WebhookChannel) Name() string {
+	return w.name
+}
+
+// Accepts tells whether the webhook is subscribed to the event type
+func (w *WebhookChannel) Accepts(eventType EventType) bool {
+	return len(w.events) == 0 || w.events[eventType]
+}
+
+// Send queues the event for delivery
+func (w *WebhookChannel) Send(event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return w.store.EnqueueOutbox(w.name, payload)
+}
+
+// reportPayload is the JSON body of a report delivered to a webhook
+type reportPayload struct {
+	Type        string    `json:"type"`
+	Subject     string    `json:"subject"`
+	Markdown    string    `json:"markdown"`
+	HTML        string    `json:"html"`
+	GeneratedAt time.Time `json:"generated_at"`
+}
+
+// SendReport queues a report for delivery
+func (w *WebhookChannel) SendReport(subject, markdown, html string) error {
+	payload, err := json.Marshal(reportPayload{
+		Type:        "report.digest",
+		Subject:     subject,
+		Markdown:    markdown,
+		HTML:        html,
+		GeneratedAt: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return w.store.EnqueueOutbox(w.name, payload)
+}
+
+// Sign returns the signature for a payload, as sent in SignatureHeader
+func Sign(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// deliver performs a single delivery attempt
+func (w *WebhookChannel) deliver(ctx context.Context, entry *store.OutboxEntry) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(entry.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "matfmonitor")
+	req.Header.Set("X-Matfmonitor-Delivery", strconv.FormatInt(entry.ID, 10))
+	if w.secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.secret, entry.Payload))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// OutboxWorker delivers queued webhook notifications, retrying failed
+// deliveries with exponential backoff
+type OutboxWorker struct {
+	store        *store.Store
+	webhooks     map[string]*WebhookChannel
+	pollInterval time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewOutboxWorker creates a new OutboxWorker for the given webhooks
+func NewOutboxWorker(dataStore *store.Store, pollInterval time.Duration, webhooks ...*WebhookChannel) *OutboxWorker {
+	ctx, cancel := context.WithCancel(context.Background())
+	byName := make(map[string]*WebhookChannel, len(webhooks))
+	for _, w := range webhooks {
+		byName[w.name] = w
+	}
+	return &OutboxWorker{
+		store:        dataStore,
+		webhooks:     byName,
+		pollInterval: pollInterval,
+		ctx:          ctx,
+		cancel:       cancel,
+	}
+}
+
+// Start begins delivering queued notifications
+func (o *OutboxWorker) Start() {
+	o.wg.Add(1)
+	go o.run()
+}
+
+// Stop stops delivery and waits for an ongoing attempt to finish
+func (o *OutboxWorker) Stop() {
+	o.cancel()
+	o.wg.Wait()
+}
+
+func (o *OutboxWorker) run() {
+	defer o.wg.Done()
+
+	ticker := time.NewTicker(o.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		o.deliverDue()
+
+		select {
+		case <-o.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (o *OutboxWorker) deliverDue() {
+	entries, err := o.store.GetDueOutbox(time.Now(), 20)
+	if err != nil {
+		log.Printf("Error reading notification outbox: %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+		if o.ctx.Err() != nil {
+			return
+		}
+
+		webhook, ok := o.webhooks[entry.Channel]
+		if !ok {
+			// The webhook has been removed from the configuration
+			log.Printf("Dropping notification for unknown webhook %s", entry.Channel)
+			o.store.DeleteOutbox(entry.ID)
+			continue
+		}
+
+		err := webhook.deliver(o.ctx, entry)
+		if err == nil {
+			if err := o.store.DeleteOutbox(entry.ID); err != nil {
+				log.Printf("Error removing delivered notification: %v", err)
+			}
+			continue
+		}
+
+		attempts := entry.Attempts + 1
+		if attempts >= webhook.maxAttempts {
+			log.Printf("Giving up on notification to %s after %d attempts: %v", webhook.name, attempts, err)
+			o.store.DeleteOutbox(entry.ID)
+			continue
+		}
+
+		log.Printf("Notification to %s failed (attempt %d): %v", webhook.name, attempts, err)
+		if err := o.store.RescheduleOutbox(entry.ID, time.Now().Add(retryBackoff(attempts)), err.Error()); err != nil {
+			log.Printf("Error rescheduling notification: %v", err)
+		}
+	}
+}
+
+// retryBackoff returns the delay before the next attempt, doubling from
+// 30 seconds up to a maximum of 6 hours
+func retryBackoff(attempts int) time.Duration {
+	backoff := 30 * time.Second
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+		if backoff >= 6*time.Hour {
+			return 6 * time.Hour
+		}
+	}
+	return backoff
+}
@@ -0,0 +1,198 @@
+// Package onboard lets prospective members test a server before it is
+// published in metadata.
+//
+// Since anyone able to reach the web interface can make the monitor
+// connect to a host of their choosing, tests are rate limited, restricted
+// to a set of ports and never connect to private or block-listed
+// addresses.
+package onboard
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+)
+
+// ErrRateLimited is returned when too many tests have been made
+var ErrRateLimited = errors.New("too many tests, try again later")
+
+// ErrBlocked is returned when the base URI isn't allowed to be tested
+var ErrBlocked = errors.New("not allowed")
+
+// rateWindow is the period rate limits apply to
+const rateWindow = time.Hour
+
+// alwaysBlocked are networks never connected to: loopback, private,
+// link-local, shared and unspecified addresses
+var alwaysBlocked = mustParseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// Tester runs rate limited server tests
+type Tester struct {
+	checker         *checker.RealChecker
+	ports           map[string]bool
+	blockedNetworks []*net.IPNet
+	blockedHosts    []string
+	perClient       int
+	total           int
+
+	mu       sync.Mutex
+	requests map[string][]time.Time
+	all      []time.Time
+}
+
+// NewTester creates a new Tester. Only the given ports may be tested, and
+// the block-lists apply in addition to private addresses. Hosts are
+// blocked including their subdomains. perClient and total limit the
+// number of tests per hour from one client address and in all.
+func NewTester(c *checker.RealChecker, ports []int, blockedNetworks []*net.IPNet, blockedHosts []string, perClient, total int) *Tester {
+	allowed := make(map[string]bool)
+	for _, port := range ports {
+		allowed[fmt.Sprint(port)] = true
+	}
+	var hosts []string
+	for _, host := range blockedHosts {
+		hosts = append(hosts, strings.TrimSuffix(strings.ToLower(host), "."))
+	}
+	return &Tester{
+		checker:         c,
+		ports:           allowed,
+		blockedNetworks: append(append([]*net.IPNet{}, alwaysBlocked...), blockedNetworks...),
+		blockedHosts:    hosts,
+		perClient:       perClient,
+		total:           total,
+		requests:        make(map[string][]time.Time),
+	}
+}
+
+// Test checks the server at baseURI on behalf of client (the address of
+// the requester). Returns ErrRateLimited if the client or everyone has
+// made too many tests, or an error wrapping ErrBlocked if the base URI
+// isn't allowed.
+func (t *Tester) Test(baseURI, client string) (*Report, error) {
+	if err := t.allowURI(baseURI); err != nil {
+		return nil, err
+	}
+	if !t.take(client, time.Now()) {
+		return nil, ErrRateLimited
+	}
+
+	handshake, err := t.checker.Probe(baseURI, t.control)
+	if errors.Is(err, ErrBlocked) {
+		return nil, fmt.Errorf("%w: address is block-listed", ErrBlocked)
+	}
+	return Assess(baseURI, handshake, err, time.Now()), nil
+}
+
+// allowURI checks the base URI before anything is resolved or connected to
+func (t *Tester) allowURI(baseURI string) error {
+	u, err := url.Parse(baseURI)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid base URI")
+	}
+	if u.Scheme != "https" {
+		return fmt.Errorf("base URI must use https")
+	}
+	if u.User != nil {
+		return fmt.Errorf("base URI must not contain user information")
+	}
+	_, port, err := checker.ParseBaseURI(baseURI)
+	if err != nil {
+		return fmt.Errorf("invalid base URI: %v", err)
+	}
+	if !t.ports[port] {
+		return fmt.Errorf("%w: port %s", ErrBlocked, port)
+	}
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for _, blocked := range t.blockedHosts {
+		if host == blocked || strings.HasSuffix(host, "."+blocked) {
+			return fmt.Errorf("%w: host is block-listed", ErrBlocked)
+		}
+	}
+	return nil
+}
+
+// control rejects connections to blocked addresses. It's called with the
+// resolved address, so host names resolving to blocked addresses are
+// caught as well.
+func (t *Tester) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return ErrBlocked
+	}
+	for _, network := range t.blockedNetworks {
+		if network.Contains(ip) {
+			return ErrBlocked
+		}
+	}
+	return nil
+}
+
+// take records a test by client if it is within the rate limits
+func (t *Tester) take(client string, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	cutoff := now.Add(-rateWindow)
+	t.all = recent(t.all, cutoff)
+	for c, times := range t.requests {
+		if times = recent(times, cutoff); len(times) == 0 {
+			delete(t.requests, c)
+		} else {
+			t.requests[c] = times
+		}
+	}
+
+	if len(t.all) >= t.total || len(t.requests[client]) >= t.perClient {
+		return false
+	}
+	t.all = append(t.all, now)
+	t.requests[client] = append(t.requests[client], now)
+	return true
+}
+
+// recent returns the times after cutoff, times is sorted
+func recent(times []time.Time, cutoff time.Time) []time.Time {
+	i := 0
+	for i < len(times) && !times[i].After(cutoff) {
+		i++
+	}
+	return times[i:]
+}
@@ -0,0 +1,100 @@
+package onboard
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/util"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+)
+
+func TestAllowURI(t *testing.T) {
+	tester := NewTester(checker.NewRealChecker(time.Second), []int{443}, nil, []string{"blocked.example"}, 10, 10)
+
+	for _, uri := range []string{
+		"http://api.example.com/",
+		"https://api.example.com:22/",
+		"https://user@api.example.com/",
+		"https://api.blocked.example/",
+		"https://BLOCKED.example./",
+		"not a uri",
+	} {
+		if err := tester.allowURI(uri); err == nil {
+			t.Errorf("allowURI(%q) succeeded", uri)
+		}
+	}
+	if err := tester.allowURI("https://api.example.com/"); err != nil {
+		t.Errorf("allowURI() = %v", err)
+	}
+}
+
+func TestPrivateAddressesBlocked(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	_, port, _ := net.SplitHostPort(u.Host)
+	p, _ := strconv.Atoi(port)
+	tester := NewTester(checker.NewRealChecker(time.Second), []int{p}, nil, nil, 10, 10)
+
+	_, err := tester.Test(server.URL+"/", "client")
+	if !errors.Is(err, ErrBlocked) {
+		t.Errorf("Test() of loopback server = %v, want ErrBlocked", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	tester := NewTester(checker.NewRealChecker(time.Second), []int{443}, nil, nil, 2, 3)
+	now := time.Now()
+
+	if !tester.take("a", now) || !tester.take("a", now) {
+		t.Fatal("first tests were rate limited")
+	}
+	if tester.take("a", now) {
+		t.Error("third test from the same client was allowed")
+	}
+	if !tester.take("b", now) {
+		t.Error("test from another client was rate limited")
+	}
+	if tester.take("c", now) {
+		t.Error("total limit not applied")
+	}
+	if !tester.take("a", now.Add(rateWindow+time.Second)) {
+		t.Error("rate limit not reset after the window")
+	}
+}
+
+func TestAssess(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.NotFoundHandler())
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	handshake, err := checker.NewRealChecker(5*time.Second).Probe(server.URL+"/", nil)
+	report := Assess(server.URL+"/", handshake, err, time.Now())
+
+	if !report.Ready {
+		t.Errorf("report not ready: %+v", report.Findings)
+	}
+	if report.Pin == nil || report.Pin.Digest != util.Fingerprint(server.Certificate()) {
+		t.Errorf("pin = %+v", report.Pin)
+	}
+	if !strings.Contains(report.ServerEntry, report.Pin.Digest) {
+		t.Errorf("server entry doesn't contain pin: %s", report.ServerEntry)
+	}
+	if !report.ClientCertRequested || report.TLSVersion == "" || len(report.Chain) == 0 {
+		t.Errorf("report = %+v", report)
+	}
+
+	report = Assess("https://unreachable.example/", nil, errors.New("connection refused"), time.Now())
+	if report.Ready || report.Pin != nil {
+		t.Errorf("failed probe report = %+v", report)
+	}
+}
@@ -0,0 +1,195 @@
+package onboard
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/bowness/util"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/issuer"
+)
+
+// ExpiryWarning is how long before expiry a certificate is reported as
+// expiring soon
+const ExpiryWarning = 30 * 24 * time.Hour
+
+// Severity of a finding
+type Severity string
+
+const (
+	// SeverityError means the monitor's check would fail
+	SeverityError Severity = "error"
+	// SeverityWarning is something that should be fixed
+	SeverityWarning Severity = "warning"
+	// SeverityInfo is for information only
+	SeverityInfo Severity = "info"
+)
+
+// Finding is something noteworthy about the server
+type Finding struct {
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+// ChainCertificate is a certificate sent by the server
+type ChainCertificate struct {
+	Subject  string    `json:"subject"`
+	Issuer   string    `json:"issuer"`
+	NotAfter time.Time `json:"not_after"`
+	KeyType  string    `json:"key_type"`
+	KeyBits  int       `json:"key_bits"`
+	SigAlg   string    `json:"signature_algorithm"`
+}
+
+// Report is the outcome of testing a server before it is in metadata
+type Report struct {
+	BaseURI    string    `json:"base_uri"`
+	CheckedAt  time.Time `json:"checked_at"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+
+	// Whether the server would pass the monitor's check once the pin is
+	// published
+	Ready bool `json:"ready"`
+
+	// The pin to publish, and the server entry containing it
+	Pin         *fedtls.Pin `json:"pin,omitempty"`
+	ServerEntry string      `json:"server_entry,omitempty"`
+
+	Chain               []ChainCertificate `json:"chain,omitempty"`
+	DNSNames            []string           `json:"dns_names,omitempty"`
+	TLSVersion          string             `json:"tls_version,omitempty"`
+	CipherSuite         string             `json:"cipher_suite,omitempty"`
+	ClientCertRequested bool               `json:"client_cert_requested"`
+	HandshakeMillis     int64              `json:"handshake_ms"`
+
+	Findings []Finding `json:"findings"`
+}
+
+func (r *Report) add(severity Severity, format string, args ...interface{}) {
+	r.Findings = append(r.Findings, Finding{Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// Assess creates a report from the outcome of checker.RealChecker.Probe
+func Assess(baseURI string, handshake *checker.Handshake, probeErr error, now time.Time) *Report {
+	report := &Report{BaseURI: baseURI, CheckedAt: now, Findings: []Finding{}}
+	defer func() {
+		report.Ready = true
+		for _, f := range report.Findings {
+			if f.Severity == SeverityError {
+				report.Ready = false
+			}
+		}
+	}()
+
+	if u, err := url.Parse(baseURI); err == nil && u.Scheme != "https" {
+		report.add(SeverityError, "base URI must use https")
+	}
+
+	if probeErr != nil {
+		report.add(SeverityError, "TLS connection failed: %v", probeErr)
+		return report
+	}
+	report.RemoteAddr = handshake.RemoteAddr
+	report.HandshakeMillis = handshake.Duration.Milliseconds()
+
+	if handshake.Version != 0 {
+		report.TLSVersion = tls.VersionName(handshake.Version)
+		switch {
+		case handshake.Version < tls.VersionTLS12:
+			report.add(SeverityError, "server negotiated %s, TLS 1.2 or later is required", report.TLSVersion)
+		case handshake.Version == tls.VersionTLS12:
+			report.add(SeverityInfo, "server negotiated TLS 1.2, TLS 1.3 is recommended")
+		}
+	}
+	if handshake.CipherSuite != 0 {
+		report.CipherSuite = tls.CipherSuiteName(handshake.CipherSuite)
+		for _, suite := range tls.InsecureCipherSuites() {
+			if suite.ID == handshake.CipherSuite {
+				report.add(SeverityWarning, "insecure cipher suite %s", report.CipherSuite)
+			}
+		}
+	}
+
+	report.ClientCertRequested = handshake.ClientCertRequested
+	if !handshake.ClientCertRequested {
+		report.add(SeverityWarning, "server didn't ask for a client certificate, federation clients are normally authenticated with one")
+	}
+	if handshake.Error != nil && !handshake.ClientCertRequested {
+		report.add(SeverityWarning, "TLS handshake failed: %v", handshake.Error)
+	}
+
+	if len(handshake.Chain) == 0 {
+		report.add(SeverityError, "no certificate received from server")
+		return report
+	}
+
+	for _, cert := range handshake.Chain {
+		keyType, keyBits := issuer.KeyInfo(cert)
+		report.Chain = append(report.Chain, ChainCertificate{
+			Subject:  cert.Subject.String(),
+			Issuer:   cert.Issuer.String(),
+			NotAfter: cert.NotAfter,
+			KeyType:  keyType,
+			KeyBits:  keyBits,
+			SigAlg:   cert.SignatureAlgorithm.String(),
+		})
+	}
+
+	leaf := handshake.Chain[0]
+	report.DNSNames = leaf.DNSNames
+	report.Pin = &fedtls.Pin{Alg: checker.PinAlg, Digest: util.Fingerprint(leaf)}
+	entry, _ := json.MarshalIndent(fedtls.Server{BaseURI: baseURI, Pins: []fedtls.Pin{*report.Pin}}, "", "  ")
+	report.ServerEntry = string(entry)
+
+	switch {
+	case now.After(leaf.NotAfter):
+		report.add(SeverityError, "certificate expired on %s", leaf.NotAfter.Format(time.RFC3339))
+	case now.Before(leaf.NotBefore):
+		report.add(SeverityError, "certificate is not valid until %s", leaf.NotBefore.Format(time.RFC3339))
+	case leaf.NotAfter.Sub(now) < ExpiryWarning:
+		report.add(SeverityWarning, "certificate expires on %s, publish the pin of its replacement as well", leaf.NotAfter.Format("2006-01-02"))
+	}
+
+	if !checker.MatchesHostname(leaf, handshake.Host) {
+		report.add(SeverityError, "certificate CN (%s) and SANs do not match hostname (%s)", leaf.Subject.CommonName, handshake.Host)
+	}
+
+	for _, weakness := range issuer.Weaknesses(leaf) {
+		report.add(SeverityWarning, "weak certificate: %s", weakness)
+	}
+
+	assessChain(report, handshake.Chain, now)
+	return report
+}
+
+// assessChain adds findings about the certificate chain. Since servers are
+// verified with pins the chain doesn't have to be complete or trusted, so
+// these are informational.
+func assessChain(report *Report, chain []*x509.Certificate, now time.Time) {
+	leaf := chain[0]
+	if bytes.Equal(leaf.RawSubject, leaf.RawIssuer) && leaf.CheckSignatureFrom(leaf) == nil {
+		report.add(SeverityInfo, "certificate is self-signed, which is fine since it is verified by its pin")
+		return
+	}
+
+	for i := 1; i < len(chain); i++ {
+		if chain[i-1].CheckSignatureFrom(chain[i]) != nil {
+			report.add(SeverityWarning, "certificate %d in the chain (%s) isn't signed by the next one, the chain is out of order or contains unrelated certificates", i, chain[i-1].Subject)
+			break
+		}
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range chain[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := leaf.Verify(x509.VerifyOptions{Intermediates: intermediates, CurrentTime: now}); err != nil {
+		report.add(SeverityInfo, "chain doesn't verify against public roots: %v", err)
+	}
+}
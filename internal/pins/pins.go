@@ -0,0 +1,89 @@
+// Package pins indexes where pins are published in metadata.
+package pins
+
+import (
+	"github.com/joesiltberg/bowness/fedtls"
+)
+
+// Use is a place in metadata where a pin is published
+type Use struct {
+	EntityID       string `json:"entity_id"`
+	Organization   string `json:"organization,omitempty"`
+	OrganizationID string `json:"organization_id,omitempty"`
+
+	// Set for server pins, empty for client pins
+	BaseURI string `json:"base_uri,omitempty"`
+}
+
+// IsClient tells if the use is a client pin
+func (u Use) IsClient() bool {
+	return u.BaseURI == ""
+}
+
+// String describes the use for messages
+func (u Use) String() string {
+	if u.IsClient() {
+		return "client of " + u.EntityID
+	}
+	return "server " + u.BaseURI + " of " + u.EntityID
+}
+
+// Index maps pin digests to where they are published
+type Index struct {
+	uses    map[string][]Use
+	digests []string
+}
+
+// NewIndex indexes all server and client pins in metadata. A client pin
+// listed several times for the same entity is only indexed once.
+func NewIndex(metadata *fedtls.Metadata) *Index {
+	index := &Index{uses: make(map[string][]Use)}
+	if metadata == nil {
+		return index
+	}
+	for _, entity := range metadata.Entities {
+		use := Use{EntityID: entity.EntityID}
+		if entity.Organization != nil {
+			use.Organization = *entity.Organization
+		}
+		if entity.OrganizationID != nil {
+			use.OrganizationID = *entity.OrganizationID
+		}
+
+		for _, server := range entity.Servers {
+			serverUse := use
+			serverUse.BaseURI = server.BaseURI
+			for _, pin := range server.Pins {
+				index.add(pin.Digest, serverUse)
+			}
+		}
+
+		seen := make(map[string]bool)
+		for _, client := range entity.Clients {
+			for _, pin := range client.Pins {
+				if !seen[pin.Digest] {
+					seen[pin.Digest] = true
+					index.add(pin.Digest, use)
+				}
+			}
+		}
+	}
+	return index
+}
+
+func (index *Index) add(digest string, use Use) {
+	if _, ok := index.uses[digest]; !ok {
+		index.digests = append(index.digests, digest)
+	}
+	index.uses[digest] = append(index.uses[digest], use)
+}
+
+// Lookup returns where a digest is published, in metadata order
+func (index *Index) Lookup(digest string) []Use {
+	return index.uses[digest]
+}
+
+// Digests returns all indexed digests, in metadata order
+func (index *Index) Digests() []string {
+	return index.digests
+}
@@ -0,0 +1,40 @@
+package pins
+
+import (
+	"testing"
+
+	"github.com/joesiltberg/bowness/fedtls"
+)
+
+func TestIndex(t *testing.T) {
+	org := "Org A"
+	pin := func(digest string) fedtls.Pin { return fedtls.Pin{Alg: "sha256", Digest: digest} }
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{
+		{
+			EntityID:     "https://a.com",
+			Organization: &org,
+			Servers:      []fedtls.Server{{BaseURI: "https://api.a.com/", Pins: []fedtls.Pin{pin("shared"), pin("a")}}},
+			Clients:      []fedtls.Client{{Pins: []fedtls.Pin{pin("client")}}, {Pins: []fedtls.Pin{pin("client")}}},
+		},
+		{
+			EntityID: "https://b.com",
+			Servers:  []fedtls.Server{{BaseURI: "https://api.b.com/", Pins: []fedtls.Pin{pin("shared")}}},
+		},
+	}}
+
+	index := NewIndex(metadata)
+
+	uses := index.Lookup("shared")
+	if len(uses) != 2 || uses[0].Organization != "Org A" || uses[1].BaseURI != "https://api.b.com/" {
+		t.Errorf("Lookup(shared) = %+v", uses)
+	}
+	if uses := index.Lookup("client"); len(uses) != 1 || !uses[0].IsClient() {
+		t.Errorf("Lookup(client) = %+v", uses)
+	}
+	if uses := index.Lookup("unknown"); len(uses) != 0 {
+		t.Errorf("Lookup(unknown) = %+v", uses)
+	}
+	if digests := index.Digests(); len(digests) != 3 || digests[0] != "shared" {
+		t.Errorf("Digests() = %v", digests)
+	}
+}
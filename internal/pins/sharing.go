@@ -0,0 +1,164 @@
+package pins
+
+import (
+	"sort"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// SharedCertificate is a certificate deployed on more than one server
+type SharedCertificate struct {
+	Fingerprint   string `json:"fingerprint"`
+	CertCN        string `json:"cert_cn,omitempty"`
+	Servers       []Use  `json:"servers"`
+	Organizations int    `json:"organizations"`
+}
+
+// ForeignCertificate is a server presenting a certificate that isn't
+// pinned for its own entity, but is pinned for another
+type ForeignCertificate struct {
+	Server      Use    `json:"server"`
+	Fingerprint string `json:"fingerprint"`
+	CertCN      string `json:"cert_cn,omitempty"`
+	PinnedFor   []Use  `json:"pinned_for"`
+}
+
+// MultiOrganizationPin is a pin published by entities of more than one
+// organization
+type MultiOrganizationPin struct {
+	Digest        string   `json:"digest"`
+	Uses          []Use    `json:"uses"`
+	Organizations []string `json:"organizations"`
+}
+
+// Sharing is the result of analyzing how certificates and pins are shared
+// across the federation
+type Sharing struct {
+	SharedCertificates    []SharedCertificate    `json:"shared_certificates"`
+	ForeignCertificates   []ForeignCertificate   `json:"foreign_certificates"`
+	MultiOrganizationPins []MultiOrganizationPin `json:"multi_organization_pins"`
+}
+
+// organizationKey identifies the organization of a use, falling back to
+// the organization name and the entity
+func organizationKey(u Use) string {
+	switch {
+	case u.OrganizationID != "":
+		return u.OrganizationID
+	case u.Organization != "":
+		return u.Organization
+	}
+	return u.EntityID
+}
+
+// organizationName is the display name of the organization of a use
+func organizationName(u Use) string {
+	if u.Organization != "" {
+		return u.Organization
+	}
+	return organizationKey(u)
+}
+
+// AnalyzeSharing finds certificates deployed on several servers, servers
+// presenting certificates pinned for other entities and pins published by
+// several organizations. statuses are the latest check results.
+func AnalyzeSharing(metadata *fedtls.Metadata, statuses []*store.ServerStatus) *Sharing {
+	sharing := &Sharing{
+		SharedCertificates:    []SharedCertificate{},
+		ForeignCertificates:   []ForeignCertificate{},
+		MultiOrganizationPins: []MultiOrganizationPin{},
+	}
+	if metadata == nil {
+		return sharing
+	}
+	index := NewIndex(metadata)
+
+	// Servers in metadata, to skip statuses of removed servers
+	servers := make(map[store.ServerKey]Use)
+	for _, entity := range metadata.Entities {
+		for _, server := range entity.Servers {
+			use := Use{EntityID: entity.EntityID, BaseURI: server.BaseURI}
+			if entity.Organization != nil {
+				use.Organization = *entity.Organization
+			}
+			if entity.OrganizationID != nil {
+				use.OrganizationID = *entity.OrganizationID
+			}
+			servers[store.ServerKey{EntityID: entity.EntityID, BaseURI: server.BaseURI}] = use
+		}
+	}
+
+	deployed := make(map[string]*SharedCertificate)
+	var fingerprints []string
+	for _, status := range statuses {
+		server, ok := servers[status.ServerKey]
+		if !ok || status.CertFingerprint == "" {
+			continue
+		}
+
+		shared := deployed[status.CertFingerprint]
+		if shared == nil {
+			shared = &SharedCertificate{Fingerprint: status.CertFingerprint, CertCN: status.CertCN}
+			deployed[status.CertFingerprint] = shared
+			fingerprints = append(fingerprints, status.CertFingerprint)
+		}
+		shared.Servers = append(shared.Servers, server)
+
+		var own, other []Use
+		for _, use := range index.Lookup(status.CertFingerprint) {
+			if use.IsClient() {
+				continue
+			}
+			if use.EntityID == server.EntityID {
+				own = append(own, use)
+			} else {
+				other = append(other, use)
+			}
+		}
+		if len(own) == 0 && len(other) > 0 {
+			sharing.ForeignCertificates = append(sharing.ForeignCertificates, ForeignCertificate{
+				Server:      server,
+				Fingerprint: status.CertFingerprint,
+				CertCN:      status.CertCN,
+				PinnedFor:   other,
+			})
+		}
+	}
+
+	for _, fingerprint := range fingerprints {
+		shared := deployed[fingerprint]
+		if len(shared.Servers) < 2 {
+			continue
+		}
+		organizations := make(map[string]bool)
+		for _, server := range shared.Servers {
+			organizations[organizationKey(server)] = true
+		}
+		shared.Organizations = len(organizations)
+		sharing.SharedCertificates = append(sharing.SharedCertificates, *shared)
+	}
+	sort.SliceStable(sharing.SharedCertificates, func(i, j int) bool {
+		return len(sharing.SharedCertificates[i].Servers) > len(sharing.SharedCertificates[j].Servers)
+	})
+
+	for _, digest := range index.Digests() {
+		uses := index.Lookup(digest)
+		seen := make(map[string]bool)
+		var names []string
+		for _, use := range uses {
+			if key := organizationKey(use); !seen[key] {
+				seen[key] = true
+				names = append(names, organizationName(use))
+			}
+		}
+		if len(names) > 1 {
+			sharing.MultiOrganizationPins = append(sharing.MultiOrganizationPins, MultiOrganizationPin{
+				Digest:        digest,
+				Uses:          uses,
+				Organizations: names,
+			})
+		}
+	}
+	return sharing
+}
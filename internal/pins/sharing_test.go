@@ -0,0 +1,73 @@
+package pins
+
+import (
+	"testing"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func TestAnalyzeSharing(t *testing.T) {
+	orgA, orgB := "SE1", "SE2"
+	pin := func(digest string) []fedtls.Pin { return []fedtls.Pin{{Alg: "sha256", Digest: digest}} }
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{
+		{
+			// A provider hosting two servers with the same certificate
+			EntityID:       "https://a.com",
+			OrganizationID: &orgA,
+			Servers: []fedtls.Server{
+				{BaseURI: "https://one.provider.com/", Pins: pin("provider")},
+				{BaseURI: "https://two.provider.com/", Pins: pin("provider")},
+			},
+		},
+		{
+			EntityID:       "https://b.com",
+			OrganizationID: &orgB,
+			Servers: []fedtls.Server{
+				{BaseURI: "https://three.provider.com/", Pins: pin("provider")},
+				{BaseURI: "https://wrong.b.com/", Pins: pin("b")},
+			},
+		},
+	}}
+	status := func(entityID, baseURI, fingerprint string) *store.ServerStatus {
+		return &store.ServerStatus{ServerKey: store.ServerKey{EntityID: entityID, BaseURI: baseURI}, CertFingerprint: fingerprint}
+	}
+	statuses := []*store.ServerStatus{
+		status("https://a.com", "https://one.provider.com/", "provider"),
+		status("https://a.com", "https://two.provider.com/", "provider"),
+		status("https://b.com", "https://three.provider.com/", "provider"),
+		status("https://b.com", "https://wrong.b.com/", "provider"),
+		status("https://removed.com", "https://removed.com/", "provider"),
+	}
+
+	sharing := AnalyzeSharing(metadata, statuses)
+
+	if len(sharing.SharedCertificates) != 1 {
+		t.Fatalf("got %d shared certificates, want 1", len(sharing.SharedCertificates))
+	}
+	if shared := sharing.SharedCertificates[0]; len(shared.Servers) != 4 || shared.Organizations != 2 {
+		t.Errorf("shared certificate = %+v", shared)
+	}
+
+	// wrong.b.com presents a certificate pinned for b.com's other server,
+	// which isn't foreign; nothing is pinned only for another entity
+	if len(sharing.ForeignCertificates) != 0 {
+		t.Errorf("foreign certificates = %+v", sharing.ForeignCertificates)
+	}
+
+	if len(sharing.MultiOrganizationPins) != 1 || sharing.MultiOrganizationPins[0].Digest != "provider" ||
+		len(sharing.MultiOrganizationPins[0].Organizations) != 2 {
+		t.Errorf("multi-organization pins = %+v", sharing.MultiOrganizationPins)
+	}
+
+	// Once b.com no longer pins the provider certificate, both its servers
+	// present a certificate pinned only for another entity
+	metadata.Entities[1].Servers[0].Pins = pin("b3")
+	sharing = AnalyzeSharing(metadata, statuses)
+	if len(sharing.ForeignCertificates) != 2 {
+		t.Fatalf("got %d foreign certificates, want 2", len(sharing.ForeignCertificates))
+	}
+	if f := sharing.ForeignCertificates[0]; f.Server.BaseURI != "https://three.provider.com/" || len(f.PinnedFor) != 2 || f.PinnedFor[0].EntityID != "https://a.com" {
+		t.Errorf("foreign certificate = %+v", f)
+	}
+}
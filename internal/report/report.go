@@ -0,0 +1,311 @@
+// Package report generates periodic federation digest reports.
+package report
+
+import (
+	"bytes"
+	htmltemplate "html/template"
+	"sort"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// Periods a report can cover
+const (
+	Daily  = "daily"
+	Weekly = "weekly"
+)
+
+// ExpiryHorizon is how far ahead expiring certificates are listed
+const ExpiryHorizon = 30 * 24 * time.Hour
+
+// Item is a server listed in a report section
+type Item struct {
+	EntityID     string
+	BaseURI      string
+	Organization string
+	Time         time.Time
+	Detail       string
+}
+
+// Report summarizes what happened in the federation during a period
+type Report struct {
+	Period      string
+	From        time.Time
+	To          time.Time
+	GeneratedAt time.Time
+
+	HealthyCount   int
+	UnhealthyCount int
+	UncheckedCount int
+
+	NewFailures    []Item
+	Recoveries     []Item
+	ExpiringCerts  []Item
+	AddedServers   []Item
+	RemovedServers []Item
+}
+
+// PeriodDuration returns the length of a report period
+func PeriodDuration(period string) time.Duration {
+	if period == Weekly {
+		return 7 * 24 * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// Generate builds a report for the period ending at to
+func Generate(dataStore *store.Store, metadata *fedtls.Metadata, period string, to time.Time) (*Report, error) {
+	from := to.Add(-PeriodDuration(period))
+	r := &Report{
+		Period:      period,
+		From:        from,
+		To:          to,
+		GeneratedAt: time.Now(),
+	}
+
+	organizations := make(map[string]string)
+	if metadata != nil {
+		for _, entity := range metadata.Entities {
+			if entity.Organization != nil {
+				organizations[entity.EntityID] = *entity.Organization
+			}
+		}
+	}
+	item := func(key store.ServerKey, t time.Time, detail string) Item {
+		return Item{
+			EntityID:     key.EntityID,
+			BaseURI:      key.BaseURI,
+			Organization: organizations[key.EntityID],
+			Time:         t,
+			Detail:       detail,
+		}
+	}
+
+	statuses, err := dataStore.GetAllStatuses()
+	if err != nil {
+		return nil, err
+	}
+	statusMap := make(map[store.ServerKey]*store.ServerStatus, len(statuses))
+	for _, s := range statuses {
+		statusMap[s.ServerKey] = s
+	}
+
+	// Health counts and expiring certificates for servers currently in metadata
+	if metadata != nil {
+		for _, entity := range metadata.Entities {
+			for _, server := range entity.Servers {
+				key := store.ServerKey{EntityID: entity.EntityID, BaseURI: server.BaseURI}
+				status, ok := statusMap[key]
+				switch {
+				case !ok || status.IsHealthy == nil:
+					r.UncheckedCount++
+				case *status.IsHealthy:
+					r.HealthyCount++
+				default:
+					r.UnhealthyCount++
+				}
+				if ok && status.CertExpires != nil && status.CertExpires.Before(to.Add(ExpiryHorizon)) {
+					r.ExpiringCerts = append(r.ExpiringCerts, item(key, *status.CertExpires, status.CertCN))
+				}
+			}
+		}
+	}
+	sort.Slice(r.ExpiringCerts, func(i, j int) bool {
+		return r.ExpiringCerts[i].Time.Before(r.ExpiringCerts[j].Time)
+	})
+
+	// Failures and recoveries are transitions in the check history
+	previous := make(map[store.ServerKey]bool)
+	before, err := dataStore.GetLastChecksBefore(from)
+	if err != nil {
+		return nil, err
+	}
+	for _, check := range before {
+		previous[check.ServerKey] = check.IsHealthy
+	}
+	checks, err := dataStore.GetChecksSince(from)
+	if err != nil {
+		return nil, err
+	}
+	for _, check := range checks {
+		if check.CheckedAt.After(to) {
+			continue
+		}
+		wasHealthy, known := previous[check.ServerKey]
+		previous[check.ServerKey] = check.IsHealthy
+		if !known || wasHealthy == check.IsHealthy {
+			continue
+		}
+		if check.IsHealthy {
+			r.Recoveries = append(r.Recoveries, item(check.ServerKey, check.CheckedAt, ""))
+		} else {
+			detail := ""
+			if status, ok := statusMap[check.ServerKey]; ok && status.IsHealthy != nil && !*status.IsHealthy {
+				detail = status.ErrorMessage
+			}
+			r.NewFailures = append(r.NewFailures, item(check.ServerKey, check.CheckedAt, detail))
+		}
+	}
+
+	changes, err := dataStore.GetServerChangesSince(from)
+	if err != nil {
+		return nil, err
+	}
+	for _, change := range changes {
+		if change.ChangedAt.After(to) {
+			continue
+		}
+		if change.Change == store.ServerAdded {
+			r.AddedServers = append(r.AddedServers, item(change.ServerKey, change.ChangedAt, ""))
+		} else {
+			r.RemovedServers = append(r.RemovedServers, item(change.ServerKey, change.ChangedAt, ""))
+		}
+	}
+
+	return r, nil
+}
+
+// Subject returns a one line summary suitable as an email subject
+func (r *Report) Subject() string {
+	title := "Daily"
+	if r.Period == Weekly {
+		title = "Weekly"
+	}
+	return "[matfmonitor] " + title + " federation report " + r.To.Format("2006-01-02")
+}
+
+// Markdown renders the report as Markdown
+func (r *Report) Markdown() (string, error) {
+	var buf bytes.Buffer
+	if err := markdownTemplate.Execute(&buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// HTML renders the report as a standalone HTML document
+func (r *Report) HTML() (string, error) {
+	var buf bytes.Buffer
+	if err := htmlTemplate.Execute(&buf, r); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+var templateFuncs = map[string]any{
+	"date": func(t time.Time) string { return t.Format("2006-01-02") },
+	"time": func(t time.Time) string { return t.Format("2006-01-02 15:04") },
+}
+
+var markdownTemplate = texttemplate.Must(texttemplate.New("markdown").Funcs(templateFuncs).Parse(
+	`# {{if eq .Period "weekly"}}Weekly{{else}}Daily{{end}} federation report
+
+Period: {{time .From}} – {{time .To}}
+
+| Healthy | Unhealthy | Not checked |
+|---------|-----------|-------------|
+| {{.HealthyCount}} | {{.UnhealthyCount}} | {{.UncheckedCount}} |
+
+## New failures ({{len .NewFailures}})
+{{range .NewFailures}}
+- {{time .Time}} **{{.BaseURI}}** ({{if .Organization}}{{.Organization}}{{else}}{{.EntityID}}{{end}}){{if .Detail}}: {{.Detail}}{{end}}
+{{- else}}
+None.
+{{- end}}
+
+## Recoveries ({{len .Recoveries}})
+{{range .Recoveries}}
+- {{time .Time}} **{{.BaseURI}}** ({{if .Organization}}{{.Organization}}{{else}}{{.EntityID}}{{end}})
+{{- else}}
+None.
+{{- end}}
+
+## Certificates expiring within 30 days ({{len .ExpiringCerts}})
+{{range .ExpiringCerts}}
+- {{date .Time}} **{{.BaseURI}}** ({{if .Organization}}{{.Organization}}{{else}}{{.EntityID}}{{end}}){{if .Detail}}, CN {{.Detail}}{{end}}
+{{- else}}
+None.
+{{- end}}
+
+## Servers added to metadata ({{len .AddedServers}})
+{{range .AddedServers}}
+- {{time .Time}} **{{.BaseURI}}** ({{if .Organization}}{{.Organization}}{{else}}{{.EntityID}}{{end}})
+{{- else}}
+None.
+{{- end}}
+
+## Servers removed from metadata ({{len .RemovedServers}})
+{{range .RemovedServers}}
+- {{time .Time}} **{{.BaseURI}}** ({{.EntityID}})
+{{- else}}
+None.
+{{- end}}
+`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Funcs(templateFuncs).Parse(
+	`<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <title>{{if eq .Period "weekly"}}Weekly{{else}}Daily{{end}} federation report</title>
+    <style>
+        body { font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif; color: #333; max-width: 900px; margin: 20px auto; padding: 0 20px; }
+        h1 { color: #2c3e50; }
+        h2 { color: #2c3e50; border-bottom: 1px solid #eee; padding-bottom: 5px; margin-top: 30px; }
+        table { border-collapse: collapse; }
+        td, th { padding: 6px 14px; text-align: left; border-bottom: 1px solid #f0f0f0; }
+        .healthy { color: #27ae60; font-weight: bold; }
+        .unhealthy { color: #e74c3c; font-weight: bold; }
+        .unchecked { color: #95a5a6; font-weight: bold; }
+        .uri { font-family: 'Monaco', 'Menlo', monospace; font-size: 0.9em; color: #2980b9; }
+        .muted { color: #999; }
+    </style>
+</head>
+<body>
+    <h1>{{if eq .Period "weekly"}}Weekly{{else}}Daily{{end}} federation report</h1>
+    <p class="muted">Period: {{time .From}} – {{time .To}}</p>
+
+    <table>
+        <tr><th>Healthy</th><th>Unhealthy</th><th>Not checked</th></tr>
+        <tr><td class="healthy">{{.HealthyCount}}</td><td class="unhealthy">{{.UnhealthyCount}}</td><td class="unchecked">{{.UncheckedCount}}</td></tr>
+    </table>
+
+    {{define "items"}}
+    {{if .}}
+    <table>
+        {{range .}}
+        <tr>
+            <td class="muted">{{time .Time}}</td>
+            <td class="uri">{{.BaseURI}}</td>
+            <td>{{if .Organization}}{{.Organization}}{{else}}{{.EntityID}}{{end}}</td>
+            <td>{{.Detail}}</td>
+        </tr>
+        {{end}}
+    </table>
+    {{else}}
+    <p class="muted">None.</p>
+    {{end}}
+    {{end}}
+
+    <h2>New failures ({{len .NewFailures}})</h2>
+    {{template "items" .NewFailures}}
+
+    <h2>Recoveries ({{len .Recoveries}})</h2>
+    {{template "items" .Recoveries}}
+
+    <h2>Certificates expiring within 30 days ({{len .ExpiringCerts}})</h2>
+    {{template "items" .ExpiringCerts}}
+
+    <h2>Servers added to metadata ({{len .AddedServers}})</h2>
+    {{template "items" .AddedServers}}
+
+    <h2>Servers removed from metadata ({{len .RemovedServers}})</h2>
+    {{template "items" .RemovedServers}}
+
+    <p class="muted">Generated by matfmonitor at {{time .GeneratedAt}}</p>
+</body>
+</html>
+`))
@@ -0,0 +1,99 @@
+package report
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func TestGenerate(t *testing.T) {
+	s, err := store.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("store.New() error = %v", err)
+	}
+	defer s.Close()
+
+	org := "Example Org"
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{{
+		EntityID:     "https://entity.com",
+		Organization: &org,
+		Servers: []fedtls.Server{
+			{BaseURI: "https://failing.com"},
+			{BaseURI: "https://recovered.com"},
+		},
+	}}}
+
+	now := time.Now()
+	failing := store.ServerKey{EntityID: "https://entity.com", BaseURI: "https://failing.com"}
+	recovered := store.ServerKey{EntityID: "https://entity.com", BaseURI: "https://recovered.com"}
+
+	// failing: healthy two days ago, unhealthy today
+	s.AddCheckRecord(&store.CheckRecord{ServerKey: failing, CheckedAt: now.Add(-48 * time.Hour), IsHealthy: true})
+	s.AddCheckRecord(&store.CheckRecord{ServerKey: failing, CheckedAt: now.Add(-time.Hour), IsHealthy: false})
+	// recovered: unhealthy then healthy within the day
+	s.AddCheckRecord(&store.CheckRecord{ServerKey: recovered, CheckedAt: now.Add(-3 * time.Hour), IsHealthy: false})
+	s.AddCheckRecord(&store.CheckRecord{ServerKey: recovered, CheckedAt: now.Add(-2 * time.Hour), IsHealthy: true})
+
+	no, yes := false, true
+	checked := now.Add(-time.Hour)
+	expires := now.Add(10 * 24 * time.Hour)
+	s.SaveStatus(&store.ServerStatus{ServerKey: failing, LastChecked: &checked, IsHealthy: &no, ErrorMessage: "TLS connection failed"})
+	s.SaveStatus(&store.ServerStatus{ServerKey: recovered, LastChecked: &checked, IsHealthy: &yes, CertExpires: &expires, CertCN: "recovered.com"})
+
+	s.AddServerChange(&store.ServerChange{ServerKey: recovered, Change: store.ServerAdded, ChangedAt: now.Add(-5 * time.Hour)})
+
+	r, err := Generate(s, metadata, Daily, now)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	if r.HealthyCount != 1 || r.UnhealthyCount != 1 || r.UncheckedCount != 0 {
+		t.Errorf("counts = %d/%d/%d, want 1/1/0", r.HealthyCount, r.UnhealthyCount, r.UncheckedCount)
+	}
+	if len(r.NewFailures) != 1 || r.NewFailures[0].BaseURI != "https://failing.com" {
+		t.Errorf("NewFailures = %+v, want failing.com", r.NewFailures)
+	}
+	if len(r.NewFailures) == 1 && r.NewFailures[0].Detail != "TLS connection failed" {
+		t.Errorf("NewFailures[0].Detail = %q", r.NewFailures[0].Detail)
+	}
+	// The first check of recovered is inside the period, so only the recovery counts
+	if len(r.Recoveries) != 1 || r.Recoveries[0].BaseURI != "https://recovered.com" {
+		t.Errorf("Recoveries = %+v, want recovered.com", r.Recoveries)
+	}
+	if len(r.ExpiringCerts) != 1 {
+		t.Errorf("ExpiringCerts = %+v, want 1 entry", r.ExpiringCerts)
+	}
+	if len(r.AddedServers) != 1 {
+		t.Errorf("AddedServers = %+v, want 1 entry", r.AddedServers)
+	}
+
+	markdown, err := r.Markdown()
+	if err != nil {
+		t.Fatalf("Markdown() error = %v", err)
+	}
+	if !strings.Contains(markdown, "**https://failing.com** (Example Org): TLS connection failed") {
+		t.Errorf("Markdown() missing failure:\n%s", markdown)
+	}
+	if _, err := r.HTML(); err != nil {
+		t.Fatalf("HTML() error = %v", err)
+	}
+}
+
+func TestNextRun(t *testing.T) {
+	loc := time.UTC
+	at := 7 * time.Hour
+
+	got := NextRun(time.Date(2026, 3, 2, 6, 0, 0, 0, loc), at)
+	if want := time.Date(2026, 3, 2, 7, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("NextRun() before time = %v, want %v", got, want)
+	}
+
+	got = NextRun(time.Date(2026, 3, 2, 7, 0, 0, 0, loc), at)
+	if want := time.Date(2026, 3, 3, 7, 0, 0, 0, loc); !got.Equal(want) {
+		t.Errorf("NextRun() at time = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,121 @@
+package report
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/notify"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// Scheduler generates reports at a fixed time of day and delivers them
+// through notification channels
+type Scheduler struct {
+	store         *store.Store
+	metadataStore *fedtls.MetadataStore
+	notifier      *notify.Notifier
+	channels      []string
+	daily         bool
+	weekly        bool
+	timeOfDay     time.Duration
+	weekday       time.Weekday
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewScheduler creates a new report Scheduler. Reports are generated
+// timeOfDay after local midnight, weekly reports on weekday. channels names
+// the notification channels to deliver to, all channels if empty.
+func NewScheduler(
+	dataStore *store.Store,
+	metadataStore *fedtls.MetadataStore,
+	notifier *notify.Notifier,
+	channels []string,
+	daily bool,
+	weekly bool,
+	timeOfDay time.Duration,
+	weekday time.Weekday,
+) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		store:         dataStore,
+		metadataStore: metadataStore,
+		notifier:      notifier,
+		channels:      channels,
+		daily:         daily,
+		weekly:        weekly,
+		timeOfDay:     timeOfDay,
+		weekday:       weekday,
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Start begins generating reports
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go s.run()
+}
+
+// Stop stops generating reports
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+func (s *Scheduler) run() {
+	defer s.wg.Done()
+
+	for {
+		next := NextRun(time.Now(), s.timeOfDay)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if s.daily {
+			s.deliver(Daily, next)
+		}
+		if s.weekly && next.Weekday() == s.weekday {
+			s.deliver(Weekly, next)
+		}
+	}
+}
+
+func (s *Scheduler) deliver(period string, to time.Time) {
+	r, err := Generate(s.store, s.metadataStore.GetMetadata(), period, to)
+	if err != nil {
+		log.Printf("Error generating %s report: %v", period, err)
+		return
+	}
+	markdown, err := r.Markdown()
+	if err != nil {
+		log.Printf("Error rendering %s report: %v", period, err)
+		return
+	}
+	html, err := r.HTML()
+	if err != nil {
+		log.Printf("Error rendering %s report: %v", period, err)
+		return
+	}
+	s.notifier.PublishReport(r.Subject(), markdown, html, s.channels)
+	log.Printf("Delivered %s report", period)
+}
+
+// NextRun returns the first time after now that is timeOfDay past local midnight
+func NextRun(now time.Time, timeOfDay time.Duration) time.Time {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	next := midnight.Add(timeOfDay)
+	if !next.After(now) {
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location()).Add(timeOfDay)
+	}
+	return next
+}
@@ -0,0 +1,163 @@
+// Package rotation assesses whether servers' pin sets allow a certificate
+// rotation without downtime.
+//
+// A safe rotation publishes the new certificate's pin before the
+// certificate is deployed, and removes the old pin only afterwards. A
+// server is therefore ready to rotate when it has a backup pin: a pin for a
+// certificate that hasn't been deployed yet.
+package rotation
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// DefaultStaleAfter is how long a pin that was previously deployed can go
+// without matching before it is considered stale
+const DefaultStaleAfter = 30 * 24 * time.Hour
+
+// Status summarizes a server's pin set
+type Status string
+
+const (
+	// StatusBackup means a backup pin is published
+	StatusBackup Status = "backup"
+	// StatusSinglePin means the server has only one usable pin
+	StatusSinglePin Status = "single-pin"
+	// StatusNoBackup means there are several pins, but all of them have
+	// been deployed already
+	StatusNoBackup Status = "no-backup"
+	// StatusUnknown means the server hasn't been checked or has no usable pins
+	StatusUnknown Status = "unknown"
+)
+
+// PinState tells how a pin relates to the deployed certificates
+type PinState string
+
+const (
+	// PinCurrent matches the deployed certificate
+	PinCurrent PinState = "current"
+	// PinBackup has never matched a deployed certificate
+	PinBackup PinState = "backup"
+	// PinPrevious matched a deployed certificate recently
+	PinPrevious PinState = "previous"
+	// PinStale hasn't matched a deployed certificate in a long time
+	PinStale PinState = "stale"
+)
+
+// Pin is a server pin and its state
+type Pin struct {
+	Digest   string
+	State    PinState
+	LastSeen *time.Time
+}
+
+// Assessment is the rotation readiness of a server
+type Assessment struct {
+	Status   Status
+	Pins     []Pin
+	Warnings []string
+}
+
+// Assess classifies a server's pins. fingerprint is the currently deployed
+// certificate (empty if unknown), seen is when each fingerprint was last
+// presented by the server and removed the pins removed from the server by
+// the latest metadata change.
+func Assess(server fedtls.Server, fingerprint string, seen map[string]*store.SeenFingerprint, removed []string, now time.Time, staleAfter time.Duration) Assessment {
+	var a Assessment
+	backups, stale := 0, 0
+	for _, pin := range server.Pins {
+		if pin.Alg != checker.PinAlg {
+			continue
+		}
+		p := Pin{Digest: pin.Digest}
+		s := seen[pin.Digest]
+		if s != nil {
+			p.LastSeen = &s.LastSeen
+		}
+		switch {
+		case pin.Digest == fingerprint:
+			p.State = PinCurrent
+		case s == nil:
+			p.State = PinBackup
+			backups++
+		case now.Sub(s.LastSeen) > staleAfter:
+			p.State = PinStale
+			stale++
+			a.Warnings = append(a.Warnings, fmt.Sprintf("pin %s hasn't matched a deployed certificate since %s and can be removed", short(pin.Digest), s.LastSeen.Format("2006-01-02")))
+		default:
+			p.State = PinPrevious
+		}
+		a.Pins = append(a.Pins, p)
+	}
+
+	switch {
+	case len(a.Pins) == 0 || fingerprint == "":
+		a.Status = StatusUnknown
+	case backups > 0:
+		a.Status = StatusBackup
+	case len(a.Pins) == 1:
+		a.Status = StatusSinglePin
+		a.Warnings = append(a.Warnings, "only one pin is published, publish the pin of the next certificate before rotating")
+	default:
+		a.Status = StatusNoBackup
+		a.Warnings = append(a.Warnings, "no backup pin, all pins have been deployed already; publish the pin of the next certificate before rotating")
+	}
+
+	if fingerprint != "" && !checker.MatchesPin(fingerprint, server.Pins) {
+		for _, digest := range removed {
+			if digest == fingerprint {
+				a.Warnings = append(a.Warnings, fmt.Sprintf("the deployed certificate matches only pin %s, which was just removed from metadata", short(digest)))
+			}
+		}
+	}
+	return a
+}
+
+// RemovedPins returns the pins of each server in previous that are missing
+// from the same server in current
+func RemovedPins(previous, current *fedtls.Metadata) map[store.ServerKey][]string {
+	removed := make(map[store.ServerKey][]string)
+	if previous == nil || current == nil {
+		return removed
+	}
+
+	currentPins := make(map[store.ServerKey]map[string]bool)
+	for _, entity := range current.Entities {
+		for _, server := range entity.Servers {
+			pins := make(map[string]bool)
+			for _, pin := range server.Pins {
+				pins[pin.Digest] = true
+			}
+			currentPins[store.ServerKey{EntityID: entity.EntityID, BaseURI: server.BaseURI}] = pins
+		}
+	}
+
+	for _, entity := range previous.Entities {
+		for _, server := range entity.Servers {
+			key := store.ServerKey{EntityID: entity.EntityID, BaseURI: server.BaseURI}
+			pins, ok := currentPins[key]
+			if !ok {
+				continue
+			}
+			for _, pin := range server.Pins {
+				if !pins[pin.Digest] {
+					removed[key] = append(removed[key], pin.Digest)
+				}
+			}
+		}
+	}
+	return removed
+}
+
+// short abbreviates a pin digest for messages
+func short(digest string) string {
+	if len(digest) > 12 {
+		return digest[:12] + "…"
+	}
+	return digest
+}
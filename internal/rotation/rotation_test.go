@@ -0,0 +1,86 @@
+package rotation
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func pins(digests ...string) []fedtls.Pin {
+	var result []fedtls.Pin
+	for _, d := range digests {
+		result = append(result, fedtls.Pin{Alg: "sha256", Digest: d})
+	}
+	return result
+}
+
+func hasWarning(a Assessment, substring string) bool {
+	for _, w := range a.Warnings {
+		if strings.Contains(w, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestAssess(t *testing.T) {
+	now := time.Now()
+	seen := map[string]*store.SeenFingerprint{
+		"current": {LastSeen: now},
+		"recent":  {LastSeen: now.Add(-5 * 24 * time.Hour)},
+		"old":     {LastSeen: now.Add(-60 * 24 * time.Hour)},
+	}
+
+	tests := []struct {
+		name        string
+		pins        []fedtls.Pin
+		fingerprint string
+		removed     []string
+		status      Status
+		warning     string
+	}{
+		{"backup present", pins("current", "next"), "current", nil, StatusBackup, ""},
+		{"single pin", pins("current"), "current", nil, StatusSinglePin, "only one pin"},
+		{"just rotated", pins("current", "recent"), "current", nil, StatusNoBackup, "no backup pin"},
+		{"stale pin", pins("current", "old", "next"), "current", nil, StatusBackup, "can be removed"},
+		{"not checked", pins("next"), "", nil, StatusUnknown, ""},
+		{"pin removed too early", pins("next"), "current", []string{"current"}, StatusBackup, "just removed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := Assess(fedtls.Server{Pins: tt.pins}, tt.fingerprint, seen, tt.removed, now, DefaultStaleAfter)
+			if a.Status != tt.status {
+				t.Errorf("Status = %s, want %s", a.Status, tt.status)
+			}
+			if tt.warning == "" && len(a.Warnings) > 0 {
+				t.Errorf("unexpected warnings %v", a.Warnings)
+			}
+			if tt.warning != "" && !hasWarning(a, tt.warning) {
+				t.Errorf("Warnings = %v, want %q", a.Warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestRemovedPins(t *testing.T) {
+	metadata := func(digests ...string) *fedtls.Metadata {
+		return &fedtls.Metadata{Entities: []fedtls.Entity{{
+			EntityID: "https://a.com",
+			Servers:  []fedtls.Server{{BaseURI: "https://api.a.com/", Pins: pins(digests...)}},
+		}}}
+	}
+
+	removed := RemovedPins(metadata("old", "current"), metadata("current", "next"))
+	key := store.ServerKey{EntityID: "https://a.com", BaseURI: "https://api.a.com/"}
+	if len(removed[key]) != 1 || removed[key][0] != "old" {
+		t.Errorf("RemovedPins() = %v", removed)
+	}
+
+	if removed := RemovedPins(nil, metadata("current")); len(removed) != 0 {
+		t.Errorf("RemovedPins(nil) = %v", removed)
+	}
+}
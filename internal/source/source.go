@@ -0,0 +1,245 @@
+// Package source monitors the federation metadata source: whether the
+// metadata store has loaded verified metadata, and how old it is.
+package source
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// Status is the latest known state of the metadata source
+type Status struct {
+	URL string
+
+	// Whether the metadata store has loaded any metadata, and when it last
+	// told us about new metadata
+	Loaded     bool
+	LastChange *time.Time
+
+	// The metadata store only writes the cache file after a successful
+	// download and verification, so its modification time is when the
+	// metadata in use was last downloaded. Nil if there is no cache file or
+	// it doesn't verify.
+	LastSuccess *time.Time
+
+	// Signature verification of the cache file
+	Verified    bool
+	VerifyError string
+
+	// From the JWS protected header of the cache file, if present
+	KeyID     string
+	Algorithm string
+	IssuedAt  *time.Time
+	Expires   *time.Time
+
+	CacheModified *time.Time
+	CacheAge      time.Duration
+
+	// Set when the metadata in use can't be trusted to be current
+	Stale       bool
+	StaleReason string
+}
+
+// Monitor follows the metadata store and its cache file
+type Monitor struct {
+	url           string
+	metadataStore *fedtls.MetadataStore
+	jwksPath      string
+	cachePath     string
+	interval      time.Duration
+	maxAge        time.Duration
+
+	lock      sync.Mutex
+	status    Status
+	inspected time.Time // modification time of the inspected cache file
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewMonitor creates a new Monitor for the metadata store downloading from
+// url into cachePath. The cache file is inspected when the store reports new
+// metadata and every interval. Metadata is considered stale when the store
+// hasn't loaded any, when it hasn't been downloaded and verified for maxAge
+// or when it has expired.
+func NewMonitor(url string, metadataStore *fedtls.MetadataStore, jwksPath, cachePath string, interval, maxAge time.Duration) *Monitor {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Monitor{
+		url:           url,
+		metadataStore: metadataStore,
+		jwksPath:      jwksPath,
+		cachePath:     cachePath,
+		interval:      interval,
+		maxAge:        maxAge,
+		status:        Status{URL: url},
+		ctx:           ctx,
+		cancel:        cancel,
+	}
+}
+
+// Start inspects the cache file and begins following the metadata store
+func (m *Monitor) Start() {
+	m.refresh()
+
+	metadataChanged := make(chan int, 1)
+	m.metadataStore.AddChangeListener(metadataChanged)
+
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-m.ctx.Done():
+				return
+			case <-metadataChanged:
+				now := time.Now()
+				m.lock.Lock()
+				m.status.LastChange = &now
+				m.lock.Unlock()
+				// The store notifies before writing the cache file, which
+				// Status and the ticker will pick up
+				m.refresh()
+			case <-ticker.C:
+				m.refresh()
+			}
+		}
+	}()
+}
+
+// Stop stops following the metadata store
+func (m *Monitor) Stop() {
+	m.cancel()
+	m.wg.Wait()
+}
+
+// Status returns the current status of the metadata source
+func (m *Monitor) Status() Status {
+	m.refresh()
+
+	m.lock.Lock()
+	status := m.status
+	m.lock.Unlock()
+
+	if m.metadataStore != nil {
+		status.Loaded = len(m.metadataStore.GetMetadata().Entities) > 0
+	}
+	status.evaluate(time.Now(), m.maxAge)
+	return status
+}
+
+// evaluate sets the cache age and decides if the metadata is stale
+func (s *Status) evaluate(now time.Time, maxAge time.Duration) {
+	s.Stale = false
+	s.StaleReason = ""
+	s.CacheAge = 0
+	if s.CacheModified != nil {
+		s.CacheAge = now.Sub(*s.CacheModified)
+	}
+
+	switch {
+	case s.Expires != nil && now.After(*s.Expires):
+		s.Stale = true
+		s.StaleReason = fmt.Sprintf("metadata expired at %s", s.Expires.Format(time.RFC3339))
+	case !s.Loaded:
+		s.Stale = true
+		s.StaleReason = "the metadata store hasn't loaded any metadata"
+	case s.LastSuccess == nil:
+		s.Stale = true
+		s.StaleReason = "metadata has never been downloaded and verified"
+	case now.Sub(*s.LastSuccess) > maxAge:
+		s.Stale = true
+		s.StaleReason = fmt.Sprintf("metadata last downloaded and verified %s ago", now.Sub(*s.LastSuccess).Truncate(time.Minute))
+	}
+}
+
+// refresh inspects the cache file if it has changed since it was last
+// inspected
+func (m *Monitor) refresh() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	info, err := os.Stat(m.cachePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Failed to read metadata cache: %v", err)
+		}
+		m.status = Status{URL: m.url, LastChange: m.status.LastChange}
+		m.inspected = time.Time{}
+		return
+	}
+	modified := info.ModTime()
+	if m.status.CacheModified != nil && modified.Equal(m.inspected) {
+		return
+	}
+	content, err := os.ReadFile(m.cachePath)
+	if err != nil {
+		log.Printf("Failed to read metadata cache: %v", err)
+		return
+	}
+
+	m.inspected = modified
+	m.status.CacheModified = &modified
+	m.verify(content)
+	m.status.LastSuccess = nil
+	if m.status.Verified {
+		m.status.LastSuccess = &modified
+	} else {
+		log.Printf("Metadata cache doesn't verify: %s", m.status.VerifyError)
+	}
+}
+
+// verify checks the signature and records the header fields. Must be
+// called with the lock held.
+func (m *Monitor) verify(content []byte) {
+	m.status.KeyID = ""
+	m.status.Algorithm = ""
+	m.status.IssuedAt = nil
+	m.status.Expires = nil
+
+	if message, err := jws.Parse(content); err == nil && len(message.Signatures()) > 0 {
+		headers := message.Signatures()[0].ProtectedHeaders()
+		m.status.KeyID = headers.KeyID()
+		m.status.Algorithm = headers.Algorithm().String()
+		m.status.IssuedAt = headerTime(headers, "iat")
+		m.status.Expires = headerTime(headers, "exp")
+	}
+
+	jwks, err := os.ReadFile(m.jwksPath)
+	if err != nil {
+		m.status.Verified = false
+		m.status.VerifyError = fmt.Sprintf("reading JWKS file: %v", err)
+		return
+	}
+	if _, err := fedtls.VerifyRaw(content, jwks); err != nil {
+		m.status.Verified = false
+		m.status.VerifyError = err.Error()
+		return
+	}
+	m.status.Verified = true
+	m.status.VerifyError = ""
+}
+
+// headerTime reads a numeric date header
+func headerTime(headers jws.Headers, name string) *time.Time {
+	value, ok := headers.Get(name)
+	if !ok {
+		return nil
+	}
+	seconds, ok := value.(float64)
+	if !ok {
+		return nil
+	}
+	t := time.Unix(int64(seconds), 0)
+	return &t
+}
@@ -0,0 +1,160 @@
+package source
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwk"
+	"github.com/lestrrat-go/jwx/v2/jws"
+)
+
+// signedMetadata creates a JWKS file and metadata signed with its key
+func signedMetadata(t *testing.T, dir string, iat, exp time.Time) (jwksPath string, signed []byte) {
+	t.Helper()
+	raw, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwk.FromRaw(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.Set(jwk.KeyIDKey, "key-1")
+	key.Set(jwk.AlgorithmKey, jwa.ES256)
+
+	public, err := jwk.PublicKeyOf(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := jwk.NewSet()
+	set.AddKey(public)
+	jwks, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksPath = filepath.Join(dir, "jwks.json")
+	if err := os.WriteFile(jwksPath, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	headers := jws.NewHeaders()
+	headers.Set(jws.KeyIDKey, "key-1")
+	headers.Set("iat", float64(iat.Unix()))
+	headers.Set("exp", float64(exp.Unix()))
+	signed, err = jws.Sign([]byte(`{"version":"1.0.0","entities":[]}`), jws.WithKey(jwa.ES256, key, jws.WithProtectedHeaders(headers)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwksPath, signed
+}
+
+func TestMonitorCache(t *testing.T) {
+	dir := t.TempDir()
+	iat := time.Now().Add(-time.Hour).Truncate(time.Second)
+	exp := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+	jwksPath, signed := signedMetadata(t, dir, iat, exp)
+
+	cachePath := filepath.Join(dir, "cache.jws")
+	m := NewMonitor("https://md.example.com/", nil, jwksPath, cachePath, time.Hour, time.Hour)
+
+	// No cache file yet
+	status := m.Status()
+	if status.Verified || status.LastSuccess != nil || !status.Stale {
+		t.Fatalf("status without cache file: %+v", status)
+	}
+
+	if err := os.WriteFile(cachePath, signed, 0600); err != nil {
+		t.Fatal(err)
+	}
+	status = m.Status()
+	if !status.Verified || status.LastSuccess == nil || status.CacheModified == nil || !status.LastSuccess.Equal(*status.CacheModified) {
+		t.Fatalf("cache not inspected: %+v", status)
+	}
+	if status.KeyID != "key-1" || status.Algorithm != "ES256" {
+		t.Errorf("KeyID = %q, Algorithm = %q", status.KeyID, status.Algorithm)
+	}
+	if status.IssuedAt == nil || !status.IssuedAt.Equal(iat) || status.Expires == nil || !status.Expires.Equal(exp) {
+		t.Errorf("IssuedAt = %v, Expires = %v", status.IssuedAt, status.Expires)
+	}
+
+	// A cache file older than maxAge is stale once the store has loaded it
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(cachePath, old, old); err != nil {
+		t.Fatal(err)
+	}
+	status = m.Status()
+	status.Loaded = true
+	status.evaluate(time.Now(), time.Hour)
+	if !status.Stale || !status.LastSuccess.Equal(old) {
+		t.Errorf("old cache: LastSuccess = %v, Stale = %v", status.LastSuccess, status.Stale)
+	}
+
+	if err := os.Remove(cachePath); err != nil {
+		t.Fatal(err)
+	}
+	if status := m.Status(); status.Verified || status.LastSuccess != nil || status.KeyID != "" {
+		t.Errorf("removed cache file still inspected: %+v", status)
+	}
+}
+
+func TestMonitorBadSignature(t *testing.T) {
+	dir := t.TempDir()
+	_, signed := signedMetadata(t, dir, time.Now(), time.Now().Add(time.Hour))
+	// Another key
+	jwksPath, _ := signedMetadata(t, dir, time.Now(), time.Now().Add(time.Hour))
+
+	cachePath := filepath.Join(dir, "cache.jws")
+	if err := os.WriteFile(cachePath, signed, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	m := NewMonitor("https://md.example.com/", nil, jwksPath, cachePath, time.Hour, time.Hour)
+	status := m.Status()
+	if status.Verified || status.VerifyError == "" {
+		t.Errorf("Verified = %v, VerifyError = %q", status.Verified, status.VerifyError)
+	}
+	if status.LastSuccess != nil || status.CacheModified == nil {
+		t.Errorf("LastSuccess = %v, CacheModified = %v", status.LastSuccess, status.CacheModified)
+	}
+	if !status.Stale {
+		t.Error("never verified metadata not stale")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) *time.Time {
+		t := now.Add(d)
+		return &t
+	}
+
+	tests := []struct {
+		name   string
+		status Status
+		stale  bool
+	}{
+		{"fresh", Status{Loaded: true, LastSuccess: at(-time.Minute), CacheModified: at(-time.Minute)}, false},
+		{"not loaded", Status{LastSuccess: at(-time.Minute), CacheModified: at(-time.Minute)}, true},
+		{"never verified", Status{Loaded: true, CacheModified: at(-time.Minute)}, true},
+		{"old cache", Status{Loaded: true, LastSuccess: at(-3 * time.Hour), CacheModified: at(-3 * time.Hour)}, true},
+		{"expired", Status{Loaded: true, LastSuccess: at(-time.Minute), CacheModified: at(-time.Minute), Expires: at(-time.Second)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.status.evaluate(now, 2*time.Hour)
+			if tt.status.Stale != tt.stale {
+				t.Errorf("Stale = %v (%s), want %v", tt.status.Stale, tt.status.StaleReason, tt.stale)
+			}
+			if tt.status.Stale && tt.status.StaleReason == "" {
+				t.Error("stale without reason")
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+// Package stats computes federation-wide statistics about TLS parameters
+// and certificates from the stored check results.
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// Count is the number of servers with a given value
+type Count struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+}
+
+// Bucket is a range of days and the number of certificates in it
+type Bucket struct {
+	Label string `json:"label"`
+	Count int    `json:"count"`
+	// Upper bound in days, exclusive. Zero for the last, open-ended bucket.
+	maxDays int
+}
+
+// Stats summarizes the latest check results of all servers in metadata
+type Stats struct {
+	Servers   int `json:"servers"`
+	Checked   int `json:"checked"`
+	Healthy   int `json:"healthy"`
+	Unhealthy int `json:"unhealthy"`
+	// Servers whose certificate details are known, which requires a check
+	// by a version that records them
+	WithDetails int `json:"with_details"`
+
+	TLSVersions    []Count  `json:"tls_versions"`
+	CipherSuites   []Count  `json:"cipher_suites"`
+	KeyTypes       []Count  `json:"key_types"`
+	Issuers        []Count  `json:"issuers"`
+	FailureReasons []Count  `json:"failure_reasons"`
+	Lifetimes      []Bucket `json:"lifetimes"`
+	DaysToExpiry   []Bucket `json:"days_to_expiry"`
+}
+
+// lifetimeBuckets follow common limits for certificate validity periods
+func lifetimeBuckets() []Bucket {
+	return []Bucket{
+		{Label: "≤ 90 days", maxDays: 91},
+		{Label: "91–200 days", maxDays: 201},
+		{Label: "201–398 days", maxDays: 399},
+		{Label: "399–825 days", maxDays: 826},
+		{Label: "> 825 days"},
+	}
+}
+
+// expiryBuckets start with already expired certificates, which have a
+// negative number of days left
+func expiryBuckets() []Bucket {
+	return []Bucket{
+		{Label: "Expired", maxDays: 0},
+		{Label: "< 7 days", maxDays: 7},
+		{Label: "7–29 days", maxDays: 30},
+		{Label: "30–89 days", maxDays: 90},
+		{Label: "90–179 days", maxDays: 180},
+		{Label: "180–364 days", maxDays: 365},
+		{Label: "≥ 365 days"},
+	}
+}
+
+// add counts days in the first bucket it fits in, the last bucket is open
+// ended
+func add(buckets []Bucket, days int) {
+	for i := range buckets {
+		if i == len(buckets)-1 || days < buckets[i].maxDays {
+			buckets[i].Count++
+			return
+		}
+	}
+}
+
+// Compute returns statistics for the servers in metadata, from their latest
+// statuses. Statuses of servers no longer in metadata are ignored.
+func Compute(metadata *fedtls.Metadata, statuses []*store.ServerStatus, now time.Time) *Stats {
+	s := &Stats{
+		TLSVersions:    []Count{},
+		CipherSuites:   []Count{},
+		KeyTypes:       []Count{},
+		Issuers:        []Count{},
+		FailureReasons: []Count{},
+		Lifetimes:      lifetimeBuckets(),
+		DaysToExpiry:   expiryBuckets(),
+	}
+	if metadata == nil {
+		return s
+	}
+
+	byKey := make(map[store.ServerKey]*store.ServerStatus, len(statuses))
+	for _, status := range statuses {
+		byKey[status.ServerKey] = status
+	}
+
+	versions := make(map[string]int)
+	suites := make(map[string]int)
+	keys := make(map[string]int)
+	issuers := make(map[string]int)
+	failures := make(map[string]int)
+
+	for _, entity := range metadata.Entities {
+		for _, server := range entity.Servers {
+			s.Servers++
+			status := byKey[store.ServerKey{EntityID: entity.EntityID, BaseURI: server.BaseURI}]
+			if status == nil || status.IsHealthy == nil {
+				continue
+			}
+			s.Checked++
+			if *status.IsHealthy {
+				s.Healthy++
+			} else {
+				s.Unhealthy++
+				reason := status.FailureReason
+				if reason == "" {
+					reason = "unknown"
+				}
+				failures[reason]++
+			}
+
+			if status.TLSVersion != "" {
+				s.WithDetails++
+				versions[status.TLSVersion]++
+			}
+			if status.CipherSuite != "" {
+				suites[status.CipherSuite]++
+			}
+			if status.KeyType != "" {
+				label := status.KeyType
+				if status.KeyBits > 0 {
+					label = fmt.Sprintf("%s %d", status.KeyType, status.KeyBits)
+				}
+				keys[label]++
+			}
+			if status.CertIssuer != "" {
+				issuers[status.CertIssuer]++
+			}
+			if status.CertExpires != nil {
+				add(s.DaysToExpiry, daysUntil(now, *status.CertExpires))
+				if status.CertNotBefore != nil {
+					add(s.Lifetimes, daysUntil(*status.CertNotBefore, *status.CertExpires))
+				}
+			}
+		}
+	}
+
+	s.TLSVersions = sorted(versions)
+	s.CipherSuites = sorted(suites)
+	s.KeyTypes = sorted(keys)
+	s.Issuers = sorted(issuers)
+	s.FailureReasons = sorted(failures)
+	return s
+}
+
+// daysUntil returns the whole days from from to to, negative if to has
+// passed
+func daysUntil(from, to time.Time) int {
+	d := to.Sub(from)
+	if d < 0 {
+		return int(d/(24*time.Hour)) - 1
+	}
+	return int(d / (24 * time.Hour))
+}
+
+// sorted returns counts with the most common first, then by label
+func sorted(counts map[string]int) []Count {
+	result := make([]Count, 0, len(counts))
+	for label, count := range counts {
+		result = append(result, Count{Label: label, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Label < result[j].Label
+	})
+	return result
+}
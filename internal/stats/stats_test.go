@@ -0,0 +1,80 @@
+package stats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func TestCompute(t *testing.T) {
+	now := time.Now()
+	healthy, unhealthy := true, false
+	days := func(n int) *time.Time {
+		t := now.Add(time.Duration(n)*24*time.Hour + time.Hour)
+		return &t
+	}
+
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{{
+		EntityID: "https://a.com",
+		Servers: []fedtls.Server{
+			{BaseURI: "https://one.a.com/"},
+			{BaseURI: "https://two.a.com/"},
+			{BaseURI: "https://three.a.com/"},
+			{BaseURI: "https://unchecked.a.com/"},
+		},
+	}}}
+	status := func(baseURI string, ok *bool) *store.ServerStatus {
+		return &store.ServerStatus{ServerKey: store.ServerKey{EntityID: "https://a.com", BaseURI: baseURI}, IsHealthy: ok}
+	}
+
+	one := status("https://one.a.com/", &healthy)
+	one.TLSVersion, one.CipherSuite, one.KeyType, one.KeyBits, one.CertIssuer = "TLS 1.3", "TLS_AES_128_GCM_SHA256", "ECDSA", 256, "R3"
+	one.CertNotBefore, one.CertExpires = days(-60), days(29)
+
+	two := status("https://two.a.com/", &healthy)
+	two.TLSVersion, two.CipherSuite, two.KeyType, two.KeyBits, two.CertIssuer = "TLS 1.2", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", "RSA", 2048, "R3"
+	two.CertNotBefore, two.CertExpires = days(-300), days(400)
+
+	three := status("https://three.a.com/", &unhealthy)
+	three.FailureReason = "expired"
+	three.CertExpires = days(-3)
+
+	removed := &store.ServerStatus{ServerKey: store.ServerKey{EntityID: "https://gone.com", BaseURI: "https://gone.com/"}, IsHealthy: &unhealthy}
+
+	s := Compute(metadata, []*store.ServerStatus{one, two, three, removed, status("https://unchecked.a.com/", nil)}, now)
+
+	if s.Servers != 4 || s.Checked != 3 || s.Healthy != 2 || s.Unhealthy != 1 || s.WithDetails != 2 {
+		t.Errorf("totals = %+v", s)
+	}
+	if len(s.Issuers) != 1 || s.Issuers[0] != (Count{Label: "R3", Count: 2}) {
+		t.Errorf("Issuers = %v", s.Issuers)
+	}
+	if len(s.KeyTypes) != 2 || s.KeyTypes[0].Label != "ECDSA 256" {
+		t.Errorf("KeyTypes = %v", s.KeyTypes)
+	}
+	if len(s.FailureReasons) != 1 || s.FailureReasons[0] != (Count{Label: "expired", Count: 1}) {
+		t.Errorf("FailureReasons = %v", s.FailureReasons)
+	}
+
+	wantExpiry := []int{1, 0, 1, 0, 0, 0, 1}
+	for i, b := range s.DaysToExpiry {
+		if b.Count != wantExpiry[i] {
+			t.Errorf("DaysToExpiry[%s] = %d, want %d", b.Label, b.Count, wantExpiry[i])
+		}
+	}
+	wantLifetimes := []int{1, 0, 0, 1, 0}
+	for i, b := range s.Lifetimes {
+		if b.Count != wantLifetimes[i] {
+			t.Errorf("Lifetimes[%s] = %d, want %d", b.Label, b.Count, wantLifetimes[i])
+		}
+	}
+}
+
+func TestComputeNoMetadata(t *testing.T) {
+	s := Compute(nil, nil, time.Now())
+	if s.Servers != 0 || len(s.DaysToExpiry) == 0 || s.TLSVersions == nil {
+		t.Errorf("Compute(nil) = %+v", s)
+	}
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// AlertState is a firing alert. Rules evaluated for the whole federation
+// use an empty ServerKey.
+type AlertState struct {
+	Rule string
+	ServerKey
+	Since   time.Time
+	Message string
+}
+
+// GetAlertState returns the firing state of a rule for a server, or nil if
+// the rule is not firing
+func (s *Store) GetAlertState(rule string, server ServerKey) (*AlertState, error) {
+	query := `
+		SELECT rule, entity_id, base_uri, since, message
+		FROM alert_state
+		WHERE rule = ? AND entity_id = ? AND base_uri = ?
+	`
+	state := &AlertState{}
+	var message sql.NullString
+	err := s.db.QueryRow(query, rule, server.EntityID, server.BaseURI).Scan(
+		&state.Rule, &state.EntityID, &state.BaseURI, &state.Since, &message,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state.Message = message.String
+	return state, nil
+}
+
+// GetAlertStates returns all firing alerts
+func (s *Store) GetAlertStates() ([]*AlertState, error) {
+	query := `
+		SELECT rule, entity_id, base_uri, since, message
+		FROM alert_state
+		ORDER BY since
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var states []*AlertState
+	for rows.Next() {
+		state := &AlertState{}
+		var message sql.NullString
+		if err := rows.Scan(&state.Rule, &state.EntityID, &state.BaseURI, &state.Since, &message); err != nil {
+			return nil, err
+		}
+		state.Message = message.String
+		states = append(states, state)
+	}
+	return states, rows.Err()
+}
+
+// SaveAlertState marks a rule as firing
+func (s *Store) SaveAlertState(state *AlertState) error {
+	query := `
+		INSERT INTO alert_state (rule, entity_id, base_uri, since, message)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(rule, entity_id, base_uri) DO UPDATE SET
+			since = excluded.since,
+			message = excluded.message
+	`
+	_, err := s.db.Exec(query, state.Rule, state.EntityID, state.BaseURI, state.Since, state.Message)
+	return err
+}
+
+// DeleteAlertState marks a rule as no longer firing
+func (s *Store) DeleteAlertState(rule string, server ServerKey) error {
+	_, err := s.db.Exec(`DELETE FROM alert_state WHERE rule = ? AND entity_id = ? AND base_uri = ?`,
+		rule, server.EntityID, server.BaseURI)
+	return err
+}
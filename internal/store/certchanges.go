@@ -0,0 +1,70 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CertChange records a server presenting a different certificate than at
+// the previous check
+type CertChange struct {
+	ServerKey
+	ChangedAt      time.Time
+	OldFingerprint string
+	NewFingerprint string
+	OldCN          string
+	NewCN          string
+	OldExpires     *time.Time
+	NewExpires     *time.Time
+
+	// Whether the new certificate matches a pin in metadata
+	Pinned bool
+}
+
+// AddCertChange records a certificate change
+func (s *Store) AddCertChange(change *CertChange) error {
+	query := `
+		INSERT INTO cert_changes (
+			entity_id, base_uri, changed_at, old_fingerprint, new_fingerprint,
+			old_cn, new_cn, old_expires, new_expires, pinned
+		) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`
+	_, err := s.db.Exec(query,
+		change.EntityID, change.BaseURI, change.ChangedAt, change.OldFingerprint, change.NewFingerprint,
+		change.OldCN, change.NewCN, change.OldExpires, change.NewExpires, change.Pinned,
+	)
+	return err
+}
+
+// GetCertChangesSince returns certificate changes at or after since,
+// newest first
+func (s *Store) GetCertChangesSince(since time.Time) ([]*CertChange, error) {
+	query := `
+		SELECT entity_id, base_uri, changed_at, old_fingerprint, new_fingerprint,
+		       old_cn, new_cn, old_expires, new_expires, pinned
+		FROM cert_changes
+		WHERE changed_at >= ?
+		ORDER BY changed_at DESC, entity_id, base_uri
+	`
+	rows, err := s.db.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []*CertChange
+	for rows.Next() {
+		change := &CertChange{}
+		var oldCN, newCN sql.NullString
+		if err := rows.Scan(
+			&change.EntityID, &change.BaseURI, &change.ChangedAt, &change.OldFingerprint, &change.NewFingerprint,
+			&oldCN, &newCN, &change.OldExpires, &change.NewExpires, &change.Pinned,
+		); err != nil {
+			return nil, err
+		}
+		change.OldCN = oldCN.String
+		change.NewCN = newCN.String
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
@@ -0,0 +1,53 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCertChanges(t *testing.T) {
+	s, err := New(tempDBPath(t))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+
+	now := time.Now().Truncate(time.Second)
+	expires := now.Add(90 * 24 * time.Hour)
+	key := ServerKey{EntityID: "https://a.com", BaseURI: "https://api.a.com/"}
+
+	changes := []*CertChange{
+		{ServerKey: key, ChangedAt: now.Add(-48 * time.Hour), OldFingerprint: "f1", NewFingerprint: "f2", Pinned: true},
+		{ServerKey: key, ChangedAt: now, OldFingerprint: "f2", NewFingerprint: "f3", OldCN: "old", NewCN: "new", NewExpires: &expires},
+	}
+	for _, c := range changes {
+		if err := s.AddCertChange(c); err != nil {
+			t.Fatalf("AddCertChange() error = %v", err)
+		}
+	}
+
+	recent, err := s.GetCertChangesSince(now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("GetCertChangesSince() error = %v", err)
+	}
+	if len(recent) != 1 {
+		t.Fatalf("GetCertChangesSince() returned %d changes, want 1", len(recent))
+	}
+	c := recent[0]
+	if c.NewFingerprint != "f3" || c.OldCN != "old" || c.NewCN != "new" || c.Pinned || c.OldExpires != nil ||
+		c.NewExpires == nil || !c.NewExpires.Equal(expires) {
+		t.Errorf("unexpected change %+v", c)
+	}
+
+	all, err := s.GetCertChangesSince(time.Time{})
+	if err != nil || len(all) != 2 || all[0].NewFingerprint != "f3" {
+		t.Fatalf("GetCertChangesSince() = %v, %v, want newest first", all, err)
+	}
+
+	if err := s.PruneCheckHistory(now.Add(-time.Hour)); err != nil {
+		t.Fatalf("PruneCheckHistory() error = %v", err)
+	}
+	if all, _ := s.GetCertChangesSince(time.Time{}); len(all) != 1 {
+		t.Errorf("PruneCheckHistory() left %d changes, want 1", len(all))
+	}
+}
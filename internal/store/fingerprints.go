@@ -0,0 +1,51 @@
+package store
+
+import "time"
+
+// SeenFingerprint records when a server presented a certificate
+type SeenFingerprint struct {
+	ServerKey
+	Fingerprint string
+	FirstSeen   time.Time
+	LastSeen    time.Time
+}
+
+// RecordFingerprint records that a server presented a certificate with the
+// given fingerprint at t
+func (s *Store) RecordFingerprint(key ServerKey, fingerprint string, t time.Time) error {
+	query := `
+		INSERT INTO seen_fingerprints (entity_id, base_uri, fingerprint, first_seen, last_seen)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(entity_id, base_uri, fingerprint) DO UPDATE SET
+			last_seen = MAX(last_seen, excluded.last_seen)
+	`
+	_, err := s.db.Exec(query, key.EntityID, key.BaseURI, fingerprint, t, t)
+	return err
+}
+
+// GetSeenFingerprints returns when each server has presented each of its
+// certificates, by server and fingerprint
+func (s *Store) GetSeenFingerprints() (map[ServerKey]map[string]*SeenFingerprint, error) {
+	query := `
+		SELECT entity_id, base_uri, fingerprint, first_seen, last_seen
+		FROM seen_fingerprints
+	`
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[ServerKey]map[string]*SeenFingerprint)
+	for rows.Next() {
+		f := &SeenFingerprint{}
+		if err := rows.Scan(&f.EntityID, &f.BaseURI, &f.Fingerprint, &f.FirstSeen, &f.LastSeen); err != nil {
+			return nil, err
+		}
+		if seen[f.ServerKey] == nil {
+			seen[f.ServerKey] = make(map[string]*SeenFingerprint)
+		}
+		seen[f.ServerKey][f.Fingerprint] = f
+	}
+	return seen, rows.Err()
+}
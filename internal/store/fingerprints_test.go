@@ -0,0 +1,42 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSeenFingerprints(t *testing.T) {
+	s, err := New(tempDBPath(t))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+
+	now := time.Now().Truncate(time.Second)
+	key := ServerKey{EntityID: "https://a.com", BaseURI: "https://api.a.com/"}
+	for _, r := range []struct {
+		fingerprint string
+		at          time.Time
+	}{
+		{"f1", now.Add(-48 * time.Hour)},
+		{"f1", now.Add(-24 * time.Hour)},
+		{"f2", now},
+		{"f1", now.Add(-36 * time.Hour)}, // out of order, doesn't move last seen back
+	} {
+		if err := s.RecordFingerprint(key, r.fingerprint, r.at); err != nil {
+			t.Fatalf("RecordFingerprint() error = %v", err)
+		}
+	}
+
+	seen, err := s.GetSeenFingerprints()
+	if err != nil {
+		t.Fatalf("GetSeenFingerprints() error = %v", err)
+	}
+	f1 := seen[key]["f1"]
+	if f1 == nil || !f1.FirstSeen.Equal(now.Add(-48*time.Hour)) || !f1.LastSeen.Equal(now.Add(-24*time.Hour)) {
+		t.Errorf("f1 = %+v", f1)
+	}
+	if f2 := seen[key]["f2"]; f2 == nil || !f2.LastSeen.Equal(now) {
+		t.Errorf("f2 = %+v", f2)
+	}
+}
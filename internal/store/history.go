@@ -0,0 +1,158 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// CheckRecord is one entry in a server's check history
+type CheckRecord struct {
+	ServerKey
+	CheckedAt         time.Time
+	IsHealthy         bool
+	HandshakeDuration *time.Duration
+}
+
+// AddCheckRecord appends a check result to the server's history
+func (s *Store) AddCheckRecord(record *CheckRecord) error {
+	var handshakeMs sql.NullInt64
+	if record.HandshakeDuration != nil {
+		handshakeMs = sql.NullInt64{Int64: record.HandshakeDuration.Milliseconds(), Valid: true}
+	}
+	query := `
+		INSERT INTO check_history (entity_id, base_uri, checked_at, is_healthy, handshake_ms)
+		VALUES (?, ?, ?, ?, ?)
+	`
+	_, err := s.db.Exec(query, record.EntityID, record.BaseURI, record.CheckedAt, record.IsHealthy, handshakeMs)
+	return err
+}
+
+// GetRecentChecks returns the latest checks of a server, newest first
+func (s *Store) GetRecentChecks(entityID, baseURI string, limit int) ([]*CheckRecord, error) {
+	query := `
+		SELECT entity_id, base_uri, checked_at, is_healthy, handshake_ms
+		FROM check_history
+		WHERE entity_id = ? AND base_uri = ?
+		ORDER BY checked_at DESC
+		LIMIT ?
+	`
+	rows, err := s.db.Query(query, entityID, baseURI, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCheckRecords(rows)
+}
+
+// GetChecksSince returns all checks made at or after since, oldest first
+func (s *Store) GetChecksSince(since time.Time) ([]*CheckRecord, error) {
+	query := `
+		SELECT entity_id, base_uri, checked_at, is_healthy, handshake_ms
+		FROM check_history
+		WHERE checked_at >= ?
+		ORDER BY entity_id, base_uri, checked_at
+	`
+	rows, err := s.db.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCheckRecords(rows)
+}
+
+// GetLastChecksBefore returns the latest check made before t for each server
+func (s *Store) GetLastChecksBefore(t time.Time) ([]*CheckRecord, error) {
+	query := `
+		SELECT h.entity_id, h.base_uri, h.checked_at, h.is_healthy, h.handshake_ms
+		FROM check_history h
+		WHERE h.checked_at = (
+			SELECT MAX(checked_at) FROM check_history
+			WHERE entity_id = h.entity_id AND base_uri = h.base_uri AND checked_at < ?
+		)
+		ORDER BY h.entity_id, h.base_uri
+	`
+	rows, err := s.db.Query(query, t)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanCheckRecords(rows)
+}
+
+func scanCheckRecords(rows *sql.Rows) ([]*CheckRecord, error) {
+	var records []*CheckRecord
+	for rows.Next() {
+		record := &CheckRecord{}
+		var handshakeMs sql.NullInt64
+		if err := rows.Scan(&record.EntityID, &record.BaseURI, &record.CheckedAt, &record.IsHealthy, &handshakeMs); err != nil {
+			return nil, err
+		}
+		if handshakeMs.Valid {
+			d := time.Duration(handshakeMs.Int64) * time.Millisecond
+			record.HandshakeDuration = &d
+		}
+		records = append(records, record)
+	}
+	return records, rows.Err()
+}
+
+// PruneCheckHistory removes history entries, server changes and
+// certificate changes older than before
+func (s *Store) PruneCheckHistory(before time.Time) error {
+	if _, err := s.db.Exec(`DELETE FROM check_history WHERE checked_at < ?`, before); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`DELETE FROM cert_changes WHERE changed_at < ?`, before); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM server_changes WHERE changed_at < ?`, before)
+	return err
+}
+
+// Kinds of server changes
+const (
+	ServerAdded   = "added"
+	ServerRemoved = "removed"
+)
+
+// ServerChange records a server being added to or removed from metadata
+type ServerChange struct {
+	ServerKey
+	Change    string
+	ChangedAt time.Time
+}
+
+// AddServerChange records a server being added to or removed from metadata
+func (s *Store) AddServerChange(change *ServerChange) error {
+	query := `
+		INSERT INTO server_changes (entity_id, base_uri, change, changed_at)
+		VALUES (?, ?, ?, ?)
+	`
+	_, err := s.db.Exec(query, change.EntityID, change.BaseURI, change.Change, change.ChangedAt)
+	return err
+}
+
+// GetServerChangesSince returns server changes at or after since, oldest first
+func (s *Store) GetServerChangesSince(since time.Time) ([]*ServerChange, error) {
+	query := `
+		SELECT entity_id, base_uri, change, changed_at
+		FROM server_changes
+		WHERE changed_at >= ?
+		ORDER BY changed_at, entity_id, base_uri
+	`
+	rows, err := s.db.Query(query, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var changes []*ServerChange
+	for rows.Next() {
+		change := &ServerChange{}
+		if err := rows.Scan(&change.EntityID, &change.BaseURI, &change.Change, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		changes = append(changes, change)
+	}
+	return changes, rows.Err()
+}
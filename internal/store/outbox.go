@@ -0,0 +1,78 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// OutboxEntry is a pending notification delivery
+type OutboxEntry struct {
+	ID          int64
+	Channel     string
+	Payload     []byte
+	Attempts    int
+	NextAttempt time.Time
+	LastError   string
+	CreatedAt   time.Time
+}
+
+// EnqueueOutbox stores a payload for delivery on the named channel.
+// The entry is due immediately.
+func (s *Store) EnqueueOutbox(channel string, payload []byte) error {
+	now := time.Now()
+	query := `
+		INSERT INTO outbox (channel, payload, attempts, next_attempt, created_at)
+		VALUES (?, ?, 0, ?, ?)
+	`
+	_, err := s.db.Exec(query, channel, payload, now, now)
+	return err
+}
+
+// GetDueOutbox returns entries whose next attempt is at or before now,
+// oldest first
+func (s *Store) GetDueOutbox(now time.Time, limit int) ([]*OutboxEntry, error) {
+	query := `
+		SELECT id, channel, payload, attempts, next_attempt, last_error, created_at
+		FROM outbox
+		WHERE next_attempt <= ?
+		ORDER BY next_attempt ASC, id ASC
+		LIMIT ?
+	`
+	rows, err := s.db.Query(query, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []*OutboxEntry
+	for rows.Next() {
+		entry := &OutboxEntry{}
+		var lastError sql.NullString
+		if err := rows.Scan(
+			&entry.ID, &entry.Channel, &entry.Payload, &entry.Attempts,
+			&entry.NextAttempt, &lastError, &entry.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		entry.LastError = lastError.String
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// RescheduleOutbox records a failed delivery attempt and sets the time of the next attempt
+func (s *Store) RescheduleOutbox(id int64, nextAttempt time.Time, lastError string) error {
+	query := `
+		UPDATE outbox
+		SET attempts = attempts + 1, next_attempt = ?, last_error = ?
+		WHERE id = ?
+	`
+	_, err := s.db.Exec(query, nextAttempt, lastError, id)
+	return err
+}
+
+// DeleteOutbox removes an entry, after successful delivery or when giving up
+func (s *Store) DeleteOutbox(id int64) error {
+	_, err := s.db.Exec(`DELETE FROM outbox WHERE id = ?`, id)
+	return err
+}
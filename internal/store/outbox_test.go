@@ -0,0 +1,81 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxEnqueueAndDeliver(t *testing.T) {
+	s, err := New(tempDBPath(t))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+
+	if err := s.EnqueueOutbox("ops", []byte(`{"type":"server.unhealthy"}`)); err != nil {
+		t.Fatalf("EnqueueOutbox() error = %v", err)
+	}
+
+	entries, err := s.GetDueOutbox(time.Now(), 10)
+	if err != nil {
+		t.Fatalf("GetDueOutbox() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("GetDueOutbox() returned %d entries, want 1", len(entries))
+	}
+	if entries[0].Channel != "ops" {
+		t.Errorf("Channel = %v, want ops", entries[0].Channel)
+	}
+	if string(entries[0].Payload) != `{"type":"server.unhealthy"}` {
+		t.Errorf("Payload = %s", entries[0].Payload)
+	}
+
+	if err := s.DeleteOutbox(entries[0].ID); err != nil {
+		t.Fatalf("DeleteOutbox() error = %v", err)
+	}
+
+	entries, err = s.GetDueOutbox(time.Now(), 10)
+	if err != nil {
+		t.Fatalf("GetDueOutbox() error = %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("GetDueOutbox() returned %d entries after delete, want 0", len(entries))
+	}
+}
+
+func TestOutboxReschedule(t *testing.T) {
+	s, err := New(tempDBPath(t))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+
+	s.EnqueueOutbox("ops", []byte("{}"))
+	entries, _ := s.GetDueOutbox(time.Now(), 10)
+	if len(entries) != 1 {
+		t.Fatalf("GetDueOutbox() returned %d entries, want 1", len(entries))
+	}
+
+	next := time.Now().Add(10 * time.Minute)
+	if err := s.RescheduleOutbox(entries[0].ID, next, "connection refused"); err != nil {
+		t.Fatalf("RescheduleOutbox() error = %v", err)
+	}
+
+	// Not due yet
+	entries, _ = s.GetDueOutbox(time.Now(), 10)
+	if len(entries) != 0 {
+		t.Errorf("GetDueOutbox() returned %d entries before next attempt, want 0", len(entries))
+	}
+
+	// Due after next attempt time
+	entries, _ = s.GetDueOutbox(next.Add(time.Second), 10)
+	if len(entries) != 1 {
+		t.Fatalf("GetDueOutbox() returned %d entries after next attempt, want 1", len(entries))
+	}
+	if entries[0].Attempts != 1 {
+		t.Errorf("Attempts = %d, want 1", entries[0].Attempts)
+	}
+	if entries[0].LastError != "connection refused" {
+		t.Errorf("LastError = %v, want connection refused", entries[0].LastError)
+	}
+}
@@ -4,6 +4,8 @@ package store
 import (
 	"database/sql"
 	"fmt"
+	"net/url"
+	"strings"
 	"time"
 
 	_ "modernc.org/sqlite"
@@ -24,6 +26,15 @@ type ServerStatus struct {
 	CertExpires     *time.Time
 	CertCN          string
 	CertFingerprint string
+
+	// Details of the latest check, used for statistics
+	TLSVersion    string
+	CipherSuite   string
+	KeyType       string
+	KeyBits       int
+	CertIssuer    string
+	CertNotBefore *time.Time
+	FailureReason string
 }
 
 // Store provides persistence for server health status
@@ -46,6 +57,25 @@ func New(dbPath string) (*Store, error) {
 	return &Store{db: db}, nil
 }
 
+// OpenReadOnly opens an existing database for reading only, without
+// touching the schema, so that it can be read while the service is
+// writing to it
+func OpenReadOnly(dbPath string) (*Store, error) {
+	uri := url.URL{Scheme: "file", Path: dbPath, RawQuery: "mode=ro"}
+	db, err := sql.Open("sqlite", uri.String())
+	if err != nil {
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	// sql.Open doesn't connect, make sure the file can be opened
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("opening database: %w", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
 // Close closes the database connection
 func (s *Store) Close() error {
 	return s.db.Close()
@@ -62,71 +92,211 @@ func initSchema(db *sql.DB) error {
 			cert_expires TIMESTAMP,
 			cert_cn TEXT,
 			cert_fingerprint TEXT,
+			tls_version TEXT,
+			cipher_suite TEXT,
+			key_type TEXT,
+			key_bits INTEGER,
+			cert_issuer TEXT,
+			cert_not_before TIMESTAMP,
+			failure_reason TEXT,
 			PRIMARY KEY (entity_id, base_uri)
 		);
 
 		CREATE INDEX IF NOT EXISTS idx_last_checked ON server_status(last_checked);
+
+		CREATE TABLE IF NOT EXISTS outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			channel TEXT NOT NULL,
+			payload BLOB NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			next_attempt TIMESTAMP NOT NULL,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_outbox_next_attempt ON outbox(next_attempt);
+
+		CREATE TABLE IF NOT EXISTS check_history (
+			entity_id TEXT NOT NULL,
+			base_uri TEXT NOT NULL,
+			checked_at TIMESTAMP NOT NULL,
+			is_healthy BOOLEAN NOT NULL,
+			handshake_ms INTEGER
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_check_history_server ON check_history(entity_id, base_uri, checked_at);
+		CREATE INDEX IF NOT EXISTS idx_check_history_checked_at ON check_history(checked_at);
+
+		CREATE TABLE IF NOT EXISTS server_changes (
+			entity_id TEXT NOT NULL,
+			base_uri TEXT NOT NULL,
+			change TEXT NOT NULL,
+			changed_at TIMESTAMP NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_server_changes_changed_at ON server_changes(changed_at);
+
+		CREATE TABLE IF NOT EXISTS cert_changes (
+			entity_id TEXT NOT NULL,
+			base_uri TEXT NOT NULL,
+			changed_at TIMESTAMP NOT NULL,
+			old_fingerprint TEXT NOT NULL,
+			new_fingerprint TEXT NOT NULL,
+			old_cn TEXT,
+			new_cn TEXT,
+			old_expires TIMESTAMP,
+			new_expires TIMESTAMP,
+			pinned BOOLEAN NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_cert_changes_changed_at ON cert_changes(changed_at);
+
+		CREATE TABLE IF NOT EXISTS seen_fingerprints (
+			entity_id TEXT NOT NULL,
+			base_uri TEXT NOT NULL,
+			fingerprint TEXT NOT NULL,
+			first_seen TIMESTAMP NOT NULL,
+			last_seen TIMESTAMP NOT NULL,
+			PRIMARY KEY (entity_id, base_uri, fingerprint)
+		);
+
+		CREATE TABLE IF NOT EXISTS alert_state (
+			rule TEXT NOT NULL,
+			entity_id TEXT NOT NULL,
+			base_uri TEXT NOT NULL,
+			since TIMESTAMP NOT NULL,
+			message TEXT,
+			PRIMARY KEY (rule, entity_id, base_uri)
+		);
+
+		CREATE TABLE IF NOT EXISTS metadata_versions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			hash TEXT NOT NULL,
+			archived_at TIMESTAMP NOT NULL,
+			content BLOB NOT NULL
+		);
 	`
-	_, err := db.Exec(schema)
-	return err
+	if _, err := db.Exec(schema); err != nil {
+		return err
+	}
+	return addMissingColumns(db, "server_status", []string{
+		"tls_version TEXT",
+		"cipher_suite TEXT",
+		"key_type TEXT",
+		"key_bits INTEGER",
+		"cert_issuer TEXT",
+		"cert_not_before TIMESTAMP",
+		"failure_reason TEXT",
+	})
+}
+
+// addMissingColumns adds columns introduced after a table was first
+// created. Each column is given as "name type".
+func addMissingColumns(db *sql.DB, table string, columns []string) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info(?)`, table)
+	if err != nil {
+		return err
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, column := range columns {
+		name := strings.Fields(column)[0]
+		if existing[name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, column)); err != nil {
+			return fmt.Errorf("adding column %s.%s: %w", table, name, err)
+		}
+	}
+	return nil
 }
 
+// statusColumns are the columns read by scanStatus
+const statusColumns = `
+	entity_id, base_uri, last_checked, is_healthy, error_message,
+	cert_expires, cert_cn, cert_fingerprint, tls_version, cipher_suite,
+	key_type, key_bits, cert_issuer, cert_not_before, failure_reason
+`
+
 // SaveStatus saves or updates a server's health status
 func (s *Store) SaveStatus(status *ServerStatus) error {
 	query := `
-		INSERT INTO server_status (
-			entity_id, base_uri, last_checked, is_healthy, error_message,
-			cert_expires, cert_cn, cert_fingerprint
-		) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		INSERT INTO server_status (` + statusColumns + `)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
 		ON CONFLICT(entity_id, base_uri) DO UPDATE SET
 			last_checked = excluded.last_checked,
 			is_healthy = excluded.is_healthy,
 			error_message = excluded.error_message,
 			cert_expires = excluded.cert_expires,
 			cert_cn = excluded.cert_cn,
-			cert_fingerprint = excluded.cert_fingerprint
+			cert_fingerprint = excluded.cert_fingerprint,
+			tls_version = excluded.tls_version,
+			cipher_suite = excluded.cipher_suite,
+			key_type = excluded.key_type,
+			key_bits = excluded.key_bits,
+			cert_issuer = excluded.cert_issuer,
+			cert_not_before = excluded.cert_not_before,
+			failure_reason = excluded.failure_reason
 	`
 	_, err := s.db.Exec(query,
 		status.EntityID, status.BaseURI, status.LastChecked, status.IsHealthy,
 		status.ErrorMessage, status.CertExpires, status.CertCN, status.CertFingerprint,
+		status.TLSVersion, status.CipherSuite, status.KeyType, status.KeyBits,
+		status.CertIssuer, status.CertNotBefore, status.FailureReason,
 	)
 	return err
 }
 
-// GetStatus retrieves a server's health status
-func (s *Store) GetStatus(entityID, baseURI string) (*ServerStatus, error) {
-	query := `
-		SELECT entity_id, base_uri, last_checked, is_healthy, error_message,
-		       cert_expires, cert_cn, cert_fingerprint
-		FROM server_status
-		WHERE entity_id = ? AND base_uri = ?
-	`
+// scanStatus reads a row of statusColumns
+func scanStatus(row interface{ Scan(...any) error }) (*ServerStatus, error) {
 	status := &ServerStatus{}
-	var errorMessage, certCN, certFingerprint sql.NullString
-	err := s.db.QueryRow(query, entityID, baseURI).Scan(
+	var errorMessage, certCN, certFingerprint, tlsVersion, cipherSuite, keyType, certIssuer, failureReason sql.NullString
+	var keyBits sql.NullInt64
+	if err := row.Scan(
 		&status.EntityID, &status.BaseURI, &status.LastChecked, &status.IsHealthy,
 		&errorMessage, &status.CertExpires, &certCN, &certFingerprint,
-	)
-	if err == sql.ErrNoRows {
-		return nil, nil
-	}
-	if err != nil {
+		&tlsVersion, &cipherSuite, &keyType, &keyBits,
+		&certIssuer, &status.CertNotBefore, &failureReason,
+	); err != nil {
 		return nil, err
 	}
 	status.ErrorMessage = errorMessage.String
 	status.CertCN = certCN.String
 	status.CertFingerprint = certFingerprint.String
+	status.TLSVersion = tlsVersion.String
+	status.CipherSuite = cipherSuite.String
+	status.KeyType = keyType.String
+	status.KeyBits = int(keyBits.Int64)
+	status.CertIssuer = certIssuer.String
+	status.FailureReason = failureReason.String
 	return status, nil
 }
 
+// GetStatus retrieves a server's health status
+func (s *Store) GetStatus(entityID, baseURI string) (*ServerStatus, error) {
+	query := `SELECT ` + statusColumns + ` FROM server_status WHERE entity_id = ? AND base_uri = ?`
+	status, err := scanStatus(s.db.QueryRow(query, entityID, baseURI))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return status, err
+}
+
 // GetAllStatuses retrieves all server statuses
 func (s *Store) GetAllStatuses() ([]*ServerStatus, error) {
-	query := `
-		SELECT entity_id, base_uri, last_checked, is_healthy, error_message,
-		       cert_expires, cert_cn, cert_fingerprint
-		FROM server_status
-		ORDER BY entity_id, base_uri
-	`
+	query := `SELECT ` + statusColumns + ` FROM server_status ORDER BY entity_id, base_uri`
 	rows, err := s.db.Query(query)
 	if err != nil {
 		return nil, err
@@ -135,17 +305,10 @@ func (s *Store) GetAllStatuses() ([]*ServerStatus, error) {
 
 	var statuses []*ServerStatus
 	for rows.Next() {
-		status := &ServerStatus{}
-		var errorMessage, certCN, certFingerprint sql.NullString
-		if err := rows.Scan(
-			&status.EntityID, &status.BaseURI, &status.LastChecked, &status.IsHealthy,
-			&errorMessage, &status.CertExpires, &certCN, &certFingerprint,
-		); err != nil {
+		status, err := scanStatus(rows)
+		if err != nil {
 			return nil, err
 		}
-		status.ErrorMessage = errorMessage.String
-		status.CertCN = certCN.String
-		status.CertFingerprint = certFingerprint.String
 		statuses = append(statuses, status)
 	}
 	return statuses, rows.Err()
@@ -26,6 +26,64 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestOpenReadOnly(t *testing.T) {
+	dbPath := tempDBPath(t)
+	s, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := s.EnsureServerExists("https://example.com", "https://api.example.com"); err != nil {
+		t.Fatalf("EnsureServerExists() error = %v", err)
+	}
+	s.Close()
+
+	ro, err := OpenReadOnly(dbPath)
+	if err != nil {
+		t.Fatalf("OpenReadOnly() error = %v", err)
+	}
+	defer ro.Close()
+
+	statuses, err := ro.GetAllStatuses()
+	if err != nil {
+		t.Fatalf("GetAllStatuses() error = %v", err)
+	}
+	if len(statuses) != 1 {
+		t.Errorf("GetAllStatuses() returned %d statuses, want 1", len(statuses))
+	}
+	if err := ro.EnsureServerExists("https://example.com", "https://other.example.com"); err == nil {
+		t.Error("EnsureServerExists() on read-only store succeeded, want error")
+	}
+}
+
+func TestOpenReadOnlySpecialCharacters(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "status #1 100%.db")
+	s, err := New(dbPath)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	s.Close()
+
+	ro, err := OpenReadOnly(dbPath)
+	if err != nil {
+		t.Fatalf("OpenReadOnly() error = %v", err)
+	}
+	defer ro.Close()
+	if err := ro.EnsureServerExists("https://example.com", "https://api.example.com"); err == nil {
+		t.Error("EnsureServerExists() on read-only store succeeded, want error")
+	}
+}
+
+func TestOpenReadOnlyMissing(t *testing.T) {
+	dbPath := tempDBPath(t)
+	if s, err := OpenReadOnly(dbPath); err == nil {
+		s.Close()
+		t.Fatal("OpenReadOnly() of missing database succeeded, want error")
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Error("OpenReadOnly() created the database file")
+	}
+}
+
 func TestSaveAndGetStatus(t *testing.T) {
 	s, err := New(tempDBPath(t))
 	if err != nil {
@@ -85,6 +143,42 @@ func TestSaveAndGetStatus(t *testing.T) {
 	}
 }
 
+func TestSaveAndGetStatusDetails(t *testing.T) {
+	s, err := New(tempDBPath(t))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+
+	notBefore := time.Now().Add(-30 * 24 * time.Hour).Truncate(time.Second)
+	status := &ServerStatus{
+		ServerKey:     ServerKey{EntityID: "https://example.com", BaseURI: "https://api.example.com"},
+		TLSVersion:    "TLS 1.3",
+		CipherSuite:   "TLS_AES_128_GCM_SHA256",
+		KeyType:       "ECDSA",
+		KeyBits:       256,
+		CertIssuer:    "Example CA",
+		CertNotBefore: &notBefore,
+		FailureReason: "pin",
+	}
+	if err := s.SaveStatus(status); err != nil {
+		t.Fatalf("SaveStatus() error = %v", err)
+	}
+
+	got, err := s.GetStatus(status.EntityID, status.BaseURI)
+	if err != nil || got == nil {
+		t.Fatalf("GetStatus() = %v, %v", got, err)
+	}
+	if got.TLSVersion != status.TLSVersion || got.CipherSuite != status.CipherSuite ||
+		got.KeyType != status.KeyType || got.KeyBits != status.KeyBits ||
+		got.CertIssuer != status.CertIssuer || got.FailureReason != status.FailureReason {
+		t.Errorf("GetStatus() = %+v, want %+v", got, status)
+	}
+	if got.CertNotBefore == nil || !got.CertNotBefore.Equal(notBefore) {
+		t.Errorf("CertNotBefore = %v, want %v", got.CertNotBefore, notBefore)
+	}
+}
+
 func TestGetStatusNotFound(t *testing.T) {
 	s, err := New(tempDBPath(t))
 	if err != nil {
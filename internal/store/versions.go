@@ -0,0 +1,104 @@
+package store
+
+import (
+	"database/sql"
+	"time"
+)
+
+// MetadataVersion is an archived version of the federation metadata.
+// Content is only set when a single version is read.
+type MetadataVersion struct {
+	ID         int64
+	Hash       string
+	ArchivedAt time.Time
+	Content    []byte
+}
+
+// AddMetadataVersion archives a metadata version unless it has the same hash
+// as the latest archived version. Returns whether the version was added.
+func (s *Store) AddMetadataVersion(hash string, content []byte, archivedAt time.Time) (bool, error) {
+	latest, err := s.GetLatestMetadataVersion()
+	if err != nil {
+		return false, err
+	}
+	if latest != nil && latest.Hash == hash {
+		return false, nil
+	}
+
+	_, err = s.db.Exec(`INSERT INTO metadata_versions (hash, archived_at, content) VALUES (?, ?, ?)`,
+		hash, archivedAt, content)
+	return err == nil, err
+}
+
+// GetMetadataVersions returns the most recently archived versions archived
+// before the version with ID before, or the most recent of all with before
+// 0, newest first and without content. A negative limit returns them all.
+func (s *Store) GetMetadataVersions(before int64, limit int) ([]*MetadataVersion, error) {
+	query := `
+		SELECT id, hash, archived_at
+		FROM metadata_versions
+		WHERE ? = 0 OR id < ?
+		ORDER BY id DESC
+		LIMIT ?
+	`
+	rows, err := s.db.Query(query, before, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []*MetadataVersion
+	for rows.Next() {
+		v := &MetadataVersion{}
+		if err := rows.Scan(&v.ID, &v.Hash, &v.ArchivedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+	return versions, rows.Err()
+}
+
+// GetMetadataVersion returns an archived version with content, or nil if
+// there is no version with the given ID
+func (s *Store) GetMetadataVersion(id int64) (*MetadataVersion, error) {
+	return s.getMetadataVersion(`
+		SELECT id, hash, archived_at, content
+		FROM metadata_versions
+		WHERE id = ?
+	`, id)
+}
+
+// GetPreviousMetadataVersion returns the version archived before the one
+// with the given ID, with content, or nil if there is none
+func (s *Store) GetPreviousMetadataVersion(id int64) (*MetadataVersion, error) {
+	return s.getMetadataVersion(`
+		SELECT id, hash, archived_at, content
+		FROM metadata_versions
+		WHERE id < ?
+		ORDER BY id DESC
+		LIMIT 1
+	`, id)
+}
+
+// GetLatestMetadataVersion returns the most recently archived version with
+// content, or nil if no version has been archived
+func (s *Store) GetLatestMetadataVersion() (*MetadataVersion, error) {
+	return s.getMetadataVersion(`
+		SELECT id, hash, archived_at, content
+		FROM metadata_versions
+		ORDER BY id DESC
+		LIMIT 1
+	`)
+}
+
+func (s *Store) getMetadataVersion(query string, args ...any) (*MetadataVersion, error) {
+	v := &MetadataVersion{}
+	err := s.db.QueryRow(query, args...).Scan(&v.ID, &v.Hash, &v.ArchivedAt, &v.Content)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,78 @@
+package store
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMetadataVersions(t *testing.T) {
+	s, err := New(tempDBPath(t))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer s.Close()
+
+	latest, err := s.GetLatestMetadataVersion()
+	if err != nil || latest != nil {
+		t.Fatalf("GetLatestMetadataVersion() = %v, %v, want nil", latest, err)
+	}
+
+	now := time.Now()
+	versions := []struct {
+		hash    string
+		content string
+		added   bool
+	}{
+		{"h1", "one", true},
+		{"h1", "one", false}, // same as latest, skipped
+		{"h2", "two", true},
+		{"h1", "one", true}, // reverted, archived again
+	}
+	for i, v := range versions {
+		added, err := s.AddMetadataVersion(v.hash, []byte(v.content), now.Add(time.Duration(i)*time.Minute))
+		if err != nil {
+			t.Fatalf("AddMetadataVersion() error = %v", err)
+		}
+		if added != v.added {
+			t.Errorf("AddMetadataVersion(%s) added = %v, want %v", v.hash, added, v.added)
+		}
+	}
+
+	list, err := s.GetMetadataVersions(0, 10)
+	if err != nil {
+		t.Fatalf("GetMetadataVersions() error = %v", err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("GetMetadataVersions() returned %d versions, want 3", len(list))
+	}
+	if list[0].Hash != "h1" || list[1].Hash != "h2" || list[0].Content != nil {
+		t.Errorf("unexpected versions %+v %+v", list[0], list[1])
+	}
+
+	older, err := s.GetMetadataVersions(list[0].ID, -1)
+	if err != nil {
+		t.Fatalf("GetMetadataVersions() error = %v", err)
+	}
+	if len(older) != 2 || older[0].ID != list[1].ID {
+		t.Errorf("GetMetadataVersions(before) = %+v", older)
+	}
+
+	version, err := s.GetMetadataVersion(list[1].ID)
+	if err != nil || version == nil || string(version.Content) != "two" {
+		t.Fatalf("GetMetadataVersion() = %v, %v", version, err)
+	}
+
+	previous, err := s.GetPreviousMetadataVersion(list[1].ID)
+	if err != nil || previous == nil || previous.ID != list[2].ID {
+		t.Fatalf("GetPreviousMetadataVersion() = %v, %v", previous, err)
+	}
+	previous, err = s.GetPreviousMetadataVersion(list[2].ID)
+	if err != nil || previous != nil {
+		t.Errorf("GetPreviousMetadataVersion() of first version = %v, %v", previous, err)
+	}
+
+	missing, err := s.GetMetadataVersion(12345)
+	if err != nil || missing != nil {
+		t.Errorf("GetMetadataVersion(missing) = %v, %v", missing, err)
+	}
+}
@@ -0,0 +1,95 @@
+// Package uptime computes how much of the time servers were healthy, from
+// the check history.
+package uptime
+
+import (
+	"time"
+
+	"github.com/joesiltberg/matfmonitor/internal/config"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// DefaultWindow is the period uptime is shown for
+const DefaultWindow = config.UptimeWindow
+
+// Uptime is the time a server, or a group of servers, was known to be
+// healthy out of the time its state was known
+type Uptime struct {
+	Healthy time.Duration
+	Known   time.Duration
+}
+
+// Add returns the combined uptime of two servers or groups
+func (u Uptime) Add(other Uptime) Uptime {
+	return Uptime{Healthy: u.Healthy + other.Healthy, Known: u.Known + other.Known}
+}
+
+// Valid tells if the state was known for any of the time
+func (u Uptime) Valid() bool {
+	return u.Known > 0
+}
+
+// Percent is the healthy share of the known time, 0-100
+func (u Uptime) Percent() float64 {
+	if u.Known <= 0 {
+		return 0
+	}
+	return float64(u.Healthy) * 100 / float64(u.Known)
+}
+
+// Compute returns the uptime of each server between from and to. A check
+// result is assumed to hold until the server's next check. before is the
+// last check of each server before from and checks the checks from from
+// on, ordered by server and time as returned by the store.
+func Compute(before, checks []*store.CheckRecord, from, to time.Time) map[store.ServerKey]Uptime {
+	type state struct {
+		since   time.Time
+		healthy bool
+	}
+	current := make(map[store.ServerKey]state)
+	result := make(map[store.ServerKey]Uptime)
+
+	// credit adds the time from the server's current state up to t
+	credit := func(key store.ServerKey, t time.Time) {
+		s, ok := current[key]
+		if !ok || !t.After(s.since) {
+			return
+		}
+		u := result[key]
+		d := t.Sub(s.since)
+		u.Known += d
+		if s.healthy {
+			u.Healthy += d
+		}
+		result[key] = u
+	}
+
+	for _, check := range before {
+		current[check.ServerKey] = state{since: from, healthy: check.IsHealthy}
+	}
+	for _, check := range checks {
+		if check.CheckedAt.Before(from) || check.CheckedAt.After(to) {
+			continue
+		}
+		credit(check.ServerKey, check.CheckedAt)
+		current[check.ServerKey] = state{since: check.CheckedAt, healthy: check.IsHealthy}
+	}
+	for key := range current {
+		credit(key, to)
+	}
+	return result
+}
+
+// Load computes the uptime of each server in the window ending at to
+func Load(dataStore *store.Store, window time.Duration, to time.Time) (map[store.ServerKey]Uptime, error) {
+	from := to.Add(-window)
+	before, err := dataStore.GetLastChecksBefore(from)
+	if err != nil {
+		return nil, err
+	}
+	checks, err := dataStore.GetChecksSince(from)
+	if err != nil {
+		return nil, err
+	}
+	return Compute(before, checks, from, to), nil
+}
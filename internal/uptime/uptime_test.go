@@ -0,0 +1,46 @@
+package uptime
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func TestCompute(t *testing.T) {
+	to := time.Now()
+	from := to.Add(-10 * time.Hour)
+	a := store.ServerKey{EntityID: "https://a.com", BaseURI: "https://api.a.com/"}
+	b := store.ServerKey{EntityID: "https://b.com", BaseURI: "https://api.b.com/"}
+	check := func(key store.ServerKey, hoursAgo int, healthy bool) *store.CheckRecord {
+		return &store.CheckRecord{ServerKey: key, CheckedAt: to.Add(-time.Duration(hoursAgo) * time.Hour), IsHealthy: healthy}
+	}
+
+	// a was healthy before the window, failed 6 hours ago and recovered
+	// 4 hours ago. b was first checked 5 hours ago, and is unhealthy.
+	before := []*store.CheckRecord{check(a, 12, true)}
+	checks := []*store.CheckRecord{
+		check(a, 6, false),
+		check(a, 4, true),
+		check(b, 5, false),
+	}
+
+	result := Compute(before, checks, from, to)
+
+	if u := result[a]; u.Known != 10*time.Hour || u.Healthy != 8*time.Hour {
+		t.Errorf("uptime of a = %+v", u)
+	}
+	if u := result[b]; u.Known != 5*time.Hour || u.Healthy != 0 {
+		t.Errorf("uptime of b = %+v", u)
+	}
+
+	combined := result[a].Add(result[b])
+	if p := combined.Percent(); math.Abs(p-100*8.0/15.0) > 0.01 {
+		t.Errorf("combined Percent() = %v", p)
+	}
+
+	if (Uptime{}).Valid() || (Uptime{}).Percent() != 0 {
+		t.Error("empty uptime should be invalid with 0%")
+	}
+}
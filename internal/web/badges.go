@@ -0,0 +1,167 @@
+package web
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/joesiltberg/matfmonitor/internal/badge"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+	"github.com/joesiltberg/matfmonitor/internal/uptime"
+)
+
+// How long clients and proxies may cache a badge, and how long computed
+// uptimes are reused between badge and organization page requests
+const badgeMaxAge = 5 * time.Minute
+
+// Label on the left of every badge
+const badgeLabel = "MATF"
+
+var badgeColors = map[string]string{
+	"healthy":   badge.ColorHealthy,
+	"warning":   badge.ColorWarning,
+	"unhealthy": badge.ColorUnhealthy,
+	"unchecked": badge.ColorUnchecked,
+}
+
+// badgeStatus rolls up the status of a group of servers the way entities
+// are rolled up on the status page. A healthy group is shown as warning if
+// a certificate expires soon.
+type badgeStatus struct {
+	hasUnhealthy bool
+	allChecked   bool
+	expiring     bool
+	servers      int
+	uptime       uptime.Uptime
+}
+
+func newBadgeStatus() *badgeStatus {
+	return &badgeStatus{allChecked: true}
+}
+
+// add includes a server. Its certificate expires soon if within
+// expiryWarning.
+func (b *badgeStatus) add(sv *ServerView, u uptime.Uptime, expiryWarning time.Duration, now time.Time) {
+	b.servers++
+	b.uptime = b.uptime.Add(u)
+	switch sv.HealthStatus {
+	case "unchecked":
+		b.allChecked = false
+	case "unhealthy":
+		b.hasUnhealthy = true
+	}
+	if sv.CertExpires != nil && sv.CertExpires.Sub(now) <= expiryWarning {
+		b.expiring = true
+	}
+}
+
+// status is "healthy", "warning", "unhealthy" or "unchecked"
+func (b *badgeStatus) status() string {
+	status := entityHealthStatus(b.hasUnhealthy, b.allChecked)
+	if status == "healthy" && b.expiring {
+		return "warning"
+	}
+	return status
+}
+
+// text is the badge value: the status and, if known, the uptime
+func (b *badgeStatus) text() string {
+	if !b.uptime.Valid() {
+		return b.status()
+	}
+	return fmt.Sprintf("%s · %.1f%%", b.status(), b.uptime.Percent())
+}
+
+// getUptimes returns the uptime of each server, computed at most once per
+// badgeMaxAge
+func (h *Handler) getUptimes(now time.Time) (map[store.ServerKey]uptime.Uptime, error) {
+	h.uptimesMu.Lock()
+	defer h.uptimesMu.Unlock()
+	if h.uptimes != nil && now.Sub(h.uptimesAt) < badgeMaxAge {
+		return h.uptimes, nil
+	}
+	uptimes, err := uptime.Load(h.store, uptime.DefaultWindow, now)
+	if err != nil {
+		return nil, err
+	}
+	h.uptimes, h.uptimesAt = uptimes, now
+	return uptimes, nil
+}
+
+// handleBadge serves an SVG status badge for a server
+// (/badge/server.svg?entity_id=&base_uri=), an entity
+// (/badge/entity.svg?entity_id=) or an organization
+// (/badge/organization.svg?org=)
+func (h *Handler) handleBadge(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	entityID, baseURI, org := query.Get("entity_id"), query.Get("base_uri"), query.Get("org")
+
+	// The page filter matches entity IDs and base URIs as substrings, the
+	// exact match is made below
+	var filter PageFilter
+	var matches func(ev *EntityView, sv *ServerView) bool
+	switch r.URL.Path {
+	case "/badge/server.svg":
+		filter = PageFilter{EntityID: entityID, BaseURI: baseURI}
+		matches = func(ev *EntityView, sv *ServerView) bool { return ev.EntityID == entityID && sv.BaseURI == baseURI }
+	case "/badge/entity.svg":
+		filter = PageFilter{EntityID: entityID}
+		matches = func(ev *EntityView, _ *ServerView) bool { return ev.EntityID == entityID }
+	case "/badge/organization.svg":
+		filter = PageFilter{Organization: org}
+		matches = func(*EntityView, *ServerView) bool { return true }
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if filter.EntityID == "" && filter.Organization == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(h.metadataStore.GetMetadata().Entities) == 0 {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	now := time.Now()
+	uptimes, err := h.getUptimes(now)
+	if err != nil {
+		log.Printf("Error computing uptime: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	data := h.buildPageData(filter)
+	group := newBadgeStatus()
+	for i := range data.Entities {
+		ev := &data.Entities[i]
+		for j := range ev.Servers {
+			sv := &ev.Servers[j]
+			if !matches(ev, sv) {
+				continue
+			}
+			key := store.ServerKey{EntityID: ev.EntityID, BaseURI: sv.BaseURI}
+			group.add(sv, uptimes[key], h.certExpiryWarning, now)
+		}
+	}
+	if group.servers == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	svg := badge.Render(badgeLabel, group.text(), badgeColors[group.status()])
+	sum := sha256.Sum256(svg)
+	etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(badgeMaxAge.Seconds())))
+	w.Header().Set("ETag", etag)
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Write(svg)
+}
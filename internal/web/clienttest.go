@@ -0,0 +1,77 @@
+package web
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/clients"
+)
+
+// ClientTestHandler shows organizations which client in metadata the
+// client certificate they connect with matches. It must be served on a TLS
+// listener that requests client certificates.
+type ClientTestHandler struct {
+	metadataStore *fedtls.MetadataStore
+	template      *template.Template
+}
+
+// NewClientTestHandler creates a new client certificate test handler
+func NewClientTestHandler(metadataStore *fedtls.MetadataStore) (*ClientTestHandler, error) {
+	tmpl, err := template.ParseFS(templateFS, "templates/*.html")
+	if err != nil {
+		return nil, err
+	}
+	return &ClientTestHandler{
+		metadataStore: metadataStore,
+		template:      tmpl,
+	}, nil
+}
+
+// ClientTestPageData holds data for the client certificate test page
+type ClientTestPageData struct {
+	ID          *clients.Identification
+	NotBefore   string
+	NotAfter    string
+	GeneratedAt string
+}
+
+// ServeHTTP implements http.Handler
+func (h *ClientTestHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "/api/client-test" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var id *clients.Identification
+	now := time.Now()
+	if r.TLS == nil {
+		id = clients.Identify(nil, nil, now)
+	} else {
+		id = clients.Identify(h.metadataStore.GetMetadata(), r.TLS.PeerCertificates, now)
+	}
+
+	if r.URL.Path == "/api/client-test" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(id)
+		return
+	}
+
+	data := ClientTestPageData{
+		ID:          id,
+		GeneratedAt: now.Format("2006-01-02 15:04:05"),
+	}
+	if id.Presented {
+		data.NotBefore = id.NotBefore.Format("2006-01-02 15:04")
+		data.NotAfter = id.NotAfter.Format("2006-01-02 15:04")
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.ExecuteTemplate(w, "clienttest.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,162 @@
+package web
+
+import (
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sort orders of the status page
+const (
+	SortOrganization = "organization"
+	SortLastChecked  = "last-checked"
+	SortExpiry       = "expiry"
+	SortStatus       = "status"
+)
+
+// PageFilter selects and orders the servers shown on the status page. The
+// zero value shows all servers sorted by organization.
+type PageFilter struct {
+	Health        string // "healthy", "unhealthy" or "unchecked"
+	Tag           string
+	Organization  string // organization ID, or name for entities without one
+	EntityID      string // substring, case-insensitive
+	BaseURI       string // substring, case-insensitive
+	ExpiresWithin int    // days, servers whose certificate expires sooner
+	Sort          string
+}
+
+// parsePageFilter reads a filter from the status page query parameters.
+// Unknown values are ignored.
+func parsePageFilter(query url.Values) PageFilter {
+	f := PageFilter{
+		Tag:          strings.TrimSpace(query.Get("tag")),
+		Organization: strings.TrimSpace(query.Get("org")),
+		EntityID:     strings.TrimSpace(query.Get("entity")),
+		BaseURI:      strings.TrimSpace(query.Get("uri")),
+		Sort:         SortOrganization,
+	}
+	switch health := query.Get("health"); health {
+	case "healthy", "unhealthy", "unchecked":
+		f.Health = health
+	}
+	if days, err := strconv.Atoi(query.Get("expires")); err == nil && days > 0 {
+		f.ExpiresWithin = days
+	}
+	switch sortBy := query.Get("sort"); sortBy {
+	case SortLastChecked, SortExpiry, SortStatus:
+		f.Sort = sortBy
+	}
+	return f
+}
+
+// Active tells if the filter hides any servers
+func (f PageFilter) Active() bool {
+	return f.Health != "" || f.Tag != "" || f.Organization != "" || f.EntityID != "" ||
+		f.BaseURI != "" || f.ExpiresWithin > 0
+}
+
+// matchesEntity tells if servers of the entity can be shown
+func (f PageFilter) matchesEntity(ev *EntityView) bool {
+	if f.Organization != "" && ev.OrganizationKey != f.Organization {
+		return false
+	}
+	return f.EntityID == "" || strings.Contains(strings.ToLower(ev.EntityID), strings.ToLower(f.EntityID))
+}
+
+// matchesServer tells if the server is shown
+func (f PageFilter) matchesServer(sv *ServerView, now time.Time) bool {
+	if f.Health != "" && sv.HealthStatus != f.Health {
+		return false
+	}
+	if f.Tag != "" && !hasTag(sv.Tags, f.Tag) {
+		return false
+	}
+	if f.BaseURI != "" && !strings.Contains(strings.ToLower(sv.BaseURI), strings.ToLower(f.BaseURI)) {
+		return false
+	}
+	if f.ExpiresWithin > 0 {
+		if sv.CertExpires == nil || sv.CertExpires.Sub(now) > time.Duration(f.ExpiresWithin)*24*time.Hour {
+			return false
+		}
+	}
+	return true
+}
+
+// organizationKey identifies an organization in the org parameter, the ID
+// if there is one, otherwise the name
+func organizationKey(id, name string) string {
+	if id != "" {
+		return id
+	}
+	return name
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// statusRank orders health statuses with the problems first
+func statusRank(status string) int {
+	switch status {
+	case "unhealthy":
+		return 0
+	case "unchecked":
+		return 1
+	}
+	return 2
+}
+
+// serverLess orders servers by the sort order. Servers without a value
+// sort first for last checked, since they are the most out of date, and
+// last for expiry.
+func serverLess(sortBy string, a, b *ServerView) bool {
+	switch sortBy {
+	case SortLastChecked:
+		if a.LastChecked == nil || b.LastChecked == nil {
+			return a.LastChecked == nil && b.LastChecked != nil
+		}
+		return a.LastChecked.Before(*b.LastChecked)
+	case SortExpiry:
+		if a.CertExpires == nil || b.CertExpires == nil {
+			return a.CertExpires != nil && b.CertExpires == nil
+		}
+		return a.CertExpires.Before(*b.CertExpires)
+	case SortStatus:
+		return statusRank(a.HealthStatus) < statusRank(b.HealthStatus)
+	}
+	return false
+}
+
+// sortEntities orders the servers of each entity, then the entities by
+// their first server, falling back to organization name and entity ID
+func sortEntities(entities []EntityView, sortBy string) {
+	for i := range entities {
+		servers := entities[i].Servers
+		sort.SliceStable(servers, func(a, b int) bool {
+			return serverLess(sortBy, &servers[a], &servers[b])
+		})
+	}
+	sort.SliceStable(entities, func(i, j int) bool {
+		a, b := &entities[i], &entities[j]
+		if sortBy != SortOrganization && len(a.Servers) > 0 && len(b.Servers) > 0 {
+			if serverLess(sortBy, &a.Servers[0], &b.Servers[0]) {
+				return true
+			}
+			if serverLess(sortBy, &b.Servers[0], &a.Servers[0]) {
+				return false
+			}
+		}
+		if a.Organization != b.Organization {
+			return a.Organization < b.Organization
+		}
+		return a.EntityID < b.EntityID
+	})
+}
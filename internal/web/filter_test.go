@@ -0,0 +1,171 @@
+package web
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParsePageFilter(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  PageFilter
+	}{
+		{
+			name:  "empty",
+			query: "",
+			want:  PageFilter{Sort: SortOrganization},
+		},
+		{
+			name:  "all parameters",
+			query: "health=unhealthy&tag=+sandbox+&org=org-1&entity=example&uri=api&expires=30&sort=expiry",
+			want: PageFilter{
+				Health:        "unhealthy",
+				Tag:           "sandbox",
+				Organization:  "org-1",
+				EntityID:      "example",
+				BaseURI:       "api",
+				ExpiresWithin: 30,
+				Sort:          SortExpiry,
+			},
+		},
+		{
+			name:  "unknown health and sort",
+			query: "health=broken&sort=random",
+			want:  PageFilter{Sort: SortOrganization},
+		},
+		{
+			name:  "expires not a number",
+			query: "expires=soon",
+			want:  PageFilter{Sort: SortOrganization},
+		},
+		{
+			name:  "expires not positive",
+			query: "expires=-5",
+			want:  PageFilter{Sort: SortOrganization},
+		},
+		{
+			name:  "status sort",
+			query: "health=unchecked&sort=status",
+			want:  PageFilter{Health: "unchecked", Sort: SortStatus},
+		},
+		{
+			name:  "last checked sort",
+			query: "sort=last-checked",
+			want:  PageFilter{Sort: SortLastChecked},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatalf("ParseQuery() error = %v", err)
+			}
+			if got := parsePageFilter(query); got != tt.want {
+				t.Errorf("parsePageFilter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortEntities(t *testing.T) {
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	at := func(hours int) *time.Time {
+		t := base.Add(time.Duration(hours) * time.Hour)
+		return &t
+	}
+
+	// entities returns a fresh copy, since sortEntities sorts in place.
+	// Entities without shown servers never get sorted.
+	entities := func() []EntityView {
+		return []EntityView{
+			{EntityID: "https://a.example.com", Organization: "B Org", Servers: []ServerView{
+				{BaseURI: "https://a1/", HealthStatus: "healthy", LastChecked: at(2), CertExpires: at(200)},
+				{BaseURI: "https://a2/", HealthStatus: "unchecked"},
+			}},
+			{EntityID: "https://b.example.com", Organization: "A Org", Servers: []ServerView{
+				{BaseURI: "https://b1/", HealthStatus: "unchecked"},
+			}},
+			{EntityID: "https://c.example.com", Organization: "C Org", Servers: []ServerView{
+				{BaseURI: "https://c1/", HealthStatus: "unhealthy", LastChecked: at(1), CertExpires: at(100)},
+			}},
+			{EntityID: "https://d.example.com", Organization: "A Org", Servers: []ServerView{
+				{BaseURI: "https://d1/", HealthStatus: "healthy", LastChecked: at(3), CertExpires: at(300)},
+			}},
+		}
+	}
+
+	// order lists the servers of each entity, entity by entity
+	order := func(entities []EntityView) []string {
+		var result []string
+		for _, ev := range entities {
+			uris := make([]string, len(ev.Servers))
+			for i, sv := range ev.Servers {
+				uris[i] = sv.BaseURI
+			}
+			result = append(result, ev.EntityID+" "+strings.Join(uris, ","))
+		}
+		return result
+	}
+
+	tests := []struct {
+		sortBy string
+		want   []string
+	}{
+		{
+			// By organization name, then entity ID, servers as listed
+			sortBy: SortOrganization,
+			want: []string{
+				"https://b.example.com https://b1/",
+				"https://d.example.com https://d1/",
+				"https://a.example.com https://a1/,https://a2/",
+				"https://c.example.com https://c1/",
+			},
+		},
+		{
+			// Never checked first, the first server decides the entity order
+			// and ties fall back to organization name
+			sortBy: SortLastChecked,
+			want: []string{
+				"https://b.example.com https://b1/",
+				"https://a.example.com https://a2/,https://a1/",
+				"https://c.example.com https://c1/",
+				"https://d.example.com https://d1/",
+			},
+		},
+		{
+			// Without a certificate last
+			sortBy: SortExpiry,
+			want: []string{
+				"https://c.example.com https://c1/",
+				"https://a.example.com https://a1/,https://a2/",
+				"https://d.example.com https://d1/",
+				"https://b.example.com https://b1/",
+			},
+		},
+		{
+			sortBy: SortStatus,
+			want: []string{
+				"https://c.example.com https://c1/",
+				"https://b.example.com https://b1/",
+				"https://a.example.com https://a2/,https://a1/",
+				"https://d.example.com https://d1/",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.sortBy, func(t *testing.T) {
+			got := entities()
+			sortEntities(got, tt.sortBy)
+			if !reflect.DeepEqual(order(got), tt.want) {
+				t.Errorf("sortEntities(%s) =\n%s\nwant\n%s", tt.sortBy,
+					strings.Join(order(got), "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -4,14 +4,35 @@ package web
 import (
 	"embed"
 	"encoding/json"
+	"errors"
 	"html/template"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/url"
 	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/archive"
+	"github.com/joesiltberg/matfmonitor/internal/calendar"
+	"github.com/joesiltberg/matfmonitor/internal/candidate"
+	"github.com/joesiltberg/matfmonitor/internal/checker"
+	"github.com/joesiltberg/matfmonitor/internal/clients"
+	"github.com/joesiltberg/matfmonitor/internal/issuer"
+	"github.com/joesiltberg/matfmonitor/internal/lint"
+	"github.com/joesiltberg/matfmonitor/internal/onboard"
+	"github.com/joesiltberg/matfmonitor/internal/pins"
+	"github.com/joesiltberg/matfmonitor/internal/report"
+	"github.com/joesiltberg/matfmonitor/internal/rotation"
+	"github.com/joesiltberg/matfmonitor/internal/source"
+	"github.com/joesiltberg/matfmonitor/internal/stats"
 	"github.com/joesiltberg/matfmonitor/internal/store"
+	"github.com/joesiltberg/matfmonitor/internal/uptime"
 )
 
 //go:embed templates/*.html
@@ -30,8 +51,61 @@ type Handler struct {
 	priorityRequester   PriorityRequester
 	priorityMinInterval time.Duration
 	refreshInterval     time.Duration
+	validator           *candidate.Validator
+	sourceMonitor       *source.Monitor
+	serverTester        *onboard.Tester
+	stalePinAge         time.Duration
+	certExpiryWarning   time.Duration
+	live                *liveHub
+
+	// Statuses of all servers, kept up to date by ServerChecked while
+	// anyone is subscribed to /events, and reread when metadata changes
+	liveStatusesMu sync.Mutex
+	liveMetadata   *fedtls.Metadata
+	liveStatuses   map[store.ServerKey]*store.ServerStatus
+
+	// Pins removed by the latest metadata change, cached by version
+	removedPinsMu      sync.Mutex
+	removedPinsVersion int64
+	removedPins        map[store.ServerKey][]string
+
+	// Lint issues of the current metadata, cached until it is replaced
+	lintMu       sync.Mutex
+	lintMetadata *fedtls.Metadata
+	lintAt       time.Time
+	lintIssues   []lint.Issue
+
+	// Changes in each archived metadata version, which never change
+	versionDiffsMu sync.Mutex
+	versionDiffs   map[int64]archive.VersionDiff
+
+	// Server uptimes for badges and organization pages, cached for
+	// badgeMaxAge
+	uptimesMu sync.Mutex
+	uptimesAt time.Time
+	uptimes   map[store.ServerKey]uptime.Uptime
 }
 
+// How long certificate changes are shown on the status page
+const certChangeWindow = 7 * 24 * time.Hour
+
+// How long before expiry a server certificate is warned about unless
+// SetCertExpiryWarning is called
+const defaultCertExpiryWarning = 14 * 24 * time.Hour
+
+// How long after a metadata change the pins it removed are warned about
+const removedPinWindow = 7 * 24 * time.Hour
+
+// How long lint issues are reused for the same metadata version. Lint
+// checks issuer expiry, so the issues change over time too.
+const lintMaxAge = time.Hour
+
+// Number of metadata versions shown per page of the history page
+const historyVersions = 50
+
+// maxCandidateSize limits the size of uploaded candidate metadata
+const maxCandidateSize = 32 << 20
+
 // NewHandler creates a new Handler
 func NewHandler(store *store.Store, metadataStore *fedtls.MetadataStore, priorityRequester PriorityRequester, priorityMinInterval time.Duration, refreshInterval time.Duration) (*Handler, error) {
 	tmpl, err := template.ParseFS(templateFS, "templates/*.html")
@@ -46,15 +120,52 @@ func NewHandler(store *store.Store, metadataStore *fedtls.MetadataStore, priorit
 		priorityRequester:   priorityRequester,
 		priorityMinInterval: priorityMinInterval,
 		refreshInterval:     refreshInterval,
+		live:                newLiveHub(),
+		stalePinAge:         rotation.DefaultStaleAfter,
+		certExpiryWarning:   defaultCertExpiryWarning,
 	}, nil
 }
 
+// SetStalePinAge sets how long a previously deployed pin may go without
+// matching before it is shown as stale. Must be called before the handler
+// is used.
+func (h *Handler) SetStalePinAge(d time.Duration) {
+	h.stalePinAge = d
+}
+
+// SetCertExpiryWarning sets how long before expiry a server certificate
+// makes a badge show a warning, the same as for notifications. Must be
+// called before the handler is used.
+func (h *Handler) SetCertExpiryWarning(d time.Duration) {
+	h.certExpiryWarning = d
+}
+
+// EnableCandidateUpload makes the candidate metadata upload form available
+// at /validate. Must be called before the handler is used.
+func (h *Handler) EnableCandidateUpload(validator *candidate.Validator) {
+	h.validator = validator
+}
+
+// SetMetadataSource shows the state of the metadata source on the status
+// page. Must be called before the handler is used.
+func (h *Handler) SetMetadataSource(monitor *source.Monitor) {
+	h.sourceMonitor = monitor
+}
+
+// EnableServerTest makes the pre-onboarding server test available at
+// /server-test and /api/server-test. Must be called before the handler is
+// used.
+func (h *Handler) EnableServerTest(tester *onboard.Tester) {
+	h.serverTester = tester
+}
+
 // EntityView represents an entity for display
 type EntityView struct {
 	EntityID            string
 	Organization        string
 	OrganizationID      string
 	OrganizationDisplay string
+	OrganizationKey     string // organization ID, or name for entities without one
 	HealthStatus        string // "healthy", "unhealthy", or "unchecked"
 	Servers             []ServerView
 }
@@ -72,7 +183,48 @@ type ServerView struct {
 	CertCN               string
 	CertExpires          *time.Time
 	CertExpiresFormatted string
+	CertChanged          string // date of a recent certificate change, if any
+	RotationStatus       string // see rotation.Status
+	RotationWarnings     []string
 	CanRequestCheck      bool
+	Uptime               string // formatted percentage, only on organization pages
+
+	// Set when the deployed certificate doesn't match any published pin
+	PinMismatch     bool
+	ObservedPin     string   // as it should appear in the server's pins
+	PublishedPins   []string // as they appear in metadata
+	PinnedElsewhere []string // other places the observed certificate is pinned
+}
+
+// CertChangeView represents a recent certificate change for display
+type CertChangeView struct {
+	EntityID       string
+	Organization   string
+	BaseURI        string
+	ChangedAt      string
+	OldFingerprint string
+	NewFingerprint string
+	OldCN          string
+	NewCN          string
+	OldExpires     string
+	NewExpires     string
+	Pinned         bool
+}
+
+// MetadataSourceView represents the state of the metadata source for display
+type MetadataSourceView struct {
+	URL         string
+	LastSuccess string
+	LastChange  string
+	Verified    bool
+	VerifyError string
+	KeyID       string
+	Algorithm   string
+	IssuedAt    string
+	Expires     string
+	CacheAge    string
+	Stale       bool
+	StaleReason string
 }
 
 // PageData is the data passed to the template
@@ -81,7 +233,95 @@ type PageData struct {
 	HealthyCount   int
 	UnhealthyCount int
 	UncheckedCount int
+	MetadataIssues []lint.Issue
+	CertChanges    []CertChangeView
+	UnpinnedCount  int
+	MetadataSource *MetadataSourceView
+	Degraded       bool
 	GeneratedAt    string
+	CanValidate    bool
+	CanTestServer  bool
+
+	// Filtering and sorting of the listed servers
+	Filter        PageFilter
+	Tags          []string
+	Organizations []OrganizationOption
+	ShownCount    int
+	TotalCount    int
+}
+
+// OrganizationOption is an organization to filter the status page by
+type OrganizationOption struct {
+	Key  string // organization ID, or name for entities without one
+	Name string
+}
+
+// VersionView represents an archived metadata version for display
+type VersionView struct {
+	ID         int64
+	Hash       string
+	ArchivedAt string
+	First      bool
+	Changes    []ChangeView
+}
+
+// ChangeView represents a change between metadata versions for display
+type ChangeView struct {
+	Type string
+	Text string
+}
+
+// HistoryPageData is the data passed to the metadata history template
+type HistoryPageData struct {
+	Versions []VersionView
+	Query    string
+	Before   int64 // the page lists versions older than this, 0 for the newest
+	Older    int64 // before parameter of the next page, 0 if this is the last
+}
+
+// IssuerGroupView is the issuer certificates of an organization
+type IssuerGroupView struct {
+	Organization   string
+	OrganizationID string
+	Certificates   []IssuerView
+}
+
+// IssuerView represents an issuer certificate for display
+type IssuerView struct {
+	EntityID    string
+	Subject     string
+	Issuer      string
+	SelfSigned  bool
+	NotAfter    string
+	DaysLeft    int
+	Status      string // "healthy", "expiring", or "unhealthy"
+	Key         string
+	SigAlg      string
+	Fingerprint string
+	Problems    []string
+}
+
+// IssuersPageData is the data passed to the issuer inventory template
+type IssuersPageData struct {
+	Groups        []IssuerGroupView
+	Total         int
+	ExpiringCount int
+	ProblemCount  int
+	ExpiryWarning int
+	GeneratedAt   string
+}
+
+// ValidatePageData is the data passed to the candidate upload template
+type ValidatePageData struct {
+	Report *candidate.Report
+	Error  string
+}
+
+// ServerTestPageData is the data passed to the server test template
+type ServerTestPageData struct {
+	BaseURI string
+	Report  *onboard.Report
+	Error   string
 }
 
 // ServeHTTP handles the HTTP request
@@ -91,12 +331,82 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if r.URL.Path == "/events" {
+		h.handleEvents(w, r)
+		return
+	}
+
+	if r.URL.Path == "/report" || r.URL.Path == "/report.md" {
+		h.handleReport(w, r)
+		return
+	}
+
+	if r.URL.Path == "/clients" || r.URL.Path == "/api/clients" {
+		h.handleClients(w, r)
+		return
+	}
+
+	if r.URL.Path == "/shared" || r.URL.Path == "/api/shared" {
+		h.handleSharing(w, r)
+		return
+	}
+
+	if r.URL.Path == "/tags" || r.URL.Path == "/api/tags" {
+		h.handleTags(w, r)
+		return
+	}
+
+	if r.URL.Path == "/organizations" || r.URL.Path == "/organization" {
+		h.handleOrganizations(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/badge/") {
+		h.handleBadge(w, r)
+		return
+	}
+
+	if r.URL.Path == "/api/servers" {
+		h.handleServers(w, r)
+		return
+	}
+
+	if r.URL.Path == "/stats" || r.URL.Path == "/api/stats" {
+		h.handleStats(w, r)
+		return
+	}
+
+	if r.URL.Path == "/calendar" || r.URL.Path == "/calendar.ics" {
+		h.handleCalendar(w, r)
+		return
+	}
+
+	if r.URL.Path == "/issuers" {
+		h.handleIssuers(w, r)
+		return
+	}
+
+	if r.URL.Path == "/metadata/history" {
+		h.handleMetadataHistory(w, r)
+		return
+	}
+
+	if r.URL.Path == "/validate" && h.validator != nil {
+		h.handleValidate(w, r)
+		return
+	}
+
+	if (r.URL.Path == "/server-test" || r.URL.Path == "/api/server-test") && h.serverTester != nil {
+		h.handleServerTest(w, r)
+		return
+	}
+
 	if r.URL.Path != "/" {
 		http.NotFound(w, r)
 		return
 	}
 
-	data := h.buildPageData()
+	data := h.buildPageData(parsePageFilter(r.URL.Query()))
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	if err := h.template.ExecuteTemplate(w, "status.html", data); err != nil {
@@ -122,10 +432,727 @@ func (h *Handler) handleRequestCheck(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *Handler) buildPageData() PageData {
-	data := PageData{
+// handleReport renders a digest report for the period ending now, as HTML
+// or as Markdown for /report.md
+func (h *Handler) handleReport(w http.ResponseWriter, r *http.Request) {
+	period := report.Daily
+	if r.URL.Query().Get("period") == report.Weekly {
+		period = report.Weekly
+	}
+
+	rep, err := report.Generate(h.store, h.metadataStore.GetMetadata(), period, time.Now())
+	if err != nil {
+		log.Printf("Error generating report: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	var body string
+	if r.URL.Path == "/report.md" {
+		body, err = rep.Markdown()
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	} else {
+		body, err = rep.HTML()
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	if err != nil {
+		log.Printf("Error rendering report: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(body))
+}
+
+// handleClients shows the client pin inventory, as HTML or as JSON for
+// /api/clients
+func (h *Handler) handleClients(w http.ResponseWriter, r *http.Request) {
+	inventory := clients.Build(h.metadataStore.GetMetadata())
+
+	if r.URL.Path == "/api/clients" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(inventory)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.ExecuteTemplate(w, "clients.html", inventory); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// SharingPageData is the data passed to the shared certificates template
+type SharingPageData struct {
+	*pins.Sharing
+	GeneratedAt string
+}
+
+// handleSharing shows certificates and pins shared across the federation,
+// as HTML or as JSON for /api/shared
+func (h *Handler) handleSharing(w http.ResponseWriter, r *http.Request) {
+	statuses, err := h.store.GetAllStatuses()
+	if err != nil {
+		log.Printf("Error getting statuses: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	sharing := pins.AnalyzeSharing(h.metadataStore.GetMetadata(), statuses)
+
+	if r.URL.Path == "/api/shared" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(sharing)
+		return
+	}
+
+	data := SharingPageData{
+		Sharing:     sharing,
+		GeneratedAt: time.Now().Format("2006-01-02 15:04:05 MST"),
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.ExecuteTemplate(w, "shared.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// StatRow is one value of a statistic and its share of the total
+type StatRow struct {
+	Label   string
+	Count   int
+	Percent int
+}
+
+// StatSection is one statistic on the statistics page
+type StatSection struct {
+	Title string
+	Note  string
+	Rows  []StatRow
+}
+
+// StatsPageData is the data passed to the statistics template
+type StatsPageData struct {
+	*stats.Stats
+	Sections    []StatSection
+	GeneratedAt string
+}
+
+// statSection converts counts to rows with percentages of their sum
+func statSection(title, note string, counts []stats.Count) StatSection {
+	total := 0
+	for _, c := range counts {
+		total += c.Count
+	}
+	section := StatSection{Title: title, Note: note}
+	for _, c := range counts {
+		row := StatRow{Label: c.Label, Count: c.Count}
+		if total > 0 {
+			row.Percent = c.Count * 100 / total
+		}
+		section.Rows = append(section.Rows, row)
+	}
+	return section
+}
+
+// bucketCounts converts buckets to counts, keeping their order
+func bucketCounts(buckets []stats.Bucket) []stats.Count {
+	counts := make([]stats.Count, 0, len(buckets))
+	for _, b := range buckets {
+		counts = append(counts, stats.Count{Label: b.Label, Count: b.Count})
+	}
+	return counts
+}
+
+// handleStats shows federation-wide TLS and certificate statistics, as HTML
+// or as JSON for /api/stats
+func (h *Handler) handleStats(w http.ResponseWriter, r *http.Request) {
+	statuses, err := h.store.GetAllStatuses()
+	if err != nil {
+		log.Printf("Error getting statuses: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	st := stats.Compute(h.metadataStore.GetMetadata(), statuses, time.Now())
+
+	if r.URL.Path == "/api/stats" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(st)
+		return
+	}
+
+	data := StatsPageData{
+		Stats: st,
+		Sections: []StatSection{
+			statSection("Days to expiry", "Deployed server certificates by days left", bucketCounts(st.DaysToExpiry)),
+			statSection("Failure reasons", "Why unhealthy servers fail their check", st.FailureReasons),
+			statSection("TLS versions", "Negotiated protocol version", st.TLSVersions),
+			statSection("Cipher suites", "Negotiated cipher suite", st.CipherSuites),
+			statSection("Key types", "Public key of the server certificate", st.KeyTypes),
+			statSection("Issuing CAs", "Issuer of the server certificate", st.Issuers),
+			statSection("Certificate lifetimes", "Validity period of the server certificate", bucketCounts(st.Lifetimes)),
+		},
 		GeneratedAt: time.Now().Format("2006-01-02 15:04:05 MST"),
 	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.ExecuteTemplate(w, "stats.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// CalendarEntryView represents a certificate on the expiry calendar
+type CalendarEntryView struct {
+	Kind         string
+	EntityID     string
+	Organization string
+	BaseURI      string
+	Subject      string
+	Expires      string
+	DaysLeft     int
+	Status       string // "healthy", "expiring", or "unhealthy"
+}
+
+// CalendarPageData is the data passed to the expiry calendar template
+type CalendarPageData struct {
+	Entries       []CalendarEntryView
+	Organizations []calendar.Organization
+	Org           string
+	FeedURL       string
+	ExpiryWarning int
+	GeneratedAt   string
+}
+
+// handleCalendar lists server and issuer certificates by expiry, as HTML or
+// as an iCalendar feed for /calendar.ics. The org parameter limits both to
+// one organization.
+func (h *Handler) handleCalendar(w http.ResponseWriter, r *http.Request) {
+	statuses, err := h.store.GetAllStatuses()
+	if err != nil {
+		log.Printf("Error getting statuses: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	all := calendar.Build(h.metadataStore.GetMetadata(), statuses)
+	org := r.URL.Query().Get("org")
+	entries := calendar.Filter(all, org)
+	now := time.Now()
+
+	feedURL := "/calendar.ics"
+	if org != "" {
+		feedURL += "?org=" + url.QueryEscape(org)
+	}
+
+	if r.URL.Path == "/calendar.ics" {
+		name := "Federation certificate expiry"
+		for _, o := range calendar.Organizations(all) {
+			if o.Key() == org && o.Name != "" {
+				name = o.Name + " certificate expiry"
+			}
+		}
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.Write([]byte(calendar.ICS(entries, name, now)))
+		return
+	}
+
+	data := CalendarPageData{
+		Organizations: calendar.Organizations(all),
+		Org:           org,
+		FeedURL:       feedURL,
+		ExpiryWarning: int(issuer.DefaultExpiryWarning.Hours() / 24),
+		GeneratedAt:   now.Format("2006-01-02 15:04:05 MST"),
+	}
+	for _, e := range entries {
+		ev := CalendarEntryView{
+			Kind:         e.Kind,
+			EntityID:     e.EntityID,
+			Organization: e.Organization,
+			BaseURI:      e.BaseURI,
+			Subject:      e.Subject,
+			Expires:      e.Expires.Format("2006-01-02"),
+			DaysLeft:     int(e.Expires.Sub(now).Hours() / 24),
+			Status:       "healthy",
+		}
+		switch {
+		case now.After(e.Expires):
+			ev.Status = "unhealthy"
+		case e.Expires.Sub(now) <= issuer.DefaultExpiryWarning:
+			ev.Status = "expiring"
+		}
+		data.Entries = append(data.Entries, ev)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.ExecuteTemplate(w, "calendar.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// handleIssuers shows all issuer certificates in metadata grouped by
+// organization
+func (h *Handler) handleIssuers(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	data := IssuersPageData{
+		ExpiryWarning: int(issuer.DefaultExpiryWarning.Hours() / 24),
+		GeneratedAt:   now.Format("2006-01-02 15:04:05 MST"),
+	}
+
+	groups := make(map[string]*IssuerGroupView)
+	var order []string
+	for _, cert := range issuer.Inventory(h.metadataStore.GetMetadata()) {
+		key := cert.Organization + "|" + cert.OrganizationID
+		group, ok := groups[key]
+		if !ok {
+			group = &IssuerGroupView{Organization: cert.Organization, OrganizationID: cert.OrganizationID}
+			if group.Organization == "" {
+				group.Organization = "Unknown"
+			}
+			groups[key] = group
+			order = append(order, key)
+		}
+
+		iv := IssuerView{
+			EntityID:    cert.EntityID,
+			Subject:     cert.Subject,
+			Issuer:      cert.Issuer,
+			SelfSigned:  cert.SelfSigned,
+			SigAlg:      cert.SigAlg,
+			Fingerprint: cert.Fingerprint,
+			Problems:    cert.Weaknesses,
+			Status:      "healthy",
+		}
+		switch {
+		case cert.ParseError != "":
+			iv.Subject = "Unparseable certificate"
+			iv.Problems = append(iv.Problems, cert.ParseError)
+		case cert.Expired(now):
+			iv.Problems = append(iv.Problems, "not valid at this time")
+		case cert.ExpiresWithin(now, issuer.DefaultExpiryWarning):
+			iv.Status = "expiring"
+			data.ExpiringCount++
+		}
+		if cert.ParseError == "" {
+			iv.NotAfter = cert.NotAfter.Format("2006-01-02")
+			iv.DaysLeft = int(cert.NotAfter.Sub(now).Hours() / 24)
+			iv.Key = cert.KeyType
+			if cert.KeyBits > 0 {
+				iv.Key += " " + strconv.Itoa(cert.KeyBits)
+			}
+		}
+		if len(iv.Problems) > 0 {
+			iv.Status = "unhealthy"
+			data.ProblemCount++
+		}
+
+		group.Certificates = append(group.Certificates, iv)
+		data.Total++
+	}
+	for _, key := range order {
+		data.Groups = append(data.Groups, *groups[key])
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.ExecuteTemplate(w, "issuers.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// handleMetadataHistory lists archived metadata versions with their
+// changes, a page at a time with the before parameter. With the q parameter
+// only changes mentioning it are shown, searching all older versions until
+// the page is full.
+func (h *Handler) handleMetadataHistory(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	data := HistoryPageData{Query: strings.TrimSpace(query.Get("q"))}
+	if before, err := strconv.ParseInt(query.Get("before"), 10, 64); err == nil && before > 0 {
+		data.Before = before
+	}
+
+	// One more than a page tells if there are older versions
+	limit := historyVersions + 1
+	if data.Query != "" {
+		limit = -1
+	}
+	versions, err := h.store.GetMetadataVersions(data.Before, limit)
+	if err != nil {
+		log.Printf("Error reading metadata history: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	needle := strings.ToLower(data.Query)
+	for i, version := range versions {
+		if len(data.Versions) == historyVersions {
+			data.Older = versions[i-1].ID
+			break
+		}
+		vd, err := h.getVersionDiff(version)
+		if err != nil {
+			log.Printf("Error reading metadata history: %v", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+
+		vv := VersionView{
+			ID:         vd.Version.ID,
+			Hash:       vd.Version.Hash[:12],
+			ArchivedAt: vd.Version.ArchivedAt.Format("2006-01-02 15:04:05"),
+			First:      vd.First,
+		}
+		for _, c := range vd.Changes {
+			text := c.String()
+			if needle != "" && !strings.Contains(strings.ToLower(text), needle) {
+				continue
+			}
+			vv.Changes = append(vv.Changes, ChangeView{Type: string(c.Type), Text: text})
+		}
+		if needle != "" && len(vv.Changes) == 0 {
+			continue
+		}
+		data.Versions = append(data.Versions, vv)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.ExecuteTemplate(w, "history.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// getVersionDiff returns the changes in an archived metadata version,
+// cached by version since they never change
+func (h *Handler) getVersionDiff(version *store.MetadataVersion) (archive.VersionDiff, error) {
+	h.versionDiffsMu.Lock()
+	defer h.versionDiffsMu.Unlock()
+	if vd, ok := h.versionDiffs[version.ID]; ok {
+		return vd, nil
+	}
+
+	vd, err := archive.Changes(h.store, version)
+	if err != nil {
+		return vd, err
+	}
+	if h.versionDiffs == nil {
+		h.versionDiffs = make(map[int64]archive.VersionDiff)
+	}
+	h.versionDiffs[version.ID] = vd
+	return vd, nil
+}
+
+// handleValidate shows the candidate metadata upload form, and on POST
+// checks all servers in the uploaded candidate and shows the report
+func (h *Handler) handleValidate(w http.ResponseWriter, r *http.Request) {
+	var data ValidatePageData
+
+	if r.Method == http.MethodPost {
+		data.Report, data.Error = h.validateUpload(w, r)
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.ExecuteTemplate(w, "validate.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) validateUpload(w http.ResponseWriter, r *http.Request) (*candidate.Report, string) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxCandidateSize)
+	file, _, err := r.FormFile("metadata")
+	if err != nil {
+		return nil, "No metadata file uploaded"
+	}
+	defer file.Close()
+	content, err := io.ReadAll(file)
+	if err != nil {
+		return nil, "Failed to read uploaded file"
+	}
+
+	statuses, err := h.store.GetAllStatuses()
+	if err != nil {
+		log.Printf("Error getting statuses: %v", err)
+		return nil, "Failed to read current statuses"
+	}
+
+	// Checking all servers takes longer than the server's write timeout
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Failed to clear write deadline: %v", err)
+	}
+
+	report, err := h.validator.Validate(content, h.metadataStore.GetMetadata(), statuses)
+	if err != nil {
+		return nil, "Invalid candidate metadata: " + err.Error()
+	}
+	return report, ""
+}
+
+// handleServerTest shows the server test form, and on POST tests the given
+// base URI. /api/server-test returns the report as JSON.
+func (h *Handler) handleServerTest(w http.ResponseWriter, r *http.Request) {
+	api := r.URL.Path == "/api/server-test"
+	data := ServerTestPageData{BaseURI: strings.TrimSpace(r.FormValue("base_uri"))}
+	status := http.StatusOK
+
+	if r.Method == http.MethodPost || (api && data.BaseURI != "") {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+
+		// The handshake can take as long as the server's write timeout
+		if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+			log.Printf("Failed to clear write deadline: %v", err)
+		}
+
+		data.Report, err = h.serverTester.Test(data.BaseURI, client)
+		switch {
+		case errors.Is(err, onboard.ErrRateLimited):
+			status = http.StatusTooManyRequests
+			data.Error = err.Error()
+		case err != nil:
+			status = http.StatusBadRequest
+			data.Error = err.Error()
+		}
+	} else if api {
+		status = http.StatusBadRequest
+		data.Error = "base_uri is required"
+	}
+
+	if api {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		if data.Error != "" {
+			json.NewEncoder(w).Encode(map[string]string{"error": data.Error})
+		} else {
+			json.NewEncoder(w).Encode(data.Report)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := h.template.ExecuteTemplate(w, "servertest.html", data); err != nil {
+		log.Printf("Error executing template: %v", err)
+	}
+}
+
+func buildMetadataSourceView(status source.Status) *MetadataSourceView {
+	formatTime := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format("2006-01-02 15:04:05")
+	}
+	view := &MetadataSourceView{
+		URL:         status.URL,
+		LastSuccess: formatTime(status.LastSuccess),
+		LastChange:  formatTime(status.LastChange),
+		Verified:    status.Verified,
+		VerifyError: status.VerifyError,
+		KeyID:       status.KeyID,
+		Algorithm:   status.Algorithm,
+		IssuedAt:    formatTime(status.IssuedAt),
+		Expires:     formatTime(status.Expires),
+		Stale:       status.Stale,
+		StaleReason: status.StaleReason,
+	}
+	if status.CacheModified != nil {
+		view.CacheAge = status.CacheAge.Truncate(time.Minute).String()
+	}
+	return view
+}
+
+// addPinMismatch explains a server's certificate not matching its pins:
+// the pin to publish, the published pins and where else the certificate is
+// pinned
+func addPinMismatch(sv *ServerView, server fedtls.Server, fingerprint string, pinIndex *pins.Index) {
+	sv.PinMismatch = true
+	observed, _ := json.Marshal(fedtls.Pin{Alg: checker.PinAlg, Digest: fingerprint})
+	sv.ObservedPin = string(observed)
+	for _, pin := range server.Pins {
+		published, _ := json.Marshal(pin)
+		sv.PublishedPins = append(sv.PublishedPins, string(published))
+	}
+	for _, use := range pinIndex.Lookup(fingerprint) {
+		if use.EntityID == sv.EntityID && use.BaseURI == sv.BaseURI {
+			continue
+		}
+		sv.PinnedElsewhere = append(sv.PinnedElsewhere, use.String())
+	}
+}
+
+// getRemovedPins returns the pins removed from each server by the latest
+// metadata change, if it was within removedPinWindow
+func (h *Handler) getRemovedPins(metadata *fedtls.Metadata) map[store.ServerKey][]string {
+	versions, err := h.store.GetMetadataVersions(0, 1)
+	if err != nil || len(versions) == 0 {
+		return nil
+	}
+	latest := versions[0]
+	if time.Since(latest.ArchivedAt) > removedPinWindow {
+		return nil
+	}
+
+	h.removedPinsMu.Lock()
+	defer h.removedPinsMu.Unlock()
+	if h.removedPins != nil && h.removedPinsVersion == latest.ID {
+		return h.removedPins
+	}
+
+	removed := make(map[store.ServerKey][]string)
+	previous, err := h.store.GetPreviousMetadataVersion(latest.ID)
+	if err != nil {
+		log.Printf("Error getting previous metadata version: %v", err)
+		return nil
+	}
+	if previous != nil {
+		if parsed, err := archive.Parse(previous); err != nil {
+			log.Printf("Error parsing previous metadata version: %v", err)
+		} else {
+			removed = rotation.RemovedPins(parsed, metadata)
+		}
+	}
+	h.removedPinsVersion = latest.ID
+	h.removedPins = removed
+	return removed
+}
+
+// getMetadataIssues returns the lint issues of the metadata, linted once
+// per metadata version and lintMaxAge. The store replaces the metadata
+// rather than changing it, so the pointer identifies the version.
+func (h *Handler) getMetadataIssues(metadata *fedtls.Metadata) []lint.Issue {
+	h.lintMu.Lock()
+	defer h.lintMu.Unlock()
+	now := time.Now()
+	if h.lintMetadata == metadata && now.Sub(h.lintAt) < lintMaxAge {
+		return h.lintIssues
+	}
+	h.lintMetadata = metadata
+	h.lintAt = now
+	h.lintIssues = lint.Lint(metadata)
+	return h.lintIssues
+}
+
+// addCertChanges adds the recent certificate changes to the page data and
+// returns the date of the latest change of each server
+func (h *Handler) addCertChanges(data *PageData, metadata *fedtls.Metadata) map[store.ServerKey]string {
+	changed := make(map[store.ServerKey]string)
+	changes, err := h.store.GetCertChangesSince(time.Now().Add(-certChangeWindow))
+	if err != nil {
+		log.Printf("Error getting certificate changes: %v", err)
+		return changed
+	}
+
+	organizations := make(map[string]string)
+	for _, entity := range metadata.Entities {
+		if entity.Organization != nil {
+			organizations[entity.EntityID] = *entity.Organization
+		}
+	}
+	formatDate := func(t *time.Time) string {
+		if t == nil {
+			return ""
+		}
+		return t.Format("2006-01-02")
+	}
+
+	for _, c := range changes {
+		// Newest first, so the first change seen is the latest
+		if _, ok := changed[c.ServerKey]; !ok {
+			changed[c.ServerKey] = c.ChangedAt.Format("2006-01-02")
+		}
+		data.CertChanges = append(data.CertChanges, CertChangeView{
+			EntityID:       c.EntityID,
+			Organization:   organizations[c.EntityID],
+			BaseURI:        c.BaseURI,
+			ChangedAt:      c.ChangedAt.Format("2006-01-02 15:04"),
+			OldFingerprint: c.OldFingerprint,
+			NewFingerprint: c.NewFingerprint,
+			OldCN:          c.OldCN,
+			NewCN:          c.NewCN,
+			OldExpires:     formatDate(c.OldExpires),
+			NewExpires:     formatDate(c.NewExpires),
+			Pinned:         c.Pinned,
+		})
+		if !c.Pinned {
+			data.UnpinnedCount++
+		}
+	}
+	return changed
+}
+
+// healthStatus is the status of a server shown on the page: "healthy",
+// "unhealthy" or "unchecked". status may be nil for servers never checked.
+func healthStatus(status *store.ServerStatus) string {
+	switch {
+	case status == nil || status.IsHealthy == nil:
+		return "unchecked"
+	case *status.IsHealthy:
+		return "healthy"
+	}
+	return "unhealthy"
+}
+
+// entityHealthStatus is the status of an entity given its servers: any
+// unhealthy server makes it unhealthy, then any unchecked server makes it
+// unchecked
+func entityHealthStatus(hasUnhealthy, allChecked bool) string {
+	switch {
+	case hasUnhealthy:
+		return "unhealthy"
+	case !allChecked:
+		return "unchecked"
+	}
+	return "healthy"
+}
+
+// statusesByKey maps statuses by server
+func statusesByKey(statuses []*store.ServerStatus) map[store.ServerKey]*store.ServerStatus {
+	statusMap := make(map[store.ServerKey]*store.ServerStatus, len(statuses))
+	for _, s := range statuses {
+		statusMap[s.ServerKey] = s
+	}
+	return statusMap
+}
+
+// summarizeHealth counts the servers in metadata by health status and rolls
+// up the health status of each entity, by entity ID. The status page and
+// live updates both use it, so that they agree.
+func summarizeHealth(metadata *fedtls.Metadata, statuses map[store.ServerKey]*store.ServerStatus) (SummaryUpdate, map[string]string) {
+	var summary SummaryUpdate
+	entities := make(map[string]string, len(metadata.Entities))
+	for _, entity := range metadata.Entities {
+		hasUnhealthy, allChecked := false, true
+		for _, server := range entity.Servers {
+			switch healthStatus(statuses[store.ServerKey{EntityID: entity.EntityID, BaseURI: server.BaseURI}]) {
+			case "unchecked":
+				allChecked = false
+				summary.Unchecked++
+			case "healthy":
+				summary.Healthy++
+			default:
+				hasUnhealthy = true
+				summary.Unhealthy++
+			}
+		}
+		entities[entity.EntityID] = entityHealthStatus(hasUnhealthy, allChecked)
+	}
+	return summary, entities
+}
+
+// buildPageData builds the status page. Summary counts cover all servers,
+// filter selects and orders the entities and servers listed.
+func (h *Handler) buildPageData(filter PageFilter) PageData {
+	data := PageData{
+		Filter:        filter,
+		GeneratedAt:   time.Now().Format("2006-01-02 15:04:05 MST"),
+		CanValidate:   h.validator != nil,
+		CanTestServer: h.serverTester != nil,
+	}
+
+	if h.sourceMonitor != nil {
+		data.MetadataSource = buildMetadataSourceView(h.sourceMonitor.Status())
+		data.Degraded = data.MetadataSource.Stale
+	}
 
 	// Get metadata for entity info
 	metadata := h.metadataStore.GetMetadata()
@@ -133,6 +1160,8 @@ func (h *Handler) buildPageData() PageData {
 		return data
 	}
 
+	data.MetadataIssues = h.getMetadataIssues(metadata)
+
 	// Get all statuses from store
 	statuses, err := h.store.GetAllStatuses()
 	if err != nil {
@@ -140,15 +1169,26 @@ func (h *Handler) buildPageData() PageData {
 		return data
 	}
 
-	// Build a map of statuses by entity_id + base_uri
-	statusMap := make(map[string]*store.ServerStatus)
-	for _, s := range statuses {
-		key := s.EntityID + "|" + s.BaseURI
-		statusMap[key] = s
+	statusMap := statusesByKey(statuses)
+	summary, entityHealth := summarizeHealth(metadata, statusMap)
+	data.HealthyCount = summary.Healthy
+	data.UnhealthyCount = summary.Unhealthy
+	data.UncheckedCount = summary.Unchecked
+
+	changed := h.addCertChanges(&data, metadata)
+
+	seen, err := h.store.GetSeenFingerprints()
+	if err != nil {
+		log.Printf("Error getting seen fingerprints: %v", err)
 	}
+	removed := h.getRemovedPins(metadata)
+	pinIndex := pins.NewIndex(metadata)
+	now := time.Now()
 
 	// Build entity views from metadata
 	entityMap := make(map[string]*EntityView)
+	tags := make(map[string]bool)
+	organizations := make(map[string]bool)
 
 	for _, entity := range metadata.Entities {
 		if len(entity.Servers) == 0 {
@@ -170,12 +1210,10 @@ func (h *Handler) buildPageData() PageData {
 			Organization:        org,
 			OrganizationID:      orgID,
 			OrganizationDisplay: org,
+			OrganizationKey:     organizationKey(orgID, org),
 			Servers:             make([]ServerView, 0, len(entity.Servers)),
 		}
 
-		hasUnhealthy := false
-		allChecked := true
-
 		for _, server := range entity.Servers {
 			sv := ServerView{
 				EntityID: entity.EntityID,
@@ -183,7 +1221,7 @@ func (h *Handler) buildPageData() PageData {
 				Tags:     server.Tags,
 			}
 
-			key := entity.EntityID + "|" + server.BaseURI
+			key := store.ServerKey{EntityID: entity.EntityID, BaseURI: server.BaseURI}
 			if status, ok := statusMap[key]; ok {
 				sv.LastChecked = status.LastChecked
 				sv.ErrorMessage = status.ErrorMessage
@@ -201,52 +1239,72 @@ func (h *Handler) buildPageData() PageData {
 				if sv.CertExpires != nil {
 					sv.CertExpiresFormatted = sv.CertExpires.Format("2006-01-02")
 				}
+				sv.CertChanged = changed[status.ServerKey]
 
-				if status.IsHealthy == nil {
-					sv.HealthStatus = "unchecked"
-					allChecked = false
-					data.UncheckedCount++
-				} else if *status.IsHealthy {
-					sv.HealthStatus = "healthy"
-					sv.IsHealthy = true
-					data.HealthyCount++
-				} else {
-					sv.HealthStatus = "unhealthy"
-					sv.IsHealthy = false
-					hasUnhealthy = true
-					data.UnhealthyCount++
+				assessment := rotation.Assess(server, status.CertFingerprint, seen[status.ServerKey], removed[status.ServerKey], now, h.stalePinAge)
+				sv.RotationStatus = string(assessment.Status)
+				sv.RotationWarnings = assessment.Warnings
+
+				if status.CertFingerprint != "" && !checker.MatchesPin(status.CertFingerprint, server.Pins) {
+					addPinMismatch(&sv, server, status.CertFingerprint, pinIndex)
 				}
+
+				sv.HealthStatus = healthStatus(status)
+				sv.IsHealthy = sv.HealthStatus == "healthy"
 			} else {
 				sv.HealthStatus = "unchecked"
 				sv.CanRequestCheck = true
-				allChecked = false
-				data.UncheckedCount++
 			}
 
 			ev.Servers = append(ev.Servers, sv)
 		}
 
-		// Determine entity health status
-		if hasUnhealthy {
-			ev.HealthStatus = "unhealthy"
-		} else if !allChecked {
-			ev.HealthStatus = "unchecked"
-		} else {
-			ev.HealthStatus = "healthy"
+		ev.HealthStatus = entityHealth[entity.EntityID]
+
+		for _, sv := range ev.Servers {
+			for _, tag := range sv.Tags {
+				tags[tag] = true
+			}
+		}
+		if !organizations[ev.OrganizationKey] {
+			organizations[ev.OrganizationKey] = true
+			data.Organizations = append(data.Organizations, OrganizationOption{Key: ev.OrganizationKey, Name: org})
 		}
 
+		// Keep the servers matching the filter
+		data.TotalCount += len(ev.Servers)
+		if !filter.matchesEntity(ev) {
+			continue
+		}
+		shown := ev.Servers[:0]
+		for _, sv := range ev.Servers {
+			if filter.matchesServer(&sv, now) {
+				shown = append(shown, sv)
+			}
+		}
+		if len(shown) == 0 {
+			continue
+		}
+		ev.Servers = shown
+		data.ShownCount += len(shown)
+
 		entityMap[entity.EntityID] = ev
 	}
 
-	// Convert map to slice and sort by organization name
+	for tag := range tags {
+		data.Tags = append(data.Tags, tag)
+	}
+	sort.Strings(data.Tags)
+	sort.Slice(data.Organizations, func(i, j int) bool {
+		return data.Organizations[i].Name < data.Organizations[j].Name
+	})
+
+	// Convert map to slice and sort
 	entities := make([]EntityView, 0, len(entityMap))
 	for _, ev := range entityMap {
 		entities = append(entities, *ev)
 	}
-
-	sort.Slice(entities, func(i, j int) bool {
-		return entities[i].Organization < entities[j].Organization
-	})
+	sortEntities(entities, filter.Sort)
 
 	data.Entities = entities
 	return data
@@ -0,0 +1,235 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+// How often a comment is sent to keep idle event streams open through
+// proxies
+const liveKeepAlive = 30 * time.Second
+
+// How long browsers wait before reconnecting a lost event stream
+const liveRetry = 5 * time.Second
+
+// Events buffered per subscriber. A subscriber that falls further behind
+// misses events rather than holding up the scheduler.
+const liveBuffer = 64
+
+// ServerUpdate is sent to /events subscribers after each saved check
+type ServerUpdate struct {
+	EntityID           string `json:"entity_id"`
+	BaseURI            string `json:"base_uri"`
+	HealthStatus       string `json:"health_status"`
+	EntityHealthStatus string `json:"entity_health_status"`
+	LastChecked        string `json:"last_checked"`
+	ErrorMessage       string `json:"error_message,omitempty"`
+	CertCN             string `json:"cert_cn,omitempty"`
+	CertExpires        string `json:"cert_expires,omitempty"`
+}
+
+// SummaryUpdate is the summary counts of the status page, sent to /events
+// subscribers after each saved check
+type SummaryUpdate struct {
+	Healthy   int `json:"healthy"`
+	Unhealthy int `json:"unhealthy"`
+	Unchecked int `json:"unchecked"`
+}
+
+// liveEvent is a server-sent event
+type liveEvent struct {
+	name string
+	data []byte
+}
+
+// liveHub distributes events to the connected /events clients
+type liveHub struct {
+	mu          sync.Mutex
+	subscribers map[chan liveEvent]struct{}
+	closed      bool
+}
+
+func newLiveHub() *liveHub {
+	return &liveHub{subscribers: make(map[chan liveEvent]struct{})}
+}
+
+// subscribe returns a channel receiving events, closed when the hub is
+// closed. It returns nil if the hub is already closed.
+func (l *liveHub) subscribe() chan liveEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	ch := make(chan liveEvent, liveBuffer)
+	l.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (l *liveHub) unsubscribe(ch chan liveEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.subscribers[ch]; ok {
+		delete(l.subscribers, ch)
+		close(ch)
+	}
+}
+
+// active tells if anyone is subscribed
+func (l *liveHub) active() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.subscribers) > 0
+}
+
+// publish sends an event to all subscribers without blocking
+func (l *liveHub) publish(name string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Error encoding %s event: %v", name, err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ch := range l.subscribers {
+		select {
+		case ch <- liveEvent{name: name, data: data}:
+		default:
+		}
+	}
+}
+
+// close ends all event streams
+func (l *liveHub) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	for ch := range l.subscribers {
+		delete(l.subscribers, ch)
+		close(ch)
+	}
+}
+
+// ServerChecked is called by the scheduler after each saved health check,
+// and streams the result and the new summary counts to /events
+func (h *Handler) ServerChecked(previous, current *store.ServerStatus) {
+	h.liveStatusesMu.Lock()
+	defer h.liveStatusesMu.Unlock()
+	if !h.live.active() {
+		// Not kept up to date without subscribers
+		h.liveStatuses = nil
+		return
+	}
+	metadata := h.metadataStore.GetMetadata()
+	if len(metadata.Entities) == 0 {
+		return
+	}
+
+	// Read all statuses once per metadata version, then follow the checks.
+	// Metadata changes add and remove servers in the store.
+	if h.liveStatuses == nil || h.liveMetadata != metadata {
+		statuses, err := h.store.GetAllStatuses()
+		if err != nil {
+			log.Printf("Error getting statuses: %v", err)
+			return
+		}
+		h.liveStatuses = statusesByKey(statuses)
+		h.liveMetadata = metadata
+	}
+	h.liveStatuses[current.ServerKey] = current
+
+	update, summary := liveUpdates(metadata, h.liveStatuses, current)
+	// Servers removed from metadata aren't on the page
+	if update != nil {
+		h.live.publish("server", update)
+	}
+	h.live.publish("summary", summary)
+}
+
+// liveUpdates returns the update for the checked server, nil if it isn't in
+// metadata, and the summary counts
+func liveUpdates(metadata *fedtls.Metadata, statuses map[store.ServerKey]*store.ServerStatus, current *store.ServerStatus) (*ServerUpdate, SummaryUpdate) {
+	summary, entityHealth := summarizeHealth(metadata, statuses)
+	for _, entity := range metadata.Entities {
+		if entity.EntityID != current.EntityID {
+			continue
+		}
+		for _, server := range entity.Servers {
+			if server.BaseURI != current.BaseURI {
+				continue
+			}
+			update := &ServerUpdate{
+				EntityID:           current.EntityID,
+				BaseURI:            current.BaseURI,
+				HealthStatus:       healthStatus(current),
+				EntityHealthStatus: entityHealth[entity.EntityID],
+				ErrorMessage:       current.ErrorMessage,
+				CertCN:             current.CertCN,
+			}
+			if current.LastChecked != nil {
+				update.LastChecked = current.LastChecked.Format("2006-01-02 15:04:05")
+			}
+			if current.CertExpires != nil {
+				update.CertExpires = current.CertExpires.Format("2006-01-02")
+			}
+			return update, summary
+		}
+	}
+	return nil, summary
+}
+
+// CloseEvents ends all /events streams, so that a graceful shutdown doesn't
+// wait for them
+func (h *Handler) CloseEvents() {
+	h.live.close()
+}
+
+// handleEvents streams check results as server-sent events
+func (h *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Error clearing write deadline for event stream: %v", err)
+	}
+
+	events := h.live.subscribe()
+	if events == nil {
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	defer h.live.unsubscribe(events)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	fmt.Fprintf(w, "retry: %d\n\n", liveRetry.Milliseconds())
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	keepAlive := time.NewTicker(liveKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.name, event.data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
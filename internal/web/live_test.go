@@ -0,0 +1,110 @@
+package web
+
+import (
+	"testing"
+	"time"
+
+	"github.com/joesiltberg/bowness/fedtls"
+	"github.com/joesiltberg/matfmonitor/internal/store"
+)
+
+func TestLiveHubDropsWhenFull(t *testing.T) {
+	hub := newLiveHub()
+	events := hub.subscribe()
+	if !hub.active() {
+		t.Fatal("hub not active with a subscriber")
+	}
+
+	// A subscriber that doesn't read must not block publishing
+	for i := 0; i < liveBuffer+10; i++ {
+		hub.publish("summary", SummaryUpdate{Healthy: i})
+	}
+	if len(events) != liveBuffer {
+		t.Errorf("subscriber has %d events buffered, want %d", len(events), liveBuffer)
+	}
+
+	hub.unsubscribe(events)
+	if hub.active() {
+		t.Error("hub active after the last subscriber left")
+	}
+	// Unsubscribing twice doesn't close the channel again
+	hub.unsubscribe(events)
+}
+
+func TestLiveHubClose(t *testing.T) {
+	hub := newLiveHub()
+	events := hub.subscribe()
+	hub.close()
+
+	for range events {
+	}
+	if hub.active() {
+		t.Error("hub active after close")
+	}
+	if hub.subscribe() != nil {
+		t.Error("subscribe() after close returned a channel")
+	}
+	// Publishing and unsubscribing after close are harmless
+	hub.publish("summary", SummaryUpdate{})
+	hub.unsubscribe(events)
+}
+
+func TestSummarizeHealth(t *testing.T) {
+	healthy, unhealthy := true, false
+	now := time.Now()
+	status := func(entityID, baseURI string, isHealthy *bool) *store.ServerStatus {
+		s := &store.ServerStatus{ServerKey: store.ServerKey{EntityID: entityID, BaseURI: baseURI}, IsHealthy: isHealthy}
+		if isHealthy != nil {
+			s.LastChecked = &now
+		}
+		return s
+	}
+
+	metadata := &fedtls.Metadata{Entities: []fedtls.Entity{
+		{EntityID: "https://a.com", Servers: []fedtls.Server{{BaseURI: "https://a1/"}, {BaseURI: "https://a2/"}}},
+		{EntityID: "https://b.com", Servers: []fedtls.Server{{BaseURI: "https://b1/"}, {BaseURI: "https://b2/"}}},
+		{EntityID: "https://c.com", Servers: []fedtls.Server{{BaseURI: "https://c1/"}, {BaseURI: "https://c2/"}}},
+		{EntityID: "https://d.com"},
+	}}
+	statuses := statusesByKey([]*store.ServerStatus{
+		status("https://a.com", "https://a1/", &healthy),
+		status("https://a.com", "https://a2/", &healthy),
+		status("https://b.com", "https://b1/", &healthy),
+		status("https://b.com", "https://b2/", nil),
+		status("https://c.com", "https://c1/", &unhealthy),
+		// c2 has no status at all, a server removed from metadata has one
+		status("https://gone.com", "https://gone/", &unhealthy),
+	})
+
+	summary, entities := summarizeHealth(metadata, statuses)
+	if want := (SummaryUpdate{Healthy: 3, Unhealthy: 1, Unchecked: 2}); summary != want {
+		t.Errorf("summary = %+v, want %+v", summary, want)
+	}
+	for entityID, want := range map[string]string{
+		"https://a.com": "healthy",
+		"https://b.com": "unchecked",
+		"https://c.com": "unhealthy",
+		"https://d.com": "healthy",
+	} {
+		if entities[entityID] != want {
+			t.Errorf("entity %s = %q, want %q", entityID, entities[entityID], want)
+		}
+	}
+
+	// Live updates send the same counts as the status page
+	current := status("https://b.com", "https://b2/", &unhealthy)
+	statuses[current.ServerKey] = current
+	update, liveSummary := liveUpdates(metadata, statuses, current)
+	pageSummary, _ := summarizeHealth(metadata, statuses)
+	if liveSummary != pageSummary || liveSummary != (SummaryUpdate{Healthy: 3, Unhealthy: 2, Unchecked: 1}) {
+		t.Errorf("live summary = %+v, page summary = %+v", liveSummary, pageSummary)
+	}
+	if update == nil || update.HealthStatus != "unhealthy" || update.EntityHealthStatus != "unhealthy" {
+		t.Errorf("update = %+v", update)
+	}
+
+	// Servers not in metadata aren't on the page
+	if update, _ := liveUpdates(metadata, statuses, status("https://gone.com", "https://gone/", &healthy)); update != nil {
+		t.Errorf("update for a server not in metadata = %+v", update)
+	}
+}
@@ -0,0 +1,143 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/joesiltberg/matfmonitor/internal/store"
+	"github.com/joesiltberg/matfmonitor/internal/uptime"
+)
+
+// OrganizationView represents an organization and the rolled-up status of
+// its entities and servers
+type OrganizationView struct {
+	Key            string // organization ID, or name for entities without one
+	Name           string
+	OrganizationID string
+	HealthStatus   string // "healthy", "unhealthy", or "unchecked"
+	EntityCount    int
+	ServerCount    int
+	HealthyCount   int
+	UnhealthyCount int
+	UncheckedCount int
+	Uptime         string // formatted percentage, empty without history
+	Entities       []EntityView
+}
+
+// OrganizationsPageData is the data passed to the organization templates
+type OrganizationsPageData struct {
+	Organizations []OrganizationView
+	UptimeWindow  string
+	GeneratedAt   string
+}
+
+// formatUptime formats an uptime for display, empty if unknown
+func formatUptime(u uptime.Uptime) string {
+	if !u.Valid() {
+		return ""
+	}
+	return fmt.Sprintf("%.2f%%", u.Percent())
+}
+
+// formatWindow formats an uptime window for display
+func formatWindow(window time.Duration) string {
+	if days := int(window.Hours() / 24); days > 0 {
+		return fmt.Sprintf("%d days", days)
+	}
+	return window.String()
+}
+
+// buildOrganizations groups entities by organization ID, falling back to
+// the organization name, and rolls up their health and uptime. Servers in
+// the entities get their own uptime.
+func buildOrganizations(entities []EntityView, uptimes map[store.ServerKey]uptime.Uptime) []OrganizationView {
+	byKey := make(map[string]*OrganizationView)
+	totals := make(map[string]uptime.Uptime)
+	var keys []string
+	for _, ev := range entities {
+		key := ev.OrganizationKey
+		org := byKey[key]
+		if org == nil {
+			org = &OrganizationView{Key: key, Name: ev.OrganizationDisplay, OrganizationID: ev.OrganizationID}
+			byKey[key] = org
+			keys = append(keys, key)
+		}
+		org.EntityCount++
+		for i := range ev.Servers {
+			sv := &ev.Servers[i]
+			u := uptimes[store.ServerKey{EntityID: ev.EntityID, BaseURI: sv.BaseURI}]
+			sv.Uptime = formatUptime(u)
+			totals[key] = totals[key].Add(u)
+
+			org.ServerCount++
+			switch sv.HealthStatus {
+			case "healthy":
+				org.HealthyCount++
+			case "unhealthy":
+				org.UnhealthyCount++
+			default:
+				org.UncheckedCount++
+			}
+		}
+		org.Entities = append(org.Entities, ev)
+	}
+
+	organizations := make([]OrganizationView, 0, len(keys))
+	for _, key := range keys {
+		org := byKey[key]
+		org.HealthStatus = entityHealthStatus(org.UnhealthyCount > 0, org.UncheckedCount == 0)
+		org.Uptime = formatUptime(totals[key])
+		organizations = append(organizations, *org)
+	}
+	sort.SliceStable(organizations, func(i, j int) bool {
+		return organizations[i].Name < organizations[j].Name
+	})
+	return organizations
+}
+
+// handleOrganizations lists organizations with rolled-up health, or with
+// the org parameter on /organization, shows one organization's entities
+// and servers
+func (h *Handler) handleOrganizations(w http.ResponseWriter, r *http.Request) {
+	org := r.URL.Query().Get("org")
+	detail := r.URL.Path == "/organization"
+	if detail && org == "" {
+		http.Redirect(w, r, "/organizations", http.StatusFound)
+		return
+	}
+
+	uptimes, err := h.getUptimes(time.Now())
+	if err != nil {
+		log.Printf("Error computing uptime: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	filter := PageFilter{Sort: SortOrganization}
+	if detail {
+		filter.Organization = org
+	}
+	page := h.buildPageData(filter)
+	data := OrganizationsPageData{
+		Organizations: buildOrganizations(page.Entities, uptimes),
+		UptimeWindow:  formatWindow(uptime.DefaultWindow),
+		GeneratedAt:   page.GeneratedAt,
+	}
+
+	name := "organizations.html"
+	if detail {
+		if len(data.Organizations) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		name = "organization.html"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.ExecuteTemplate(w, name, data); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
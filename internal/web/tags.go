@@ -0,0 +1,164 @@
+package web
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ServerSummary is a server and its latest status in the tag overview and
+// the server API
+type ServerSummary struct {
+	EntityID     string     `json:"entity_id"`
+	Organization string     `json:"organization"`
+	BaseURI      string     `json:"base_uri"`
+	Tags         []string   `json:"tags"`
+	HealthStatus string     `json:"health_status"`
+	LastChecked  *time.Time `json:"last_checked,omitempty"`
+	ErrorMessage string     `json:"error_message,omitempty"`
+	CertCN       string     `json:"cert_cn,omitempty"`
+	CertExpires  *time.Time `json:"cert_expires,omitempty"`
+}
+
+// TagSummary is the health of the servers with a tag
+type TagSummary struct {
+	Tag       string          `json:"tag"`
+	Servers   int             `json:"servers"`
+	Healthy   int             `json:"healthy"`
+	Unhealthy int             `json:"unhealthy"`
+	Unchecked int             `json:"unchecked"`
+	Failing   []ServerSummary `json:"failing"`
+}
+
+// HealthyPercent is the share of checked servers that are healthy
+func (t TagSummary) HealthyPercent() int {
+	if checked := t.Healthy + t.Unhealthy; checked > 0 {
+		return t.Healthy * 100 / checked
+	}
+	return 0
+}
+
+// TagsPageData is the data passed to the tag overview template
+type TagsPageData struct {
+	Tags        []TagSummary
+	Untagged    int
+	GeneratedAt string
+}
+
+// serverSummary converts a server view for the tag overview and server API
+func serverSummary(ev *EntityView, sv *ServerView) ServerSummary {
+	tags := sv.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return ServerSummary{
+		EntityID:     ev.EntityID,
+		Organization: ev.OrganizationDisplay,
+		BaseURI:      sv.BaseURI,
+		Tags:         tags,
+		HealthStatus: sv.HealthStatus,
+		LastChecked:  sv.LastChecked,
+		ErrorMessage: sv.ErrorMessage,
+		CertCN:       sv.CertCN,
+		CertExpires:  sv.CertExpires,
+	}
+}
+
+// summarizeTags groups the servers of the entities by tag, most common tag
+// first. A server with several tags counts for each of them.
+func summarizeTags(entities []EntityView) ([]TagSummary, int) {
+	byTag := make(map[string]*TagSummary)
+	untagged := 0
+	for i := range entities {
+		ev := &entities[i]
+		for j := range ev.Servers {
+			sv := &ev.Servers[j]
+			if len(sv.Tags) == 0 {
+				untagged++
+			}
+			for _, tag := range sv.Tags {
+				summary := byTag[tag]
+				if summary == nil {
+					summary = &TagSummary{Tag: tag, Failing: []ServerSummary{}}
+					byTag[tag] = summary
+				}
+				summary.Servers++
+				switch sv.HealthStatus {
+				case "healthy":
+					summary.Healthy++
+				case "unhealthy":
+					summary.Unhealthy++
+					summary.Failing = append(summary.Failing, serverSummary(ev, sv))
+				default:
+					summary.Unchecked++
+				}
+			}
+		}
+	}
+
+	tags := make([]TagSummary, 0, len(byTag))
+	for _, summary := range byTag {
+		tags = append(tags, *summary)
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Servers != tags[j].Servers {
+			return tags[i].Servers > tags[j].Servers
+		}
+		return tags[i].Tag < tags[j].Tag
+	})
+	return tags, untagged
+}
+
+// handleTags shows the health of the servers offering each tag, as HTML or
+// as JSON for /api/tags. The tag parameter limits the result to one tag.
+func (h *Handler) handleTags(w http.ResponseWriter, r *http.Request) {
+	filter := PageFilter{Tag: r.URL.Query().Get("tag"), Sort: SortOrganization}
+	data := h.buildPageData(filter)
+	tags, untagged := summarizeTags(data.Entities)
+	if filter.Tag != "" {
+		// Servers with the tag have other tags too, keep only the requested
+		for _, summary := range tags {
+			if summary.Tag == filter.Tag {
+				tags = []TagSummary{summary}
+				break
+			}
+		}
+		untagged = 0
+	}
+
+	if r.URL.Path == "/api/tags" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(tags)
+		return
+	}
+
+	page := TagsPageData{
+		Tags:        tags,
+		Untagged:    untagged,
+		GeneratedAt: data.GeneratedAt,
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.template.ExecuteTemplate(w, "tags.html", page); err != nil {
+		log.Printf("Error executing template: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// handleServers lists servers and their latest status as JSON, filtered
+// and sorted with the same query parameters as the status page
+func (h *Handler) handleServers(w http.ResponseWriter, r *http.Request) {
+	data := h.buildPageData(parsePageFilter(r.URL.Query()))
+
+	servers := []ServerSummary{}
+	for i := range data.Entities {
+		ev := &data.Entities[i]
+		for j := range ev.Servers {
+			servers = append(servers, serverSummary(ev, &ev.Servers[j]))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(servers)
+}